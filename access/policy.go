@@ -0,0 +1,229 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+
+	"github.com/huhndev/gohenry/config"
+)
+
+// PowerLevelFunc looks up a user's power level in a room.
+type PowerLevelFunc func(roomID, userID string) (int, error)
+
+// Rules is one set of access rules. Nil lists in a room override inherit
+// the global value; an empty list clears it. The global deny list is the
+// exception: it applies in every room, and a room's deny_users only adds
+// to it.
+type Rules struct {
+	AllowServers  []string `json:"allow_servers,omitempty"`
+	AllowUsers    []string `json:"allow_users,omitempty"`
+	DenyUsers     []string `json:"deny_users,omitempty"`
+	MinPowerLevel *int     `json:"min_power_level,omitempty"`
+	DeniedReply   *string  `json:"denied_reply,omitempty"`
+}
+
+// policyFile is the format of HENRY_ACCESS_POLICY_FILE.
+type policyFile struct {
+	Rules
+	Rooms map[string]Rules `json:"rooms,omitempty"`
+}
+
+// Decision is the outcome of an access check.
+type Decision struct {
+	Allowed bool
+	Reason  string
+	// Reply is sent to denied users who addressed Henry. Empty means
+	// Henry stays silent.
+	Reply string
+}
+
+// Policy decides who may talk to Henry. Deny lists always win, then
+// allowlisted users, then server globs. A room can override any rule and
+// can require a minimum power level, e.g. to limit Henry to moderators.
+type Policy struct {
+	global     Rules
+	rooms      map[string]Rules
+	powerLevel PowerLevelFunc
+}
+
+// Load builds the policy from the file in cfg.AccessPolicyFile, or from
+// HENRY_ALLOWED_DOMAIN alone if no file is configured.
+func Load(cfg *config.Config, powerLevel PowerLevelFunc) (*Policy, error) {
+	file := policyFile{
+		Rules: Rules{AllowServers: []string{cfg.AllowedDomain}},
+	}
+
+	// A policy file that was configured but can't be read is an error:
+	// falling back to the domain alone could open Henry to more users.
+	if cfg.AccessPolicyFile != "" {
+		data, err := ioutil.ReadFile(cfg.AccessPolicyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read access policy: %v", err)
+		}
+		file = policyFile{}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse access policy: %v", err)
+		}
+	}
+
+	if file.DeniedReply == nil && cfg.AccessDeniedReply != "" {
+		reply := cfg.AccessDeniedReply
+		file.DeniedReply = &reply
+	}
+
+	return &Policy{
+		global:     file.Rules,
+		rooms:      file.Rooms,
+		powerLevel: powerLevel,
+	}, nil
+}
+
+// Check decides whether senderID may use Henry in roomID.
+func (p *Policy) Check(roomID, senderID string) Decision {
+	rules := p.rulesFor(roomID)
+	deny := func(reason string) Decision {
+		d := Decision{Reason: reason}
+		if rules.DeniedReply != nil {
+			d.Reply = *rules.DeniedReply
+		}
+		return d
+	}
+
+	if contains(p.global.DenyUsers, senderID) || contains(rules.DenyUsers, senderID) {
+		return deny("user is on the deny list")
+	}
+
+	if !contains(rules.AllowUsers, senderID) && !MatchesServer(rules.AllowServers, senderID) {
+		return deny("user and server are not allowed")
+	}
+
+	if rules.MinPowerLevel != nil && *rules.MinPowerLevel > 0 {
+		if p.powerLevel == nil {
+			return deny("power level unavailable")
+		}
+		level, err := p.powerLevel(roomID, senderID)
+		if err != nil {
+			return deny(fmt.Sprintf("failed to read power level: %v", err))
+		}
+		if level < *rules.MinPowerLevel {
+			return deny(fmt.Sprintf("power level %d below required %d", level, *rules.MinPowerLevel))
+		}
+	}
+
+	return Decision{Allowed: true}
+}
+
+// rulesFor merges a room's overrides into the global rules.
+func (p *Policy) rulesFor(roomID string) Rules {
+	rules := p.global
+	override, ok := p.rooms[roomID]
+	if !ok {
+		return rules
+	}
+	if override.AllowServers != nil {
+		rules.AllowServers = override.AllowServers
+	}
+	if override.AllowUsers != nil {
+		rules.AllowUsers = override.AllowUsers
+	}
+	if override.DenyUsers != nil {
+		rules.DenyUsers = override.DenyUsers
+	}
+	if override.MinPowerLevel != nil {
+		rules.MinPowerLevel = override.MinPowerLevel
+	}
+	if override.DeniedReply != nil {
+		rules.DeniedReply = override.DeniedReply
+	}
+	return rules
+}
+
+// Describe returns one line per rule set for the debug command.
+func (p *Policy) Describe() []string {
+	lines := []string{"global: " + describeRules(p.global)}
+
+	roomIDs := make([]string, 0, len(p.rooms))
+	for roomID := range p.rooms {
+		roomIDs = append(roomIDs, roomID)
+	}
+	sort.Strings(roomIDs)
+	for _, roomID := range roomIDs {
+		lines = append(lines, roomID+": "+describeRules(p.rooms[roomID]))
+	}
+	return lines
+}
+
+func describeRules(r Rules) string {
+	var parts []string
+	if r.AllowServers != nil {
+		parts = append(parts, "servers="+strings.Join(r.AllowServers, ","))
+	}
+	if r.AllowUsers != nil {
+		parts = append(parts, "users="+strings.Join(r.AllowUsers, ","))
+	}
+	if r.DenyUsers != nil {
+		parts = append(parts, "deny="+strings.Join(r.DenyUsers, ","))
+	}
+	if r.MinPowerLevel != nil {
+		parts = append(parts, fmt.Sprintf("min_power_level=%d", *r.MinPowerLevel))
+	}
+	if r.DeniedReply != nil {
+		if *r.DeniedReply == "" {
+			parts = append(parts, "denied_reply=(silent)")
+		} else {
+			parts = append(parts, fmt.Sprintf("denied_reply=%q", *r.DeniedReply))
+		}
+	}
+	if len(parts) == 0 {
+		return "(inherits everything)"
+	}
+	return strings.Join(parts, " ")
+}
+
+// MatchesServer reports whether the user's server matches one of the
+// globs. Server names may carry a port; a pattern without a port matches
+// the host on any port. IPv6 literals are written in brackets, as in
+// user IDs, and the brackets match literally.
+func MatchesServer(patterns []string, userID string) bool {
+	_, server, err := id.UserID(userID).Parse()
+	if err != nil || server == "" {
+		return false
+	}
+	host := server
+	if idx := strings.LastIndex(server, ":"); idx >= 0 && !strings.HasSuffix(server, "]") {
+		host = server[:idx]
+	}
+
+	for _, pattern := range patterns {
+		glob := pattern
+		hasPort := strings.Contains(pattern, ":")
+		if strings.HasPrefix(pattern, "[") {
+			glob = strings.NewReplacer("[", `\[`, "]", `\]`).Replace(pattern)
+			hasPort = strings.Contains(pattern, "]:")
+		}
+		if ok, _ := path.Match(glob, server); ok {
+			return true
+		}
+		if !hasPort {
+			if ok, _ := path.Match(glob, host); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package access
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/huhndev/gohenry/config"
+)
+
+const (
+	teamRoom   = "!team:example.org"
+	openRoom   = "!open:example.org"
+	lockedRoom = "!locked:example.org"
+)
+
+func intPtr(n int) *int       { return &n }
+func strPtr(s string) *string { return &s }
+
+func testPolicy(powerLevel PowerLevelFunc) *Policy {
+	return &Policy{
+		global: Rules{
+			AllowServers: []string{"example.org", "*.corp.example"},
+			AllowUsers:   []string{"@guest:matrix.org"},
+			DenyUsers:    []string{"@troll:example.org"},
+			DeniedReply:  strPtr("members only"),
+		},
+		rooms: map[string]Rules{
+			teamRoom:   {MinPowerLevel: intPtr(50)},
+			openRoom:   {AllowServers: []string{"*"}, DenyUsers: []string{"@spammer:matrix.org"}, DeniedReply: strPtr("")},
+			lockedRoom: {AllowServers: []string{}, AllowUsers: []string{"@troll:example.org", "@vip:example.org"}},
+		},
+		powerLevel: powerLevel,
+	}
+}
+
+func TestCheck(t *testing.T) {
+	levels := map[string]int{"@mod:example.org": 50, "@user:example.org": 0}
+	policy := testPolicy(func(roomID, userID string) (int, error) {
+		if userID == "@broken:example.org" {
+			return 0, errors.New("state unavailable")
+		}
+		return levels[userID], nil
+	})
+
+	tests := []struct {
+		name    string
+		room    string
+		sender  string
+		allowed bool
+		reply   string
+	}{
+		{"allowed server", "!any:example.org", "@anna:example.org", true, ""},
+		{"server glob", "!any:example.org", "@anna:eu.corp.example", true, ""},
+		{"glob doesn't match the bare domain", "!any:example.org", "@anna:corp.example", false, "members only"},
+		{"other server", "!any:example.org", "@anna:matrix.org", false, "members only"},
+		{"allowed user", "!any:example.org", "@guest:matrix.org", true, ""},
+		{"deny list", "!any:example.org", "@troll:example.org", false, "members only"},
+		{"room allows every server", openRoom, "@anna:matrix.org", true, ""},
+		{"room deny list adds to global", openRoom, "@spammer:matrix.org", false, ""},
+		{"global deny wins over room allow", lockedRoom, "@troll:example.org", false, "members only"},
+		{"room clears allowed servers", lockedRoom, "@anna:example.org", false, "members only"},
+		{"room allowed user", lockedRoom, "@vip:example.org", true, ""},
+		{"room doesn't inherit global users it overrides", lockedRoom, "@guest:matrix.org", false, "members only"},
+		{"power level reached", teamRoom, "@mod:example.org", true, ""},
+		{"power level too low", teamRoom, "@user:example.org", false, "members only"},
+		{"power level unreadable", teamRoom, "@broken:example.org", false, "members only"},
+		{"power level needs allowed server", teamRoom, "@mod:matrix.org", false, "members only"},
+	}
+	for _, tt := range tests {
+		d := policy.Check(tt.room, tt.sender)
+		if d.Allowed != tt.allowed {
+			t.Errorf("%s: allowed = %v (%s), want %v", tt.name, d.Allowed, d.Reason, tt.allowed)
+		}
+		if d.Reply != tt.reply {
+			t.Errorf("%s: reply = %q, want %q", tt.name, d.Reply, tt.reply)
+		}
+	}
+}
+
+func TestCheckWithoutPowerLevels(t *testing.T) {
+	if d := testPolicy(nil).Check(teamRoom, "@mod:example.org"); d.Allowed {
+		t.Error("min_power_level passed without a way to read power levels")
+	}
+}
+
+func TestRulesFor(t *testing.T) {
+	policy := testPolicy(nil)
+
+	global := policy.rulesFor("!unknown:example.org")
+	if strings.Join(global.AllowServers, ",") != "example.org,*.corp.example" || global.MinPowerLevel != nil {
+		t.Errorf("room without override = %+v", global)
+	}
+	team := policy.rulesFor(teamRoom)
+	if team.MinPowerLevel == nil || *team.MinPowerLevel != 50 || len(team.AllowUsers) != 1 || *team.DeniedReply != "members only" {
+		t.Errorf("team room = %+v, want global rules plus min_power_level", team)
+	}
+	locked := policy.rulesFor(lockedRoom)
+	if locked.AllowServers == nil || len(locked.AllowServers) != 0 {
+		t.Errorf("empty list didn't clear allow_servers: %#v", locked.AllowServers)
+	}
+	if open := policy.rulesFor(openRoom); open.DeniedReply == nil || *open.DeniedReply != "" {
+		t.Errorf("empty denied_reply didn't silence the room: %+v", open)
+	}
+}
+
+func TestMatchesServer(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		userID   string
+		want     bool
+	}{
+		{[]string{"example.org"}, "@a:example.org", true},
+		{[]string{"example.org"}, "@a:example.org:8448", true},
+		{[]string{"example.org:8448"}, "@a:example.org:8448", true},
+		{[]string{"example.org:8448"}, "@a:example.org", false},
+		{[]string{"example.org:8448"}, "@a:example.org:443", false},
+		{[]string{"*.example.org"}, "@a:chat.example.org", true},
+		{[]string{"*.example.org"}, "@a:example.org", false},
+		{[]string{"*.example.org"}, "@a:evil-example.org", false},
+		{[]string{"[::1]"}, "@a:[::1]", true},
+		{[]string{"[::1]"}, "@a:[::1]:8448", true},
+		{[]string{"[::1]:8448"}, "@a:[::1]:8448", true},
+		{[]string{"[::1]:8448"}, "@a:[::1]", false},
+		{[]string{"[::1]"}, "@a:[::2]", false},
+		{[]string{"[2001:db8::*]"}, "@a:[2001:db8::7]:8448", true},
+		{[]string{"example.org"}, "@a:[::1]", false},
+		{[]string{"*"}, "@a:[::1]", true},
+		{[]string{"example.org"}, "not-a-user-id", false},
+		{nil, "@a:example.org", false},
+	}
+	for _, tt := range tests {
+		if got := MatchesServer(tt.patterns, tt.userID); got != tt.want {
+			t.Errorf("MatchesServer(%q, %s) = %v, want %v", tt.patterns, tt.userID, got, tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	policy, err := Load(&config.Config{AllowedDomain: "example.org", AccessDeniedReply: "no"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := policy.Check("!r:example.org", "@a:matrix.org"); d.Allowed || d.Reply != "no" {
+		t.Errorf("domain-only policy = %+v", d)
+	}
+
+	missing := filepath.Join(dir, "missing.json")
+	if _, err := Load(&config.Config{AllowedDomain: "example.org", AccessPolicyFile: missing}, nil); err == nil {
+		t.Error("a configured policy file that doesn't exist was ignored")
+	}
+
+	file := filepath.Join(dir, "policy.json")
+	if err := ioutil.WriteFile(file, []byte(`{"allow_users": ["@a:matrix.org"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err = Load(&config.Config{AllowedDomain: "example.org", AccessPolicyFile: file}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !policy.Check("!r:example.org", "@a:matrix.org").Allowed || policy.Check("!r:example.org", "@b:example.org").Allowed {
+		t.Error("policy file didn't replace the allowed domain")
+	}
+
+	if err := ioutil.WriteFile(file, []byte(`{"allow_users": `), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(&config.Config{AccessPolicyFile: file}, nil); err == nil {
+		t.Error("invalid policy file was accepted")
+	}
+}
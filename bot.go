@@ -11,10 +11,13 @@ import (
 
 	"maunium.net/go/mautrix/event"
 
+	"github.com/huhndev/gohenry/access"
 	"github.com/huhndev/gohenry/chat"
 	"github.com/huhndev/gohenry/config"
 	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/matrix"
 	"github.com/huhndev/gohenry/room"
+	"github.com/huhndev/gohenry/scheduler"
 )
 
 // Bot represents the main application
@@ -22,27 +25,43 @@ type Bot struct {
 	config         *config.Config
 	matrixService  domain.MatrixService
 	aiService      domain.AIService
+	accessPolicy   *access.Policy
 	messageHandler *chat.MessageHandler
+	digestService  *chat.DigestService
+	scheduler      *scheduler.Scheduler
 	joinService    *room.JoinService
 	inviteService  *room.InviteService
+	invitePolicy   *room.InvitePolicy
 }
 
 func NewBot(
 	cfg *config.Config,
 	matrixService domain.MatrixService,
 	aiService domain.AIService,
+	accessPolicy *access.Policy,
+	standups []chat.StandupConfig,
 ) *Bot {
-	messageHandler := chat.NewMessageHandler(cfg, matrixService, aiService)
+	messageHandler := chat.NewMessageHandler(cfg, matrixService, aiService, accessPolicy)
 	joinService := room.NewJoinService(matrixService, cfg)
 	inviteService := room.NewInviteService(matrixService, cfg)
+	invitePolicy := room.NewInvitePolicy(matrixService, cfg)
+	sched := scheduler.New(cfg.DataDir, scheduler.SystemClock{})
+	chat.NewReminderService(messageHandler, sched)
+	chat.NewScheduleService(messageHandler, sched)
+	chat.NewStandupService(messageHandler, sched, standups)
+	messageHandler.RegisterBotErasers(sched, invitePolicy)
 
 	return &Bot{
 		config:         cfg,
 		matrixService:  matrixService,
 		aiService:      aiService,
+		accessPolicy:   accessPolicy,
 		messageHandler: messageHandler,
+		digestService:  chat.NewDigestService(messageHandler),
+		scheduler:      sched,
 		joinService:    joinService,
 		inviteService:  inviteService,
+		invitePolicy:   invitePolicy,
 	}
 }
 
@@ -63,16 +82,40 @@ func (b *Bot) Run(ctx context.Context) error {
 			if !ok || content.Body == "" {
 				return
 			}
-			if err := b.messageHandler.HandleMessage(ctx, string(evt.Sender), string(evt.RoomID), content.Body); err != nil {
+			msg := &domain.Message{
+				ID:        string(evt.ID),
+				RoomID:    string(evt.RoomID),
+				SenderID:  string(evt.Sender),
+				Content:   content.Body,
+				Timestamp: evt.Timestamp,
+				ThreadID:  matrix.ThreadID(evt),
+			}
+			if content.MsgType == event.MsgFile && content.URL != "" {
+				msg.Attachment = attachment(content)
+				msg.Content = ""
+				if content.FileName != "" && content.FileName != content.Body {
+					msg.Content = content.Body
+				}
+			}
+			if err := b.messageHandler.HandleMessage(ctx, msg); err != nil {
 				log.Printf("Error handling message: %v", err)
 			}
 		},
 	)
 
+	go b.messageHandler.LoadUserSettings(ctx)
+
+	b.matrixService.SetInviteHandler(b.invitePolicy.HandleInvite)
+	b.matrixService.SetReactionHandler(b.invitePolicy.HandleReaction)
+	b.matrixService.SetReceiptHandler(b.digestService.HandleReceipt)
+	b.matrixService.SetRedactionHandler(b.messageHandler.HandleRedaction)
+
 	if err := b.matrixService.CheckAndJoinInvitedRooms(ctx); err != nil {
 		log.Printf("Error checking for invited rooms: %v", err)
 	}
 
+	go b.scheduler.Run(ctx)
+
 	go func() {
 		log.Printf("Starting Matrix event listener...")
 		if err := b.matrixService.ListenForMessages(ctx); err != nil {
@@ -81,7 +124,7 @@ func (b *Bot) Run(ctx context.Context) error {
 		}
 	}()
 
-	log.Printf("Henry is now running and will auto-accept room invitations. Press Ctrl+C to exit.")
+	log.Printf("Henry is now running (invite policy: %s). Press Ctrl+C to exit.", b.invitePolicy.Describe())
 	log.Printf("Bot userID: %s", b.matrixService.GetBotUserID())
 
 	go func() {
@@ -113,6 +156,23 @@ func (b *Bot) Run(ctx context.Context) error {
 	return ctx.Err()
 }
 
+// attachment describes the file of an m.file message. Since Matrix 1.10
+// the body is a caption when a separate filename is given.
+func attachment(content *event.MessageEventContent) *domain.Attachment {
+	a := &domain.Attachment{
+		Name: content.FileName,
+		URL:  string(content.URL),
+	}
+	if a.Name == "" {
+		a.Name = content.Body
+	}
+	if content.Info != nil {
+		a.MimeType = content.Info.MimeType
+		a.Size = content.Info.Size
+	}
+	return a
+}
+
 func (b *Bot) JoinRoom(ctx context.Context, roomID string) error {
 	if err := b.matrixService.Connect(ctx); err != nil {
 		return fmt.Errorf("failed to connect to Matrix: %v", err)
@@ -135,18 +195,39 @@ func (b *Bot) InviteUser(
 	return err
 }
 
+// Logout invalidates the bot's access token and forgets the stored session.
+// The next start performs a fresh password login with a new device.
+func (b *Bot) Logout(ctx context.Context) error {
+	if err := b.matrixService.Connect(ctx); err != nil {
+		return fmt.Errorf("failed to connect to Matrix: %v", err)
+	}
+	if err := b.matrixService.Logout(); err != nil {
+		return err
+	}
+	log.Printf("Logged out and removed stored session")
+	return nil
+}
+
 func (b *Bot) RunDebug(ctx context.Context) error {
 	log.Printf("Bot diagnostic information:")
 	log.Printf("  Configuration:")
 	log.Printf("    Matrix homeserver: %s", b.config.MatrixHomeserver)
 	log.Printf("    Matrix user ID: %s", b.config.MatrixUserID)
 	log.Printf("    Authentication: Using %s", func() string {
+		if b.config.AppServiceRegistration != "" {
+			return "appservice registration " + b.config.AppServiceRegistration +
+				" (listening on " + b.config.AppServiceListen + ")"
+		}
 		if b.config.MatrixAccessToken != "" {
 			return "access token"
 		}
-		return "password"
+		return "password (session stored in " + b.config.SessionFile + ")"
 	}())
-	log.Printf("    Allowed domain: %s", b.config.AllowedDomain)
+	log.Printf("    Access policy:")
+	for _, line := range b.accessPolicy.Describe() {
+		log.Printf("      %s", line)
+	}
+	log.Printf("    Invite policy: %s", b.invitePolicy.Describe())
 	log.Printf("    Context message count: %d", b.config.ContextMessageCount)
 	log.Printf("    Claude API Key: %s", func() string {
 		if b.config.ClaudeAPIKey != "" {
@@ -0,0 +1,243 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/huhndev/gohenry/i18n"
+)
+
+// registerBuiltins adds the commands every Henry instance has.
+func (h *MessageHandler) registerBuiltins() {
+	h.router.Register(&Command{
+		Name: "help",
+		Handler: func(ctx context.Context, inv *Invocation) (string, error) {
+			return h.router.help(inv), nil
+		},
+	})
+
+	h.router.Register(&Command{
+		Name:    "reset",
+		Aliases: []string{"forget"},
+		Handler: h.cmdReset,
+	})
+
+	h.router.Register(&Command{
+		Name:    "settings",
+		Handler: h.cmdSettings,
+	})
+
+	h.router.Register(&Command{
+		Name: "set",
+		Args: []Arg{
+			{Name: "setting", Choices: []string{"model", "persona", "roomcontext", "language"}},
+			{Name: "value", Type: ArgText},
+		},
+		Permission: PermissionModerator,
+		Handler:    h.cmdSet,
+	})
+
+	h.router.Register(&Command{
+		Name:    "consent",
+		Aliases: []string{"privacy"},
+		Args: []Arg{
+			{Name: "action", Optional: true, Choices: []string{"accept", "decline", "notice", "status"}},
+		},
+		Handler: h.cmdConsent,
+	})
+
+	h.router.Register(&Command{
+		Name:    "erase",
+		Aliases: []string{"forgetme", "deletemydata"},
+		Args:    []Arg{{Name: "request", Type: ArgText, Optional: true}},
+		Handler: h.cmdErase,
+	})
+
+	h.router.Register(&Command{
+		Name:    "kb",
+		Aliases: []string{"knowledge", "docs"},
+		Args:    []Arg{{Name: "request", Type: ArgText, Optional: true}},
+		Handler: h.cmdKnowledge,
+	})
+
+	h.router.Register(&Command{
+		Name:    "memory",
+		Aliases: []string{"memories"},
+		Args:    []Arg{{Name: "request", Type: ArgText, Optional: true}},
+		Handler: h.cmdMemory,
+	})
+
+	h.router.Register(&Command{
+		Name:    "search",
+		Aliases: []string{"find"},
+		Args:    []Arg{{Name: "query", Type: ArgText, Optional: true}},
+		Handler: h.cmdSearch,
+	})
+
+	h.router.Register(&Command{
+		Name:    "locale",
+		Aliases: []string{"language"},
+		Args:    []Arg{{Name: "locale", Optional: true}},
+		Handler: h.cmdLocale,
+	})
+
+	h.router.Register(&Command{
+		Name:    "timezone",
+		Aliases: []string{"tz"},
+		Args:    []Arg{{Name: "zone", Optional: true}},
+		Handler: h.cmdTimezone,
+	})
+
+	h.router.Register(&Command{
+		Name:    "usage",
+		Handler: h.cmdUsage,
+	})
+}
+
+func (h *MessageHandler) cmdReset(ctx context.Context, inv *Invocation) (string, error) {
+	// The marker is compared with origin_server_ts, so it uses the
+	// command's own timestamp rather than Henry's clock.
+	timestamp := inv.Timestamp
+	if timestamp == 0 {
+		timestamp = time.Now().UnixNano() / 1e6
+	}
+	marker := ResetMarker{
+		EventID:   inv.EventID,
+		Timestamp: timestamp,
+		SenderID:  inv.SenderID,
+	}
+	if err := h.resets.Set(inv.RoomID, inv.ThreadID, marker); err != nil {
+		return "", fmt.Errorf("failed to save reset marker: %v", err)
+	}
+
+	key := "reset.room"
+	if inv.ThreadID != "" {
+		key = "reset.thread"
+	}
+	return inv.Text(key, i18n.Vars{"user": inv.SenderID}), nil
+}
+
+func (h *MessageHandler) cmdSettings(ctx context.Context, inv *Invocation) (string, error) {
+	settings := h.settings.Get(inv.RoomID)
+
+	model := inv.Text("settings.default", i18n.Vars{"value": h.config.ClaudeModel})
+	if settings.Model != "" {
+		model = settings.Model
+	}
+	persona := inv.Text("settings.default", i18n.Vars{"value": "-"})
+	if settings.Persona != "" {
+		persona = settings.Persona
+	}
+	language := inv.Text("settings.language_users", nil)
+	if settings.Language != "" {
+		language = settings.Language
+	}
+
+	lines := []string{
+		inv.Text("settings.header", nil),
+		inv.Text("settings.model", i18n.Vars{"value": model}),
+		inv.Text("settings.persona", i18n.Vars{"value": persona}),
+		inv.Text("settings.context", i18n.Vars{"count": h.config.ContextMessageCount}),
+		inv.Text("settings.roomcontext", i18n.Vars{"state": inv.Text(onOff(!settings.RoomContextOff), nil)}),
+		inv.Text("settings.language", i18n.Vars{"value": language}),
+	}
+	if marker, ok := h.resets.Get(inv.RoomID, inv.ThreadID); ok {
+		lines = append(lines, inv.Text("settings.last_reset", i18n.Vars{
+			"time": h.users.Format(inv.SenderID, time.Unix(0, marker.Timestamp*int64(time.Millisecond))),
+			"user": marker.SenderID,
+		}))
+	}
+	lines = append(lines, inv.Text("settings.models", i18n.Vars{"models": strings.Join(h.config.ClaudeModels, ", ")}))
+	return strings.Join(lines, "\n"), nil
+}
+
+func (h *MessageHandler) cmdSet(ctx context.Context, inv *Invocation) (string, error) {
+	setting, value := inv.String("setting"), inv.String("value")
+	if strings.EqualFold(value, "default") {
+		value = ""
+	}
+
+	if setting == "roomcontext" && value != "" && value != "on" && value != "off" {
+		return inv.Text("set.roomcontext_invalid", nil), nil
+	}
+	if setting == "model" && value != "" && !containsFold(h.config.ClaudeModels, value) {
+		return inv.Text("set.model_unknown", i18n.Vars{
+			"model":  value,
+			"models": strings.Join(h.config.ClaudeModels, ", "),
+		}), nil
+	}
+	if setting == "language" && value != "" {
+		lang := i18n.Language(value)
+		if lang == "" {
+			return inv.Text("set.language_unknown", i18n.Vars{
+				"language":  value,
+				"languages": strings.Join(i18n.Languages(), ", "),
+			}), nil
+		}
+		value = lang
+		inv.Lang = lang
+	}
+
+	err := h.settings.Update(inv.RoomID, func(s *RoomSettings) {
+		switch setting {
+		case "model":
+			s.Model = value
+		case "persona":
+			s.Persona = value
+		case "roomcontext":
+			s.RoomContextOff = value == "off"
+		case "language":
+			s.Language = value
+		}
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to save settings: %v", err)
+	}
+
+	if value == "" {
+		return inv.Text("set.restored", i18n.Vars{"setting": setting}), nil
+	}
+	return inv.Text("set.done", i18n.Vars{"setting": setting, "value": value}), nil
+}
+
+func (h *MessageHandler) cmdUsage(ctx context.Context, inv *Invocation) (string, error) {
+	total, users := h.usage.Room(inv.RoomID)
+	if total.Requests == 0 {
+		return inv.Text("usage.none", nil), nil
+	}
+
+	userIDs := make([]string, 0, len(users))
+	for userID := range users {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Slice(userIDs, func(i, j int) bool {
+		return users[userIDs[i]].Requests > users[userIDs[j]].Requests
+	})
+
+	lines := []string{inv.Text("usage.room", i18n.Vars{
+		"requests": total.Requests,
+		"input":    total.InputTokens,
+		"output":   total.OutputTokens,
+	})}
+	for _, userID := range userIDs {
+		u := users[userID]
+		lines = append(lines, inv.Text("usage.user", i18n.Vars{
+			"user":     userID,
+			"requests": u.Requests,
+			"input":    u.InputTokens,
+			"output":   u.OutputTokens,
+		}))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// onOff returns the catalog message for on or off.
+func onOff(on bool) string {
+	if on {
+		return "state.on"
+	}
+	return "state.off"
+}
@@ -0,0 +1,214 @@
+package chat
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/i18n"
+)
+
+// Permission is the minimum role needed to run a command.
+type Permission int
+
+const (
+	// PermissionUser allows anyone the access policy lets talk to Henry.
+	PermissionUser Permission = iota
+	// PermissionModerator requires moderatorPowerLevel in the room.
+	PermissionModerator
+	// PermissionAdmin requires adminPowerLevel in the room.
+	PermissionAdmin
+	// PermissionOwner is reserved for HENRY_OWNER_ID.
+	PermissionOwner
+)
+
+// Power levels Matrix clients show as moderator and admin.
+const (
+	moderatorPowerLevel = 50
+	adminPowerLevel     = 100
+)
+
+// key is the catalog message naming who holds the permission.
+func (p Permission) key() string {
+	switch p {
+	case PermissionModerator:
+		return "permission.moderator"
+	case PermissionAdmin:
+		return "permission.admin"
+	case PermissionOwner:
+		return "permission.owner"
+	default:
+		return "permission.user"
+	}
+}
+
+func (p Permission) String() string {
+	switch p {
+	case PermissionModerator:
+		return "room moderators"
+	case PermissionAdmin:
+		return "room admins"
+	case PermissionOwner:
+		return "the bot owner"
+	default:
+		return "everyone"
+	}
+}
+
+// ArgType determines how an argument is parsed.
+type ArgType int
+
+const (
+	// ArgWord is a single whitespace-separated word.
+	ArgWord ArgType = iota
+	// ArgInt is a whole number.
+	ArgInt
+	// ArgText takes the rest of the line and must be the last argument.
+	ArgText
+)
+
+// Arg describes one positional command argument.
+type Arg struct {
+	Name     string
+	Type     ArgType
+	Optional bool
+	// Choices restricts an ArgWord to a fixed set of values.
+	Choices []string
+}
+
+// Command is a chat command such as "!henry help". Its help text is the
+// catalog's "help.<name>" message.
+type Command struct {
+	Name       string
+	Aliases    []string
+	Args       []Arg
+	Permission Permission
+	Handler    func(ctx context.Context, inv *Invocation) (string, error)
+}
+
+// Invocation is a parsed command call.
+type Invocation struct {
+	RoomID   string
+	SenderID string
+	// EventID is the command message itself, and Timestamp its
+	// origin_server_ts in milliseconds. Both are empty for scheduled runs.
+	EventID   string
+	Timestamp int64
+	ThreadID  string
+	RoomType  domain.RoomType
+	Command   *Command
+	// Lang is the language replies are written in.
+	Lang string
+
+	values map[string]interface{}
+}
+
+// Text renders a catalog message in the invocation's language.
+func (inv *Invocation) Text(key string, vars i18n.Vars) string {
+	return i18n.Text(inv.Lang, key, vars)
+}
+
+// String returns a word or text argument, or "" if it was omitted.
+func (inv *Invocation) String(name string) string {
+	s, _ := inv.values[name].(string)
+	return s
+}
+
+// Int returns an integer argument, or def if it was omitted.
+func (inv *Invocation) Int(name string, def int) int {
+	if n, ok := inv.values[name].(int); ok {
+		return n
+	}
+	return def
+}
+
+// Has reports whether an optional argument was given.
+func (inv *Invocation) Has(name string) bool {
+	_, ok := inv.values[name]
+	return ok
+}
+
+// Usage renders the command's syntax, e.g. "!henry set <setting> <value>".
+func (c *Command) Usage(prefix string) string {
+	parts := []string{prefix, c.Name}
+	for _, arg := range c.Args {
+		name := arg.Name
+		if len(arg.Choices) > 0 {
+			name = strings.Join(arg.Choices, "|")
+		}
+		if arg.Type == ArgText {
+			name += "..."
+		}
+		if arg.Optional {
+			parts = append(parts, "["+name+"]")
+		} else {
+			parts = append(parts, "<"+name+">")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// parseArgs fills in the command's arguments from the text after its name.
+func (c *Command) parseArgs(text string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	rest := strings.TrimSpace(text)
+
+	for _, arg := range c.Args {
+		if rest == "" {
+			if arg.Optional {
+				continue
+			}
+			return nil, i18n.NewError("args.missing", i18n.Vars{"arg": arg.Name})
+		}
+
+		if arg.Type == ArgText {
+			values[arg.Name] = rest
+			rest = ""
+			continue
+		}
+
+		word := rest
+		if idx := strings.IndexAny(rest, " \t\n"); idx >= 0 {
+			word = rest[:idx]
+			rest = strings.TrimSpace(rest[idx:])
+		} else {
+			rest = ""
+		}
+
+		switch arg.Type {
+		case ArgInt:
+			n, err := strconv.Atoi(word)
+			if err != nil {
+				return nil, i18n.NewError("args.not_number", i18n.Vars{"arg": arg.Name, "value": word})
+			}
+			values[arg.Name] = n
+		default:
+			if len(arg.Choices) > 0 && !containsFold(arg.Choices, word) {
+				return nil, i18n.NewError("args.not_choice", i18n.Vars{
+					"arg":     arg.Name,
+					"choices": strings.Join(arg.Choices, ", "),
+					"value":   word,
+				})
+			}
+			if len(arg.Choices) > 0 {
+				word = strings.ToLower(word)
+			}
+			values[arg.Name] = word
+		}
+	}
+
+	if rest != "" {
+		return nil, i18n.NewError("args.extra", i18n.Vars{"text": rest})
+	}
+	return values, nil
+}
+
+func containsFold(list []string, value string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,362 @@
+package chat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/i18n"
+	"github.com/huhndev/gohenry/store"
+)
+
+// Consent states. Users without a record haven't decided yet.
+const (
+	consentAccepted = "accepted"
+	consentDeclined = "declined"
+)
+
+// Audit log actions besides the two states.
+const consentNoticeSent = "notice_sent"
+
+// Words that answer the consent notice in a direct chat.
+var (
+	consentAcceptWords  = []string{"accept", "i accept", "yes", "akzeptieren", "ich akzeptiere", "ja"}
+	consentDeclineWords = []string{"decline", "no", "ablehnen", "nein"}
+)
+
+// ConsentRecord is a user's current consent.
+type ConsentRecord struct {
+	State string `json:"state,omitempty"`
+	// NoticeVersion identifies the notice text last sent to the user.
+	NoticeVersion string    `json:"notice_version,omitempty"`
+	NoticeSentAt  time.Time `json:"notice_sent_at,omitempty"`
+	DecidedAt     time.Time `json:"decided_at,omitempty"`
+}
+
+// ConsentEvent is one entry of the consent audit log.
+type ConsentEvent struct {
+	Time          time.Time `json:"time"`
+	UserID        string    `json:"user_id"`
+	Action        string    `json:"action"`
+	By            string    `json:"by,omitempty"`
+	NoticeVersion string    `json:"notice_version,omitempty"`
+}
+
+// ConsentStore keeps each user's consent in consent.json and every change
+// in the append-only consent_audit.jsonl.
+type ConsentStore struct {
+	file     *store.File
+	audit    *store.Log
+	required bool
+
+	mu    sync.Mutex
+	users map[string]*ConsentRecord
+}
+
+func NewConsentStore(dataDir string, required bool) *ConsentStore {
+	s := &ConsentStore{
+		file:     store.NewFile(dataDir, "consent.json"),
+		audit:    store.NewLog(dataDir, "consent_audit.jsonl"),
+		required: required,
+		users:    make(map[string]*ConsentRecord),
+	}
+	if err := s.file.Load(&s.users); err != nil {
+		log.Printf("WARNING: Failed to load consent: %v", err)
+	}
+	if s.users == nil {
+		s.users = make(map[string]*ConsentRecord)
+	}
+	return s
+}
+
+// Allowed reports whether the user's messages may be sent to Claude:
+// never after they declined, and with HENRY_CONSENT_REQUIRED only once
+// they accepted.
+func (s *ConsentStore) Allowed(userID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := s.users[userID]
+	if record == nil {
+		return !s.required
+	}
+	if s.required {
+		return record.State == consentAccepted
+	}
+	return record.State != consentDeclined
+}
+
+// Get returns the user's consent record.
+func (s *ConsentStore) Get(userID string) ConsentRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record := s.users[userID]; record != nil {
+		return *record
+	}
+	return ConsentRecord{}
+}
+
+// NoticeSent records that the user was sent the notice in version.
+func (s *ConsentStore) NoticeSent(userID, version string) error {
+	return s.change(userID, consentNoticeSent, userID, version, func(record *ConsentRecord, now time.Time) {
+		record.NoticeVersion = version
+		record.NoticeSentAt = now
+	})
+}
+
+// Decide sets the user's consent to consentAccepted or consentDeclined. by
+// is who made the change, usually the user.
+func (s *ConsentStore) Decide(userID, state, by string) error {
+	version := s.Get(userID).NoticeVersion
+	return s.change(userID, state, by, version, func(record *ConsentRecord, now time.Time) {
+		record.State = state
+		record.DecidedAt = now
+	})
+}
+
+func (s *ConsentStore) change(
+	userID, action, by, version string,
+	fn func(record *ConsentRecord, now time.Time),
+) error {
+	now := time.Now().UTC()
+	event := ConsentEvent{Time: now, UserID: userID, Action: action, By: by, NoticeVersion: version}
+	if err := s.audit.Append(event); err != nil {
+		return fmt.Errorf("failed to audit consent change: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := s.users[userID]
+	if record == nil {
+		record = &ConsentRecord{}
+		s.users[userID] = record
+	}
+	fn(record, now)
+	if err := s.file.Save(s.users); err != nil {
+		return fmt.Errorf("failed to save consent: %v", err)
+	}
+	log.Printf("Consent of %s: %s by %s", userID, action, by)
+	return nil
+}
+
+// Forget deletes the user's consent record. A decline is kept, without
+// its history, so the user's messages stay out of everything; the audit
+// log is never rewritten.
+func (s *ConsentStore) Forget(userID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := s.users[userID]
+	if record == nil {
+		return 0, nil
+	}
+	if record.State == consentDeclined {
+		s.users[userID] = &ConsentRecord{State: consentDeclined}
+	} else {
+		delete(s.users, userID)
+	}
+	if err := s.file.Save(s.users); err != nil {
+		return 0, fmt.Errorf("failed to save consent: %v", err)
+	}
+	return 1, nil
+}
+
+// History returns the audit log entries of the user, oldest first.
+func (s *ConsentStore) History(userID string) ([]ConsentEvent, error) {
+	var events []ConsentEvent
+	err := s.audit.Each(func(data []byte) error {
+		var event ConsentEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			log.Printf("WARNING: Skipping unreadable consent audit entry: %v", err)
+			return nil
+		}
+		if event.UserID == userID {
+			events = append(events, event)
+		}
+		return nil
+	})
+	return events, err
+}
+
+// mayShare reports whether a message may be sent to Claude, summarized or
+// indexed.
+func (h *MessageHandler) mayShare(msg *domain.Message) bool {
+	return msg.IsFromBot || msg.SenderID == h.matrixService.GetBotUserID() || h.consent.Allowed(msg.SenderID)
+}
+
+// loadConsentNotice reads HENRY_CONSENT_NOTICE_FILE. Without one, the
+// catalog's notice is used in each user's language.
+func loadConsentNotice(path string) string {
+	if path == "" {
+		return ""
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("WARNING: Failed to read consent notice, using the built-in one: %v", err)
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// consentNotice returns the notice for the user and its version, a hash of
+// the text.
+func (h *MessageHandler) consentNotice(roomID, userID string) (string, string) {
+	notice := h.consentNoticeText
+	if notice == "" {
+		notice = h.text(roomID, userID, "consent.notice", nil)
+	}
+	sum := sha256.Sum256([]byte(notice))
+	return notice, hex.EncodeToString(sum[:6])
+}
+
+// requestConsent answers a user whose messages may not be sent to Claude.
+// First-time users are sent the notice in a direct chat.
+func (h *MessageHandler) requestConsent(roomID, senderID string, roomType domain.RoomType) {
+	record := h.consent.Get(senderID)
+	if record.State == consentDeclined {
+		h.replyDenied(roomID, senderID, h.text(roomID, senderID, "consent.opted_out", i18n.Vars{
+			"prefix": h.config.CommandPrefix,
+		}))
+		return
+	}
+
+	if record.NoticeSentAt.IsZero() {
+		dmRoom := roomID
+		if roomType != domain.DirectRoom {
+			var err error
+			if dmRoom, err = h.matrixService.EnsureDirectRoom(senderID); err != nil {
+				log.Printf("Failed to open direct chat with %s for consent notice: %v", senderID, err)
+				return
+			}
+		}
+		notice, version := h.consentNotice(dmRoom, senderID)
+		message := notice + "\n\n" + h.text(dmRoom, senderID, "consent.how", i18n.Vars{
+			"prefix": h.config.CommandPrefix,
+		})
+		if err := h.matrixService.SendMessage(dmRoom, message); err != nil {
+			log.Printf("Failed to send consent notice to %s: %v", senderID, err)
+			return
+		}
+		if err := h.consent.NoticeSent(senderID, version); err != nil {
+			log.Printf("WARNING: %v", err)
+		}
+		if roomType == domain.DirectRoom {
+			return
+		}
+	}
+
+	key := "consent.pending_room"
+	if roomType == domain.DirectRoom {
+		key = "consent.pending_dm"
+	}
+	h.replyDenied(roomID, senderID, h.text(roomID, senderID, key, i18n.Vars{"prefix": h.config.CommandPrefix}))
+}
+
+// collectConsent takes "accept" or "decline" in a direct chat as the answer
+// to a pending notice.
+func (h *MessageHandler) collectConsent(ctx context.Context, msg *domain.Message) bool {
+	record := h.consent.Get(msg.SenderID)
+	if record.State != "" || record.NoticeSentAt.IsZero() {
+		return false
+	}
+	answer := strings.ToLower(strings.Trim(strings.TrimSpace(msg.Content), ".!"))
+	var state string
+	switch {
+	case containsFold(consentAcceptWords, answer):
+		state = consentAccepted
+	case containsFold(consentDeclineWords, answer):
+		state = consentDeclined
+	default:
+		return false
+	}
+	roomType, err := h.matrixService.GetRoomType(ctx, msg.RoomID)
+	if err != nil || roomType != domain.DirectRoom {
+		return false
+	}
+
+	reply, err := h.setConsent(msg.SenderID, state, msg.SenderID, h.language(msg.RoomID, msg.SenderID))
+	if err != nil {
+		log.Printf("Failed to record consent of %s: %v", msg.SenderID, err)
+		return true
+	}
+	if err := h.matrixService.SendMessage(msg.RoomID, reply); err != nil {
+		log.Printf("Failed to confirm consent: %v", err)
+	}
+	return true
+}
+
+// setConsent records a decision and returns the confirmation. Declining
+// also removes the user's messages from the search index.
+func (h *MessageHandler) setConsent(userID, state, by, lang string) (string, error) {
+	if err := h.consent.Decide(userID, state, by); err != nil {
+		return "", err
+	}
+	if state == consentDeclined {
+		if err := h.forgetDeclined(userID); err != nil {
+			return "", err
+		}
+		return i18n.Text(lang, "consent.declined", i18n.Vars{"prefix": h.config.CommandPrefix}), nil
+	}
+	return i18n.Text(lang, "consent.accepted", i18n.Vars{"prefix": h.config.CommandPrefix}), nil
+}
+
+// forgetDeclined removes the user's content from every index that feeds
+// Claude: their indexed messages, memories and uploaded documents. Settings
+// and usage aren't content and stay.
+func (h *MessageHandler) forgetDeclined(userID string) error {
+	if removed := h.messages.Forget(userID); removed > 0 {
+		log.Printf("Removed %d messages of %s from the search index", removed, userID)
+	}
+	removed, err := h.memories.Forget(userID)
+	if err != nil {
+		return fmt.Errorf("failed to remove memories of %s: %v", userID, err)
+	}
+	if removed > 0 {
+		log.Printf("Removed %d memories of %s", removed, userID)
+	}
+	if removed, err = h.knowledge.Forget(userID); err != nil {
+		return fmt.Errorf("failed to remove documents of %s: %v", userID, err)
+	}
+	if removed > 0 {
+		log.Printf("Removed %d documents of %s from the knowledge base", removed, userID)
+	}
+	return nil
+}
+
+func (h *MessageHandler) cmdConsent(ctx context.Context, inv *Invocation) (string, error) {
+	switch inv.String("action") {
+	case "accept":
+		return h.setConsent(inv.SenderID, consentAccepted, inv.SenderID, inv.Lang)
+	case "decline":
+		return h.setConsent(inv.SenderID, consentDeclined, inv.SenderID, inv.Lang)
+	case "notice":
+		notice, version := h.consentNotice(inv.RoomID, inv.SenderID)
+		return notice + "\n\n" + inv.Text("consent.version", i18n.Vars{"version": version}), nil
+	}
+
+	record := h.consent.Get(inv.SenderID)
+	state := record.State
+	if state == "" {
+		state = "undecided"
+	}
+	lines := []string{inv.Text("consent.status."+state, i18n.Vars{"prefix": h.config.CommandPrefix})}
+	events, err := h.consent.History(inv.SenderID)
+	if err != nil {
+		return "", err
+	}
+	for _, event := range events {
+		lines = append(lines, inv.Text("consent.history_item", i18n.Vars{
+			"time":    h.users.Format(inv.SenderID, event.Time),
+			"action":  inv.Text("consent.action."+event.Action, nil),
+			"by":      event.By,
+			"version": event.NoticeVersion,
+		}))
+	}
+	return strings.Join(lines, "\n"), nil
+}
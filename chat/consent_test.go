@@ -0,0 +1,272 @@
+package chat
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/huhndev/gohenry/config"
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/i18n"
+)
+
+func TestDeclineRemovesContent(t *testing.T) {
+	h, _, _ := newEraseTestHandler(t)
+
+	if _, err := h.setConsent(alice, consentDeclined, alice, "en"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(h.memories.List(alice)) != 0 {
+		t.Error("alice's memories survived the decline")
+	}
+	for _, doc := range h.knowledge.List(testRoom) {
+		if doc.AddedBy == alice {
+			t.Errorf("alice's document %s survived the decline", doc.Name)
+		}
+	}
+	if hits := h.messages.Search(map[string]int64{testRoom: 0}, "hello", "", 10); len(hits) != 1 || hits[0].SenderID != bob {
+		t.Errorf("search after decline = %+v", hits)
+	}
+
+	if len(h.memories.List(bob)) != 1 || len(h.knowledge.List(testRoom)) != 1 {
+		t.Error("bob's memories or documents were removed")
+	}
+}
+
+func TestConsentAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		required bool
+		record   func(s *ConsentStore) error
+		want     bool
+	}{
+		{"required, unknown user", true, nil, false},
+		{"required, notice sent", true, func(s *ConsentStore) error { return s.NoticeSent(alice, "v1") }, false},
+		{"required, accepted", true, func(s *ConsentStore) error { return s.Decide(alice, consentAccepted, alice) }, true},
+		{"required, declined", true, func(s *ConsentStore) error { return s.Decide(alice, consentDeclined, alice) }, false},
+		{"optional, unknown user", false, nil, true},
+		{"optional, notice sent", false, func(s *ConsentStore) error { return s.NoticeSent(alice, "v1") }, true},
+		{"optional, accepted", false, func(s *ConsentStore) error { return s.Decide(alice, consentAccepted, alice) }, true},
+		{"optional, declined", false, func(s *ConsentStore) error { return s.Decide(alice, consentDeclined, alice) }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := NewConsentStore(dir, tt.required)
+			if tt.record != nil {
+				if err := tt.record(s); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := s.Allowed(alice); got != tt.want {
+				t.Errorf("Allowed = %v, want %v", got, tt.want)
+			}
+			if got := NewConsentStore(dir, tt.required).Allowed(alice); got != tt.want {
+				t.Errorf("Allowed after reload = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// consentMatrix opens direct chats named after the user, records sent
+// messages and serves a fixed history, newest first.
+type consentMatrix struct {
+	*fakeMatrix
+	direct  map[string]bool
+	sent    map[string][]string
+	history []*domain.Message
+}
+
+func newConsentMatrix() *consentMatrix {
+	return &consentMatrix{
+		fakeMatrix: &fakeMatrix{accountData: make(map[string][]byte)},
+		direct:     make(map[string]bool),
+		sent:       make(map[string][]string),
+	}
+}
+
+func (m *consentMatrix) GetRoomType(ctx context.Context, roomID string) (domain.RoomType, error) {
+	if m.direct[roomID] {
+		return domain.DirectRoom, nil
+	}
+	return domain.GroupRoom, nil
+}
+
+func (m *consentMatrix) EnsureDirectRoom(userID string) (string, error) {
+	dm := "!dm-" + strings.TrimPrefix(strings.Split(userID, ":")[0], "@") + ":example.org"
+	m.direct[dm] = true
+	return dm, nil
+}
+
+func (m *consentMatrix) SendMessage(roomID, content string) error {
+	m.sent[roomID] = append(m.sent[roomID], content)
+	return nil
+}
+
+func (m *consentMatrix) GetRoomContext(ctx context.Context, roomID string, limit int) ([]*domain.Message, error) {
+	return m.history, nil
+}
+
+func (m *consentMatrix) GetRoomHistory(ctx context.Context, roomID, threadID string, since int64, limit int) ([]*domain.Message, error) {
+	chronological := make([]*domain.Message, len(m.history))
+	for i, msg := range m.history {
+		chronological[len(m.history)-1-i] = msg
+	}
+	return chronological, nil
+}
+
+func (m *consentMatrix) SendTyping(roomID string, typing bool, timeout int) error {
+	return nil
+}
+
+func (m *consentMatrix) Permalink(roomID, eventID string) string {
+	return "https://matrix.to/#/" + roomID + "/" + eventID
+}
+
+// recordingAI answers every request with the same text and keeps the
+// requests.
+type recordingAI struct {
+	requests []domain.AIRequest
+}
+
+func (ai *recordingAI) GenerateResponse(ctx context.Context, req domain.AIRequest) (*domain.AIResponse, error) {
+	ai.requests = append(ai.requests, req)
+	return &domain.AIResponse{Content: "All good."}, nil
+}
+
+func newConsentTestHandler(t *testing.T, matrix *consentMatrix, ai domain.AIService) *MessageHandler {
+	cfg := &config.Config{
+		DataDir:             t.TempDir(),
+		Timezone:            "UTC",
+		Locale:              "en",
+		CommandPrefix:       "!henry",
+		ConsentRequired:     true,
+		ContextMessageCount: 10,
+		SummaryMaxMessages:  100,
+	}
+	return NewMessageHandler(cfg, matrix, ai, nil)
+}
+
+func TestConsentNoticeFlow(t *testing.T) {
+	matrix := newConsentMatrix()
+	h := newConsentTestHandler(t, matrix, nil)
+	ctx := context.Background()
+	const aliceDM = "!dm-alice:example.org"
+
+	// Alice writes in a group room first: the notice goes to a new
+	// direct chat, the room only learns where to look.
+	h.requestConsent(testRoom, alice, domain.GroupRoom)
+	notice, version := h.consentNotice(aliceDM, alice)
+	if dm := matrix.sent[aliceDM]; len(dm) != 1 || !strings.HasPrefix(dm[0], notice) {
+		t.Fatalf("notice not sent to alice's direct chat: %q", matrix.sent)
+	}
+	if room := matrix.sent[testRoom]; len(room) != 1 || room[0] != h.text(testRoom, alice, "consent.pending_room", nil) {
+		t.Errorf("room reply = %q", room)
+	}
+	if record := h.consent.Get(alice); record.NoticeVersion != version || record.NoticeSentAt.IsZero() {
+		t.Errorf("notice not recorded: %+v", record)
+	}
+
+	// Asking again neither resends the notice nor floods the room.
+	h.requestConsent(testRoom, alice, domain.GroupRoom)
+	if len(matrix.sent[aliceDM]) != 1 || len(matrix.sent[testRoom]) != 1 {
+		t.Errorf("second request sent %q", matrix.sent)
+	}
+
+	// "accept" only answers the notice in a direct chat.
+	if h.collectConsent(ctx, &domain.Message{RoomID: testRoom, SenderID: alice, Content: "accept"}) {
+		t.Error("accept in a group room was taken as consent")
+	}
+	if h.collectConsent(ctx, &domain.Message{RoomID: aliceDM, SenderID: alice, Content: "Tell me more"}) {
+		t.Error("an unrelated reply was taken as consent")
+	}
+	if !h.collectConsent(ctx, &domain.Message{RoomID: aliceDM, SenderID: alice, Content: "Accept!"}) {
+		t.Fatal("accept in the direct chat wasn't collected")
+	}
+	if !h.consent.Allowed(alice) {
+		t.Error("alice isn't allowed after accepting")
+	}
+	if dm := matrix.sent[aliceDM]; len(dm) != 2 || dm[1] != h.text(aliceDM, alice, "consent.accepted", i18n.Vars{"prefix": "!henry"}) {
+		t.Errorf("confirmation = %q", dm)
+	}
+	// Once decided, further replies are ordinary messages.
+	if h.collectConsent(ctx, &domain.Message{RoomID: aliceDM, SenderID: alice, Content: "no"}) {
+		t.Error("a later \"no\" changed alice's consent")
+	}
+
+	// Bob writes in a direct chat: the notice is the only reply there.
+	const bobDM = "!bob-chat:example.org"
+	matrix.direct[bobDM] = true
+	if h.collectConsent(ctx, &domain.Message{RoomID: bobDM, SenderID: bob, Content: "yes"}) {
+		t.Error("bob's yes was collected before he got the notice")
+	}
+	h.requestConsent(bobDM, bob, domain.DirectRoom)
+	if dm := matrix.sent[bobDM]; len(dm) != 1 || !strings.HasPrefix(dm[0], notice) {
+		t.Fatalf("bob's direct chat got %q", dm)
+	}
+	if !h.collectConsent(ctx, &domain.Message{RoomID: bobDM, SenderID: bob, Content: "nein"}) {
+		t.Fatal("decline in the direct chat wasn't collected")
+	}
+	if h.consent.Allowed(bob) || h.consent.Get(bob).State != consentDeclined {
+		t.Errorf("bob's decline not recorded: %+v", h.consent.Get(bob))
+	}
+	h.requestConsent(testRoom, bob, domain.GroupRoom)
+	if room := matrix.sent[testRoom]; room[len(room)-1] != h.text(testRoom, bob, "consent.opted_out", i18n.Vars{"prefix": "!henry"}) {
+		t.Errorf("opted-out reply = %q", room)
+	}
+}
+
+func TestConsentFiltersContextAndSummary(t *testing.T) {
+	matrix := newConsentMatrix()
+	ai := &recordingAI{}
+	h := newConsentTestHandler(t, matrix, ai)
+	ctx := context.Background()
+	if err := h.consent.Decide(alice, consentAccepted, alice); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UnixNano() / 1e6
+	matrix.history = []*domain.Message{
+		{ID: "$4", RoomID: testRoom, SenderID: "@henry:example.org", Content: "henry answered", Timestamp: now - 1000, IsFromBot: true},
+		{ID: "$3", RoomID: testRoom, SenderID: "@carol:example.org", Content: "carol wrote", Timestamp: now - 2000},
+		{ID: "$2", RoomID: testRoom, SenderID: bob, Content: "bob wrote", Timestamp: now - 3000},
+		{ID: "$1", RoomID: testRoom, SenderID: alice, Content: "alice wrote", Timestamp: now - 4000},
+	}
+	if err := h.consent.Decide(bob, consentDeclined, bob); err != nil {
+		t.Fatal(err)
+	}
+
+	current := &domain.Message{ID: "$5", RoomID: testRoom, SenderID: alice, Content: "and now?", Timestamp: now}
+	conversation, err := h.getConversationContext(ctx, current, current.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contents []string
+	for _, msg := range conversation {
+		contents = append(contents, msg.Content)
+	}
+	if want := []string{"alice wrote", "henry answered", "and now?"}; !reflect.DeepEqual(contents, want) {
+		t.Errorf("context = %q, want %q", contents, want)
+	}
+
+	inv := &Invocation{RoomID: testRoom, SenderID: alice, EventID: "$5", Lang: "en"}
+	if _, err := h.cmdSummarize(ctx, inv); err != nil {
+		t.Fatal(err)
+	}
+	if len(ai.requests) != 1 {
+		t.Fatalf("%d summary requests, want 1", len(ai.requests))
+	}
+	transcript := ai.requests[0].Messages[0].Content
+	for _, shared := range []string{"alice wrote", "henry answered"} {
+		if !strings.Contains(transcript, shared) {
+			t.Errorf("summary transcript lacks %q:\n%s", shared, transcript)
+		}
+	}
+	for _, withheld := range []string{"bob wrote", "carol wrote"} {
+		if strings.Contains(transcript, withheld) {
+			t.Errorf("summary transcript contains %q:\n%s", withheld, transcript)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/i18n"
+	"github.com/huhndev/gohenry/store"
+)
+
+// digestRoom is a room a user gets digests for, and how far they've read.
+type digestRoom struct {
+	ReadEventID string `json:"read_event_id,omitempty"`
+	ReadAt      int64  `json:"read_at"`
+}
+
+// digestUser holds one user's digest subscriptions.
+type digestUser struct {
+	Rooms      map[string]*digestRoom `json:"rooms"`
+	LastActive int64                  `json:"last_active"`
+}
+
+// DigestService sends opted-in users a private "while you were away"
+// summary of the rooms they subscribed to. Read receipts show when a user
+// is active: the first receipt after HENRY_DIGEST_AWAY_MINUTES of silence
+// triggers a digest of every subscribed room with enough unread messages.
+type DigestService struct {
+	handler *MessageHandler
+	file    *store.File
+
+	mu    sync.Mutex
+	users map[string]*digestUser
+	// running guards against two digests for one user at once.
+	running map[string]bool
+	// saveTimer batches the saves of read positions between digests.
+	saveTimer *time.Timer
+}
+
+// digestSaveDelay is how long read positions from receipts may stay
+// unsaved. Losing them in a crash only makes a digest repeat a little.
+const digestSaveDelay = time.Minute
+
+func NewDigestService(handler *MessageHandler) *DigestService {
+	d := &DigestService{
+		handler: handler,
+		file:    store.NewFile(handler.config.DataDir, "digests.json"),
+		users:   make(map[string]*digestUser),
+		running: make(map[string]bool),
+	}
+	if err := d.file.Load(&d.users); err != nil {
+		log.Printf("WARNING: Failed to load digest subscriptions: %v", err)
+	}
+
+	handler.RegisterCommand(&Command{
+		Name: "digest",
+		Args: []Arg{
+			{Name: "action", Optional: true, Choices: []string{"on", "off", "status"}},
+		},
+		Handler: d.cmdDigest,
+	})
+	handler.RegisterEraser("digests", d.forget)
+	return d
+}
+
+func (d *DigestService) cmdDigest(ctx context.Context, inv *Invocation) (string, error) {
+	action := inv.String("action")
+	if action == "" {
+		action = "status"
+	}
+
+	now := time.Now().UnixNano() / 1e6
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	user := d.users[inv.SenderID]
+
+	switch action {
+	case "on":
+		if inv.RoomType == domain.DirectRoom {
+			return inv.Text("digest.not_here", nil), nil
+		}
+		if user == nil {
+			user = &digestUser{Rooms: make(map[string]*digestRoom), LastActive: now}
+			d.users[inv.SenderID] = user
+		}
+		user.Rooms[inv.RoomID] = &digestRoom{ReadEventID: inv.EventID, ReadAt: now}
+		if err := d.file.Save(d.users); err != nil {
+			return "", fmt.Errorf("failed to save digest subscription: %v", err)
+		}
+		return inv.Text("digest.on", i18n.Vars{
+			"minutes":  d.handler.config.DigestAwayMinutes,
+			"messages": d.handler.config.DigestMinMessages,
+		}), nil
+
+	case "off":
+		if user == nil || user.Rooms[inv.RoomID] == nil {
+			return inv.Text("digest.not_subscribed", nil), nil
+		}
+		delete(user.Rooms, inv.RoomID)
+		if len(user.Rooms) == 0 {
+			delete(d.users, inv.SenderID)
+		}
+		if err := d.file.Save(d.users); err != nil {
+			return "", fmt.Errorf("failed to save digest subscription: %v", err)
+		}
+		return inv.Text("digest.off", nil), nil
+
+	default:
+		if user == nil || len(user.Rooms) == 0 {
+			return inv.Text("digest.none", nil), nil
+		}
+		names := make([]string, 0, len(user.Rooms))
+		for roomID := range user.Rooms {
+			names = append(names, d.handler.matrixService.GetRoomName(roomID))
+		}
+		sort.Strings(names)
+		return inv.Text("digest.status", i18n.Vars{"rooms": strings.Join(names, ", ")}), nil
+	}
+}
+
+// HandleReceipt is called for every read receipt. It records how far the
+// user has read and sends a digest if they were away long enough.
+func (d *DigestService) HandleReceipt(ctx context.Context, roomID, userID, eventID string, ts int64) {
+	d.mu.Lock()
+	user := d.users[userID]
+	if user == nil {
+		d.mu.Unlock()
+		return
+	}
+
+	awayMs := int64(d.handler.config.DigestAwayMinutes) * 60 * 1000
+	cameBack := ts-user.LastActive >= awayMs && !d.running[userID]
+	if ts > user.LastActive {
+		user.LastActive = ts
+	}
+
+	if room := user.Rooms[roomID]; room != nil && ts > room.ReadAt {
+		room.ReadEventID = eventID
+		room.ReadAt = ts
+	}
+
+	var pending map[string]digestRoom
+	if cameBack {
+		pending = make(map[string]digestRoom)
+		for subRoomID, room := range user.Rooms {
+			if subRoomID != roomID {
+				pending[subRoomID] = *room
+			}
+		}
+		d.running[userID] = true
+		d.persistLocked()
+	} else {
+		d.scheduleSaveLocked()
+	}
+	d.mu.Unlock()
+
+	if cameBack {
+		log.Printf("%s is back, checking %d rooms for a digest", userID, len(pending))
+		go d.sendDigest(ctx, userID, pending)
+	}
+}
+
+// sendDigest summarizes what userID missed in each room and sends it as
+// one direct message.
+func (d *DigestService) sendDigest(ctx context.Context, userID string, rooms map[string]digestRoom) {
+	defer func() {
+		d.mu.Lock()
+		delete(d.running, userID)
+		d.mu.Unlock()
+	}()
+
+	if !d.handler.consent.Allowed(userID) {
+		log.Printf("Skipping digest for %s without consent", userID)
+		return
+	}
+
+	roomIDs := make([]string, 0, len(rooms))
+	for roomID := range rooms {
+		roomIDs = append(roomIDs, roomID)
+	}
+	sort.Strings(roomIDs)
+
+	var sections []string
+	for _, roomID := range roomIDs {
+		members, err := d.handler.matrixService.JoinedMembers(roomID)
+		if err != nil {
+			log.Printf("Failed to read members of %s for %s's digest: %v", roomID, userID, err)
+			continue
+		}
+		if _, ok := members[userID]; !ok {
+			log.Printf("%s left %s, dropping their digest subscription", userID, roomID)
+			d.unsubscribe(userID, roomID)
+			continue
+		}
+
+		history, err := d.unread(ctx, userID, roomID, rooms[roomID])
+		if err != nil {
+			log.Printf("Failed to fetch unread messages in %s for %s: %v", roomID, userID, err)
+			continue
+		}
+		if len(history) < d.handler.config.DigestMinMessages {
+			log.Printf("Only %d unread messages in %s for %s, skipping digest",
+				len(history), roomID, userID)
+			continue
+		}
+
+		summary, err := d.handler.summarizeHistory(ctx, roomID, userID, history)
+		if err != nil {
+			log.Printf("Failed to summarize %s for %s: %v", roomID, userID, err)
+			continue
+		}
+		sections = append(sections, d.handler.text(roomID, userID, "digest.section", i18n.Vars{
+			"room":    d.handler.matrixService.GetRoomName(roomID),
+			"count":   len(history),
+			"summary": summary,
+		}))
+
+		d.markDigested(userID, roomID, history[len(history)-1])
+	}
+
+	if len(sections) == 0 {
+		return
+	}
+
+	dmRoom, err := d.handler.matrixService.EnsureDirectRoom(userID)
+	if err != nil {
+		log.Printf("Failed to open direct chat with %s for digest: %v", userID, err)
+		return
+	}
+	message := d.handler.text("", userID, "digest.header", nil) + "\n\n" + strings.Join(sections, "\n\n")
+	if err := d.handler.matrixService.SendMessage(dmRoom, message); err != nil {
+		log.Printf("Failed to send digest to %s: %v", userID, err)
+		return
+	}
+	log.Printf("Sent digest of %d rooms to %s", len(sections), userID)
+}
+
+// unread returns the messages in roomID after the user's read position,
+// leaving out their own messages and bot commands.
+func (d *DigestService) unread(
+	ctx context.Context,
+	userID, roomID string,
+	room digestRoom,
+) ([]*domain.Message, error) {
+	messages, err := d.handler.matrixService.GetRoomHistory(
+		ctx, roomID, "", room.ReadAt+1, d.handler.config.SummaryMaxMessages,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []*domain.Message
+	for _, msg := range messages {
+		if msg.ID == room.ReadEventID || msg.SenderID == userID || !d.handler.mayShare(msg) {
+			continue
+		}
+		if !msg.IsFromBot && d.handler.router.HasPrefix(strings.TrimSpace(msg.Content)) {
+			continue
+		}
+		history = append(history, msg)
+	}
+	return history, nil
+}
+
+// markDigested moves the read position past the digested messages, unless
+// a newer receipt already did.
+func (d *DigestService) markDigested(userID, roomID string, last *domain.Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	user := d.users[userID]
+	if user == nil || user.Rooms[roomID] == nil {
+		return
+	}
+	if room := user.Rooms[roomID]; last.Timestamp > room.ReadAt {
+		room.ReadEventID = last.ID
+		room.ReadAt = last.Timestamp
+	}
+	d.persistLocked()
+}
+
+// unsubscribe drops the user's subscription to roomID.
+func (d *DigestService) unsubscribe(userID, roomID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	user := d.users[userID]
+	if user == nil || user.Rooms[roomID] == nil {
+		return
+	}
+	delete(user.Rooms, roomID)
+	if len(user.Rooms) == 0 {
+		delete(d.users, userID)
+	}
+	d.persistLocked()
+}
+
+// forget deletes the user's subscriptions and read positions.
+func (d *DigestService) forget(userID string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	user := d.users[userID]
+	if user == nil {
+		return 0, nil
+	}
+	delete(d.users, userID)
+	if err := d.file.Save(d.users); err != nil {
+		return 0, fmt.Errorf("failed to save digest subscriptions: %v", err)
+	}
+	return len(user.Rooms), nil
+}
+
+// scheduleSaveLocked saves the subscriptions after digestSaveDelay unless
+// a save is already pending.
+func (d *DigestService) scheduleSaveLocked() {
+	if d.saveTimer != nil {
+		return
+	}
+	d.saveTimer = time.AfterFunc(digestSaveDelay, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.saveTimer = nil
+		d.persistLocked()
+	})
+}
+
+func (d *DigestService) persistLocked() {
+	if d.saveTimer != nil {
+		d.saveTimer.Stop()
+		d.saveTimer = nil
+	}
+	if err := d.file.Save(d.users); err != nil {
+		log.Printf("WARNING: Failed to save digest subscriptions: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package chat
+
+import (
+	"context"
+	"testing"
+)
+
+// membersMatrix reports a fixed member list for every room.
+type membersMatrix struct {
+	*fakeMatrix
+	members map[string]int64
+}
+
+func (m *membersMatrix) JoinedMembers(roomID string) (map[string]int64, error) {
+	return m.members, nil
+}
+
+func TestDigestDropsRoomsTheUserLeft(t *testing.T) {
+	matrix := &membersMatrix{
+		fakeMatrix: &fakeMatrix{accountData: make(map[string][]byte)},
+		members:    map[string]int64{bob: 0},
+	}
+	h := newTestHandler(t, matrix)
+	d := NewDigestService(h)
+	d.users[alice] = &digestUser{Rooms: map[string]*digestRoom{testRoom: {}}}
+
+	// The room's history isn't fetched: fakeMatrix panics if it were.
+	d.sendDigest(context.Background(), alice, map[string]digestRoom{testRoom: {}})
+
+	if _, ok := d.users[alice]; ok {
+		t.Error("subscription to a room alice left was kept")
+	}
+	reloaded := NewDigestService(h)
+	if _, ok := reloaded.users[alice]; ok {
+		t.Error("dropped subscription was not saved")
+	}
+}
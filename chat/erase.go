@@ -0,0 +1,159 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/i18n"
+	"github.com/huhndev/gohenry/room"
+	"github.com/huhndev/gohenry/scheduler"
+)
+
+// Eraser deletes everything one store keeps about a user and returns how
+// many items it removed.
+type Eraser func(userID string) (int, error)
+
+type namedEraser struct {
+	name  string
+	erase Eraser
+}
+
+// ErasureEvent is one entry of the erasure audit log and the report of an
+// erasure. Stores are listed by name.
+type ErasureEvent struct {
+	Time    time.Time         `json:"time"`
+	UserID  string            `json:"user_id"`
+	By      string            `json:"by"`
+	Removed map[string]int    `json:"removed"`
+	Failed  map[string]string `json:"failed,omitempty"`
+}
+
+// RegisterEraser adds a store to those cleared by the erase command. Every
+// store that keeps data keyed to a user must register one.
+func (h *MessageHandler) RegisterEraser(name string, erase Eraser) {
+	h.erasers = append(h.erasers, namedEraser{name: name, erase: erase})
+}
+
+// registerErasers registers the stores the handler owns.
+func (h *MessageHandler) registerErasers() {
+	h.RegisterEraser("memories", h.memories.Forget)
+	h.RegisterEraser("user settings", h.users.Forget)
+	h.RegisterEraser("usage", h.usage.Forget)
+	h.RegisterEraser("resets", h.resets.Forget)
+	h.RegisterEraser("knowledge", h.knowledge.Forget)
+	h.RegisterEraser("search", func(userID string) (int, error) {
+		removed := h.messages.Forget(userID)
+		return removed, h.messages.Flush()
+	})
+	h.RegisterEraser("consent", h.consent.Forget)
+}
+
+// RegisterBotErasers registers the stores the handler shares with the
+// rest of the bot: the scheduler's jobs and the invite decisions.
+func (h *MessageHandler) RegisterBotErasers(sched *scheduler.Scheduler, invites *room.InvitePolicy) {
+	h.RegisterEraser("scheduled jobs", sched.Forget)
+	h.RegisterEraser("invites", invites.Forget)
+}
+
+// loadErasures reads when users were erased from the audit log, which is
+// kept by design, so backfills don't bring their messages back.
+func (h *MessageHandler) loadErasures() {
+	err := h.erasures.Each(func(data []byte) error {
+		var event ErasureEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		h.erasedAt[event.UserID] = event.Time.UnixNano() / 1e6
+		return nil
+	})
+	if err != nil {
+		log.Printf("WARNING: Failed to load erasure audit log: %v", err)
+	}
+}
+
+// erased reports whether the message was sent before its sender's data
+// was erased. Such messages must not be indexed again.
+func (h *MessageHandler) erased(msg *domain.Message) bool {
+	h.erasedMu.Lock()
+	defer h.erasedMu.Unlock()
+	erasedAt, ok := h.erasedAt[msg.SenderID]
+	return ok && msg.Timestamp <= erasedAt
+}
+
+// EraseUser deletes the user's data from every store and records who did
+// it in erasure_audit.jsonl. A failing store doesn't stop the others; it's
+// listed in the event's Failed.
+func (h *MessageHandler) EraseUser(userID, by string) (ErasureEvent, error) {
+	event := ErasureEvent{
+		Time:    time.Now().UTC(),
+		UserID:  userID,
+		By:      by,
+		Removed: make(map[string]int),
+	}
+	// Recorded first, so a backfill running meanwhile skips the messages.
+	h.erasedMu.Lock()
+	h.erasedAt[userID] = event.Time.UnixNano() / 1e6
+	h.erasedMu.Unlock()
+	for _, e := range h.erasers {
+		removed, err := e.erase(userID)
+		if err != nil {
+			log.Printf("WARNING: Failed to erase %s data of %s: %v", e.name, userID, err)
+			if event.Failed == nil {
+				event.Failed = make(map[string]string)
+			}
+			event.Failed[e.name] = err.Error()
+			continue
+		}
+		event.Removed[e.name] = removed
+	}
+
+	if err := h.erasures.Append(event); err != nil {
+		return event, fmt.Errorf("failed to audit erasure: %v", err)
+	}
+	log.Printf("Erased data of %s for %s: removed %v, failed %d stores", userID, by, event.Removed, len(event.Failed))
+	return event, nil
+}
+
+func (h *MessageHandler) cmdErase(ctx context.Context, inv *Invocation) (string, error) {
+	words := strings.Fields(inv.String("request"))
+	userID := inv.SenderID
+	if len(words) > 0 && strings.HasPrefix(words[0], "@") {
+		userID = words[0]
+		words = words[1:]
+		if !strings.Contains(userID, ":") {
+			return inv.Text("erase.bad_user", i18n.Vars{"user": userID}), nil
+		}
+		if userID != inv.SenderID && !h.router.allowed(PermissionOwner, inv.RoomID, inv.SenderID) {
+			return inv.Text("erase.denied", nil), nil
+		}
+	}
+
+	if len(words) != 1 || strings.ToLower(words[0]) != "confirm" {
+		command := h.config.CommandPrefix + " erase confirm"
+		if userID != inv.SenderID {
+			command = h.config.CommandPrefix + " erase " + userID + " confirm"
+		}
+		return inv.Text("erase.confirm", i18n.Vars{"user": userID, "command": command}), nil
+	}
+
+	event, err := h.EraseUser(userID, inv.SenderID)
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{inv.Text("erase.done", i18n.Vars{"user": userID})}
+	for _, e := range h.erasers {
+		if reason, failed := event.Failed[e.name]; failed {
+			lines = append(lines, inv.Text("erase.failed", i18n.Vars{"store": e.name, "error": reason}))
+			continue
+		}
+		lines = append(lines, inv.Text("erase.item", i18n.Vars{"store": e.name, "count": event.Removed[e.name]}))
+	}
+	lines = append(lines, inv.Text("erase.kept", nil))
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,362 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/huhndev/gohenry/config"
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/knowledge"
+	"github.com/huhndev/gohenry/room"
+	"github.com/huhndev/gohenry/scheduler"
+)
+
+const (
+	testRoom = "!room:example.org"
+	alice    = "@alice:example.org"
+	bob      = "@bob:example.org"
+)
+
+// fakeMatrix keeps account data and power levels in memory. Other
+// methods aren't needed by the stores and panic if called.
+type fakeMatrix struct {
+	domain.MatrixService
+	accountData map[string][]byte
+	powerLevels map[string]int
+}
+
+func (m *fakeMatrix) GetUserPowerLevel(roomID, userID string) (int, error) {
+	return m.powerLevels[userID], nil
+}
+
+func (m *fakeMatrix) GetAccountData(eventType string, v interface{}) error {
+	data, ok := m.accountData[eventType]
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (m *fakeMatrix) SetAccountData(eventType string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	m.accountData[eventType] = data
+	return nil
+}
+
+func (m *fakeMatrix) GetBotUserID() string {
+	return "@henry:example.org"
+}
+
+// newTestHandler builds a handler on matrix with a fresh data dir and
+// the default settings.
+func newTestHandler(t *testing.T, matrix domain.MatrixService) *MessageHandler {
+	cfg := &config.Config{DataDir: t.TempDir(), Timezone: "UTC", Locale: "en", CommandPrefix: "!henry"}
+	return NewMessageHandler(cfg, matrix, nil, nil)
+}
+
+// newEraseTestHandler builds a handler with every service that keeps user
+// data, registered the way NewBot does.
+func newEraseTestHandler(t *testing.T) (*MessageHandler, *fakeMatrix, string) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		DataDir:           dir,
+		Timezone:          "UTC",
+		Locale:            "en",
+		CommandPrefix:     "!henry",
+		SearchMaxMessages: 100,
+		ConsentRequired:   true,
+		InvitePolicy:      room.InviteModeAsk,
+	}
+
+	invites := `{"!invited:example.org": {"room_id": "!invited:example.org", "inviter_id": "` + alice +
+		`", "status": "approved", "decided_by": "` + bob + `"}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "invites.json"), []byte(invites), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	matrix := &fakeMatrix{accountData: make(map[string][]byte)}
+	h := NewMessageHandler(cfg, matrix, nil, nil)
+	h.LoadUserSettings(context.Background())
+	invitePolicy := room.NewInvitePolicy(matrix, cfg)
+	sched := scheduler.New(dir, scheduler.SystemClock{})
+	NewReminderService(h, sched)
+	NewScheduleService(h, sched)
+	standups := NewStandupService(h, sched, nil)
+	digests := NewDigestService(h)
+	h.RegisterBotErasers(sched, invitePolicy)
+
+	for _, user := range []string{alice, bob} {
+		name := strings.TrimPrefix(strings.Split(user, ":")[0], "@")
+		h.usage.Record(testRoom, user, 10, 20)
+		if err := h.users.SetTimezone(user, "Europe/Berlin"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := h.memories.Add(user, name+" likes tea"); err != nil {
+			t.Fatal(err)
+		}
+		doc := knowledge.Document{RoomID: testRoom, Name: name + ".md", Source: knowledge.SourceUpload, AddedBy: user}
+		if _, err := h.knowledge.Add(doc, "# Notes\n\nNotes by "+name); err != nil {
+			t.Fatal(err)
+		}
+		h.messages.Add(&domain.Message{
+			ID:        "$" + name,
+			RoomID:    testRoom,
+			SenderID:  user,
+			Content:   "hello from " + name,
+			Timestamp: 1,
+		})
+		if err := h.consent.Decide(user, consentAccepted, user); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sched.Add(scheduler.Job{
+			Kind:   reminderJobKind,
+			Due:    time.Now().Add(time.Hour),
+			RoomID: testRoom,
+			UserID: user,
+			Text:   "tea for " + name,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		digests.users[user] = &digestUser{Rooms: map[string]*digestRoom{testRoom: {ReadAt: 1}}}
+	}
+	digests.persistLocked()
+
+	if err := h.resets.Set(testRoom, "", ResetMarker{EventID: "$reset", Timestamp: 1, SenderID: alice}); err != nil {
+		t.Fatal(err)
+	}
+
+	standups.runs["daily"] = &standupRun{
+		DMRooms: map[string]string{alice: "!dm-alice:example.org", bob: "!dm-bob:example.org"},
+		Answers: map[string][]string{alice: {"alice fixed the build"}, bob: {"bob wrote docs"}},
+		Nudged:  map[string]bool{alice: true},
+	}
+	standups.persistLocked()
+	if err := h.messages.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return h, matrix, dir
+}
+
+// storedData returns every file in the data directory except the audit
+// logs, which are kept by design, and the account data.
+func storedData(t *testing.T, matrix *fakeMatrix, dir string) map[string]string {
+	data := make(map[string]string)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), "_audit.jsonl") {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[file.Name()] = string(content)
+	}
+	for eventType, content := range matrix.accountData {
+		data["account data "+eventType] = string(content)
+	}
+	return data
+}
+
+func TestEraseUserLeavesNoData(t *testing.T) {
+	h, matrix, dir := newEraseTestHandler(t)
+
+	before := storedData(t, matrix, dir)
+	for _, name := range []string{"usage.json", "resets.json", "memories.json", "knowledge.json",
+		"search_index.json", "consent.json", "scheduler.json", "digests.json", "standups.json", "invites.json"} {
+		if !strings.Contains(strings.ToLower(before[name]), "alice") {
+			t.Fatalf("setup: %s has no data of alice", name)
+		}
+	}
+
+	event, err := h.EraseUser(alice, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Failed) > 0 {
+		t.Fatalf("failed stores: %v", event.Failed)
+	}
+	for _, e := range h.erasers {
+		if event.Removed[e.name] == 0 {
+			t.Errorf("%s removed nothing", e.name)
+		}
+	}
+
+	for name, content := range storedData(t, matrix, dir) {
+		if strings.Contains(strings.ToLower(content), "alice") {
+			t.Errorf("%s still has data of alice:\n%s", name, content)
+		}
+	}
+
+	for _, name := range []string{"memories.json", "knowledge.json", "search_index.json", "consent.json",
+		"scheduler.json", "digests.json", "standups.json"} {
+		if !strings.Contains(storedData(t, matrix, dir)[name], "bob") {
+			t.Errorf("%s lost the data of bob", name)
+		}
+	}
+	if len(h.memories.List(bob)) != 1 {
+		t.Errorf("bob's memories are gone")
+	}
+}
+
+func TestEraseUserIsAudited(t *testing.T) {
+	h, _, _ := newEraseTestHandler(t)
+
+	if _, err := h.EraseUser(alice, "@owner:example.org"); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []ErasureEvent
+	err := h.erasures.Each(func(data []byte) error {
+		var event ErasureEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].UserID != alice || events[0].By != "@owner:example.org" {
+		t.Fatalf("audit log = %+v", events)
+	}
+	if events[0].Removed["memories"] != 1 {
+		t.Errorf("audit removed = %v", events[0].Removed)
+	}
+}
+
+func TestEraseKeepsDecline(t *testing.T) {
+	h, _, _ := newEraseTestHandler(t)
+	if err := h.consent.Decide(alice, consentDeclined, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.EraseUser(alice, alice); err != nil {
+		t.Fatal(err)
+	}
+	if h.consent.Allowed(alice) {
+		t.Error("alice's messages are allowed again after erasing a decline")
+	}
+	if record := h.consent.Get(alice); record.NoticeVersion != "" || !record.DecidedAt.IsZero() {
+		t.Errorf("decline kept more than its state: %+v", record)
+	}
+}
+
+func TestEraseCommand(t *testing.T) {
+	h, matrix, dir := newEraseTestHandler(t)
+	const (
+		owner    = "@owner:example.org"
+		admin    = "@admin:example.org"
+		ownRoom  = "!own:example.org"
+		intruder = "@mallory:example.org"
+	)
+	h.config.OwnerID = owner
+	// An admin of the room and the creator of another room, where they
+	// hold power level 100, both count only as room admins.
+	matrix.powerLevels = map[string]int{admin: 100, alice: 50, intruder: 100}
+	run := func(roomID, sender, request string) string {
+		inv := &Invocation{
+			RoomID:   roomID,
+			SenderID: sender,
+			Lang:     "en",
+			values:   map[string]interface{}{"request": request},
+		}
+		reply, err := h.cmdErase(context.Background(), inv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	if reply := run(testRoom, alice, ""); !strings.Contains(reply, "!henry erase confirm") {
+		t.Errorf("no confirmation asked: %q", reply)
+	}
+	if reply := run(testRoom, alice, bob+" confirm"); !strings.Contains(reply, "Only the bot owner") {
+		t.Errorf("alice, a moderator, erased bob: %q", reply)
+	}
+	if reply := run(testRoom, admin, bob+" confirm"); !strings.Contains(reply, "Only the bot owner") {
+		t.Errorf("a room admin erased bob: %q", reply)
+	}
+	if reply := run(ownRoom, intruder, bob+" confirm"); !strings.Contains(reply, "Only the bot owner") {
+		t.Errorf("the admin of an unrelated room erased bob: %q", reply)
+	}
+	if len(h.memories.List(alice)) != 1 || len(h.memories.List(bob)) != 1 {
+		t.Fatal("data was erased without confirmation or permission")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "erasure_audit.jsonl")); !os.IsNotExist(err) {
+		t.Error("audit entry written without an erasure")
+	}
+
+	reply := run(testRoom, alice, "confirm")
+	if !strings.Contains(reply, "- memories: 1 removed") {
+		t.Errorf("report = %q", reply)
+	}
+	if len(h.memories.List(alice)) != 0 || len(h.memories.List(bob)) != 1 {
+		t.Error("confirm didn't erase exactly alice's data")
+	}
+
+	if reply := run(ownRoom, owner, bob+" confirm"); !strings.Contains(reply, "Deleted the data of "+bob) {
+		t.Errorf("owner couldn't erase bob: %q", reply)
+	}
+	if len(h.memories.List(bob)) != 0 {
+		t.Error("bob's memories survived")
+	}
+}
+
+// backfillMatrix serves a fixed room history to backfills.
+type backfillMatrix struct {
+	*fakeMatrix
+	history []*domain.Message
+}
+
+func (m *backfillMatrix) JoinedMembers(roomID string) (map[string]int64, error) {
+	return map[string]int64{m.GetBotUserID(): 0, alice: 0, bob: 0}, nil
+}
+
+func (m *backfillMatrix) GetRoomHistory(
+	ctx context.Context, roomID, threadID string, since int64, limit int,
+) ([]*domain.Message, error) {
+	return m.history, nil
+}
+
+func TestBackfillSkipsErasedMessages(t *testing.T) {
+	matrix := &backfillMatrix{fakeMatrix: &fakeMatrix{accountData: make(map[string][]byte)}}
+	cfg := &config.Config{DataDir: t.TempDir(), Timezone: "UTC", Locale: "en", SearchBackfill: 100}
+	h := NewMessageHandler(cfg, matrix, nil, nil)
+
+	if _, err := h.EraseUser(alice, alice); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UnixNano() / 1e6
+	matrix.history = []*domain.Message{
+		{ID: "$old", RoomID: testRoom, SenderID: alice, Content: "old secret plans", Timestamp: now - 60000},
+		{ID: "$bob", RoomID: testRoom, SenderID: bob, Content: "bob's plans", Timestamp: now - 30000},
+		{ID: "$new", RoomID: testRoom, SenderID: alice, Content: "new plans", Timestamp: now + 60000},
+	}
+
+	// A restart reads the erasure back from the audit log.
+	h = NewMessageHandler(cfg, matrix, nil, nil)
+	h.backfill(context.Background(), testRoom)
+	var ids []string
+	for _, hit := range h.messages.Search(map[string]int64{testRoom: 0}, "plans", "", 10) {
+		ids = append(ids, hit.ID)
+	}
+	sort.Strings(ids)
+	if strings.Join(ids, " ") != "$bob $new" {
+		t.Errorf("backfill indexed %v, want $bob and $new", ids)
+	}
+}
@@ -2,45 +2,133 @@ package chat
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/huhndev/gohenry/access"
 	"github.com/huhndev/gohenry/config"
 	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/knowledge"
+	"github.com/huhndev/gohenry/search"
+	"github.com/huhndev/gohenry/store"
 )
 
+// deniedReplyCooldown limits how often a denied user is told so in a room.
+const deniedReplyCooldown = time.Hour
+
 // MessageHandler processes incoming messages and decides how to respond
 type MessageHandler struct {
 	config        *config.Config
 	matrixService domain.MatrixService
 	aiService     domain.AIService
+	accessPolicy  *access.Policy
+	router        *CommandRouter
+	settings      *SettingsStore
+	resets        *ResetStore
+	usage         *UsageTracker
+	users         *UserSettingsStore
+	memories      *MemoryStore
+	knowledge     *knowledge.Index
+	messages      *search.Index
+	consent       *ConsentStore
+	// consentNoticeText replaces the catalog's consent notice when set.
+	consentNoticeText string
+	erasures          *store.Log
+	tools             []ToolFactory
+	interceptors      []Interceptor
+	erasers           []namedEraser
+
+	backfillMu  sync.Mutex
+	backfilling map[string]bool
+
+	// erasedAt maps erased users to when they were last erased, in ms.
+	erasedMu sync.Mutex
+	erasedAt map[string]int64
+
+	deniedMu        sync.Mutex
+	deniedRepliedAt map[string]time.Time
 }
 
 func NewMessageHandler(
 	cfg *config.Config,
 	matrixService domain.MatrixService,
 	aiService domain.AIService,
+	accessPolicy *access.Policy,
 ) *MessageHandler {
-	return &MessageHandler{
-		config:        cfg,
-		matrixService: matrixService,
-		aiService:     aiService,
+	h := &MessageHandler{
+		config:            cfg,
+		matrixService:     matrixService,
+		aiService:         aiService,
+		accessPolicy:      accessPolicy,
+		router:            NewCommandRouter(cfg, matrixService),
+		settings:          NewSettingsStore(cfg.DataDir),
+		resets:            NewResetStore(cfg.DataDir),
+		usage:             NewUsageTracker(cfg.DataDir),
+		users:             NewUserSettingsStore(matrixService, cfg.DataDir, cfg.Timezone, defaultLocale(cfg.Locale)),
+		memories:          NewMemoryStore(cfg.DataDir),
+		knowledge:         knowledge.NewIndex(cfg.DataDir),
+		messages:          search.NewIndex(cfg.DataDir, cfg.SearchMaxMessages),
+		consent:           NewConsentStore(cfg.DataDir, cfg.ConsentRequired),
+		consentNoticeText: loadConsentNotice(cfg.ConsentNoticeFile),
+		erasures:          store.NewLog(cfg.DataDir, "erasure_audit.jsonl"),
+		deniedRepliedAt:   make(map[string]time.Time),
+		backfilling:       make(map[string]bool),
+		erasedAt:          make(map[string]int64),
+	}
+	h.registerBuiltins()
+	h.registerSummaryCommand()
+	h.registerErasers()
+	h.loadErasures()
+	h.RegisterTool(h.memoryTool)
+	h.RegisterTool(h.searchTool)
+	h.RegisterTool(h.convertTimeTool)
+	h.RegisterInterceptor(h.collectConsent)
+	h.RegisterInterceptor(h.indexAttachment)
+	if err := h.syncKnowledgeDir(); err != nil {
+		log.Printf("WARNING: Failed to index knowledge dir: %v", err)
 	}
+	return h
 }
 
-// HandleMessage processes an incoming Matrix message
-func (h *MessageHandler) HandleMessage(
-	ctx context.Context,
-	senderID, roomID, content string,
-) error {
-	if content == "" {
-		return nil
-	}
+// ToolFactory builds a Claude tool bound to the message being answered,
+// so the tool knows who asked and where.
+type ToolFactory func(msg *domain.Message) domain.Tool
 
-	if !h.matrixService.IsFromAllowedDomain(senderID) {
-		log.Printf("Ignoring message from non-allowed domain: %s", senderID)
+// RegisterTool makes a tool available to Claude for every answer.
+func (h *MessageHandler) RegisterTool(factory ToolFactory) {
+	h.tools = append(h.tools, factory)
+}
+
+// Interceptor sees addressed and unaddressed messages before anything
+// else. It returns true if it consumed the message.
+type Interceptor func(ctx context.Context, msg *domain.Message) bool
+
+// RegisterInterceptor adds an interceptor, e.g. to collect answers to a
+// question Henry asked.
+func (h *MessageHandler) RegisterInterceptor(interceptor Interceptor) {
+	h.interceptors = append(h.interceptors, interceptor)
+}
+
+// RegisterCommand adds a chat command next to the built-in ones.
+func (h *MessageHandler) RegisterCommand(cmd *Command) {
+	h.router.Register(cmd)
+}
+
+// LoadUserSettings reads the user settings from the homeserver, retrying
+// until it succeeds or ctx is done. Call it once connected.
+func (h *MessageHandler) LoadUserSettings(ctx context.Context) {
+	h.users.Load(ctx)
+}
+
+// HandleMessage processes an incoming Matrix message. Replies go to the
+// message's thread, if it has one.
+func (h *MessageHandler) HandleMessage(ctx context.Context, msg *domain.Message) error {
+	senderID, roomID, threadID, content := msg.SenderID, msg.RoomID, msg.ThreadID, msg.Content
+	if content == "" && msg.Attachment == nil {
 		return nil
 	}
 
@@ -49,12 +137,54 @@ func (h *MessageHandler) HandleMessage(
 		return fmt.Errorf("error determining room type: %v", err)
 	}
 
-	if !h.matrixService.IsAddressedToBot(content, roomType) {
+	if msg.SenderName == "" {
+		msg.SenderName = h.matrixService.GetDisplayName(roomID, senderID)
+	}
+
+	isCommand := h.router.HasPrefix(strings.TrimSpace(content))
+	if !isCommand {
+		if h.mayShare(msg) {
+			h.messages.Add(msg)
+		}
+		for _, intercept := range h.interceptors {
+			if intercept(ctx, msg) {
+				return nil
+			}
+		}
+	}
+	if !isCommand && !h.matrixService.IsAddressedToBot(content, roomType) {
+		return nil
+	}
+
+	if decision := h.accessPolicy.Check(roomID, senderID); !decision.Allowed {
+		log.Printf("Ignoring message from %s in room %s: %s", senderID, roomID, decision.Reason)
+		h.replyDenied(roomID, senderID, decision.Reply)
 		return nil
 	}
 
 	messageText := content
-	if roomType == domain.GroupRoom {
+	if isCommand {
+		inv := &Invocation{
+			RoomID:    roomID,
+			SenderID:  senderID,
+			EventID:   msg.ID,
+			Timestamp: msg.Timestamp,
+			ThreadID:  threadID,
+			RoomType:  roomType,
+			Lang:      h.language(roomID, senderID),
+		}
+		reply, remainder, handled := h.router.Dispatch(ctx, inv, strings.TrimSpace(content))
+		if handled {
+			if reply == "" {
+				return nil
+			}
+			if err := h.matrixService.SendThreadMessage(roomID, threadID, reply); err != nil {
+				return fmt.Errorf("error sending command reply: %v", err)
+			}
+			return nil
+		}
+		messageText = remainder
+	} else if roomType == domain.GroupRoom {
 		fullUsername := strings.TrimPrefix(h.matrixService.GetBotUserID(), "@")
 
 		localpart := fullUsername
@@ -80,7 +210,13 @@ func (h *MessageHandler) HandleMessage(
 		return nil
 	}
 
-	contextMessages, err := h.getConversationContext(ctx, senderID, roomID, messageText)
+	if !h.consent.Allowed(senderID) {
+		log.Printf("Not answering %s in room %s without consent", senderID, roomID)
+		h.requestConsent(roomID, senderID, roomType)
+		return nil
+	}
+
+	contextMessages, err := h.getConversationContext(ctx, msg, messageText)
 	if err != nil {
 		log.Printf("Error getting conversation context: %v", err)
 		contextMessages = []domain.ConversationMessage{
@@ -101,15 +237,29 @@ func (h *MessageHandler) HandleMessage(
 	}
 
 	log.Printf("Generating response for message in room %s", roomID)
-	aiResponse, err := h.aiService.GenerateResponse(ctx, contextMessages)
+	settings := h.settings.Get(roomID)
+	aiResponse, err := h.aiService.GenerateResponse(ctx, domain.AIRequest{
+		Messages: contextMessages,
+		Model:    settings.Model,
+		Persona:  settings.Persona,
+		Room:     h.roomContext(roomID),
+		Memories: h.promptMemories(roomType, senderID, messageText),
+		Location: h.users.Location(senderID),
+		Locale:   h.users.Locale(senderID),
+		Excerpts: h.excerpts(roomID, messageText),
+		Tools:    h.toolsFor(msg),
+	})
 	if err != nil {
 		log.Printf("Error generating response: %v", err)
-		if err := h.matrixService.SendMessage(roomID, "Sorry, I'm having trouble thinking right now."); err != nil {
+		reply := h.text(roomID, senderID, "error.thinking", nil)
+		if err := h.matrixService.SendThreadMessage(roomID, threadID, reply); err != nil {
 			log.Printf("Error sending error message: %v", err)
 		}
 		return fmt.Errorf("error generating response: %v", err)
 	}
 
+	h.usage.Record(roomID, senderID, aiResponse.InputTokens, aiResponse.OutputTokens)
+
 	responseTimestamp := time.Now().UnixNano() / 1e6
 	log.Printf("Generated bot response for room %s at timestamp %d", roomID, responseTimestamp)
 
@@ -117,7 +267,7 @@ func (h *MessageHandler) HandleMessage(
 		log.Printf("Error stopping typing notification: %v", err)
 	}
 
-	if err := h.matrixService.SendMessage(roomID, aiResponse); err != nil {
+	if err := h.matrixService.SendThreadMessage(roomID, threadID, aiResponse.Content); err != nil {
 		return fmt.Errorf("error sending response: %v", err)
 	}
 
@@ -125,12 +275,36 @@ func (h *MessageHandler) HandleMessage(
 	return nil
 }
 
+// replyDenied tells a denied user why Henry won't answer, at most once per
+// cooldown period per room, so repeated attempts don't flood the room.
+func (h *MessageHandler) replyDenied(roomID, senderID, reply string) {
+	if reply == "" {
+		return
+	}
+
+	key := roomID + "|" + senderID
+	h.deniedMu.Lock()
+	if last, ok := h.deniedRepliedAt[key]; ok && time.Since(last) < deniedReplyCooldown {
+		h.deniedMu.Unlock()
+		return
+	}
+	h.deniedRepliedAt[key] = time.Now()
+	h.deniedMu.Unlock()
+
+	if err := h.matrixService.SendMessage(roomID, reply); err != nil {
+		log.Printf("Error sending access denied reply: %v", err)
+	}
+}
+
+// getConversationContext assembles the recent messages of the current
+// message's thread, or of the main timeline, back to the last reset.
 func (h *MessageHandler) getConversationContext(
 	ctx context.Context,
-	senderID string,
-	roomID string,
+	current *domain.Message,
 	currentMessage string,
 ) ([]domain.ConversationMessage, error) {
+	senderID, roomID, threadID := current.SenderID, current.RoomID, current.ThreadID
+
 	roomType, err := h.matrixService.GetRoomType(ctx, roomID)
 	if err != nil {
 		log.Printf("Error getting room type: %v, defaulting to direct message behavior", err)
@@ -148,8 +322,13 @@ func (h *MessageHandler) getConversationContext(
 		log.Printf("Error getting Matrix messages: %v", err)
 		recentMessages = []*domain.Message{}
 	} else {
+		marker, hasMarker := h.resets.Get(roomID, threadID)
 		for _, msg := range matrixMessages {
-			if msg.Content == "" {
+			if hasMarker && marker.covers(msg.ID, msg.Timestamp) {
+				log.Printf("Reached reset marker %s in room %s", marker.EventID, roomID)
+				break
+			}
+			if msg.Content == "" || !inThread(msg, threadID) || !h.mayShare(msg) {
 				continue
 			}
 			recentMessages = append(recentMessages, msg)
@@ -176,6 +355,9 @@ func (h *MessageHandler) getConversationContext(
 		if msg.Content == "" {
 			continue
 		}
+		if !msg.IsFromBot && h.router.HasPrefix(strings.TrimSpace(msg.Content)) {
+			continue
+		}
 
 		role := domain.RoleUser
 		if msg.IsFromBot {
@@ -206,10 +388,11 @@ func (h *MessageHandler) getConversationContext(
 
 		if msgContent != "" {
 			conversationMessages = append(conversationMessages, domain.ConversationMessage{
-				Role:      role,
-				Content:   msgContent,
-				Timestamp: msg.Timestamp,
-				SenderID:  msg.SenderID,
+				Role:       role,
+				Content:    msgContent,
+				Timestamp:  msg.Timestamp,
+				SenderID:   msg.SenderID,
+				SenderName: msg.SenderName,
 			})
 		}
 	}
@@ -230,12 +413,98 @@ func (h *MessageHandler) getConversationContext(
 	if !currentMessageExists {
 		log.Printf("Adding current message to context")
 		conversationMessages = append(conversationMessages, domain.ConversationMessage{
-			Role:      domain.RoleUser,
-			Content:   currentMessage,
-			Timestamp: currentTimestamp,
-			SenderID:  senderID,
+			Role:       domain.RoleUser,
+			Content:    currentMessage,
+			Timestamp:  currentTimestamp,
+			SenderID:   senderID,
+			SenderName: current.SenderName,
 		})
 	}
 
 	return conversationMessages, nil
 }
+
+// inThread reports whether msg belongs to the thread rooted at threadID.
+// An empty threadID means the main timeline; thread roots belong to both.
+// queuedAsSent treats a message the outbox still holds as sent. It goes
+// out once the homeserver is back, so a scheduler retry would post it
+// twice.
+func queuedAsSent(err error) error {
+	if errors.Is(err, domain.ErrStillQueued) {
+		log.Printf("Message is still queued, not retrying: %v", err)
+		return nil
+	}
+	return err
+}
+
+func inThread(msg *domain.Message, threadID string) bool {
+	if threadID == "" {
+		return msg.ThreadID == ""
+	}
+	return msg.ThreadID == threadID || msg.ID == threadID
+}
+
+func (h *MessageHandler) toolsFor(msg *domain.Message) []domain.Tool {
+	tools := make([]domain.Tool, 0, len(h.tools))
+	for _, factory := range h.tools {
+		tools = append(tools, factory(msg))
+	}
+	return tools
+}
+
+// RunPrompt answers text as if requesterID had sent it to roomID, without
+// a conversation history, and returns the answer. Prefixed text runs the
+// command instead. Errors are returned rather than posted, so callers such
+// as the scheduler can retry.
+func (h *MessageHandler) RunPrompt(ctx context.Context, roomID, requesterID, text string) (string, error) {
+	if !h.consent.Allowed(requesterID) {
+		return "", fmt.Errorf("%s has not consented to sending data to Claude", requesterID)
+	}
+	if h.router.HasPrefix(text) {
+		if cmd, args, ok := h.router.lookup(text); ok {
+			if !h.router.allowed(cmd.Permission, roomID, requesterID) {
+				return "", fmt.Errorf("%s may no longer use %s", requesterID, cmd.Name)
+			}
+			roomType, err := h.matrixService.GetRoomType(ctx, roomID)
+			if err != nil {
+				return "", fmt.Errorf("error determining room type: %v", err)
+			}
+			inv := &Invocation{
+				RoomID:   roomID,
+				SenderID: requesterID,
+				RoomType: roomType,
+				Command:  cmd,
+				Lang:     h.language(roomID, requesterID),
+			}
+			return h.router.run(ctx, inv, args)
+		}
+		text = strings.TrimSpace(text[len(h.config.CommandPrefix):])
+	}
+
+	msg := &domain.Message{
+		RoomID:    roomID,
+		SenderID:  requesterID,
+		Content:   text,
+		Timestamp: time.Now().UnixNano() / 1e6,
+	}
+	settings := h.settings.Get(roomID)
+	resp, err := h.aiService.GenerateResponse(ctx, domain.AIRequest{
+		Messages: []domain.ConversationMessage{{
+			Role:      domain.RoleUser,
+			Content:   text,
+			Timestamp: msg.Timestamp,
+			SenderID:  requesterID,
+		}},
+		Model:    settings.Model,
+		Persona:  settings.Persona,
+		Room:     h.roomContext(roomID),
+		Location: h.users.Location(requesterID),
+		Locale:   h.users.Locale(requesterID),
+		Tools:    h.toolsFor(msg),
+	})
+	if err != nil {
+		return "", err
+	}
+	h.usage.Record(roomID, requesterID, resp.InputTokens, resp.OutputTokens)
+	return resp.Content, nil
+}
@@ -0,0 +1,193 @@
+package chat
+
+import (
+	"context"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/i18n"
+	"github.com/huhndev/gohenry/knowledge"
+)
+
+const (
+	// maxDocumentSize is the largest file accepted into a knowledge base.
+	maxDocumentSize = 1 << 20
+	// maxExcerpts is how many knowledge base chunks go into one request.
+	maxExcerpts = 4
+	// maxExcerptsLength caps the total length of those chunks.
+	maxExcerptsLength = 6000
+)
+
+// syncKnowledgeDir indexes the configured documentation directory.
+func (h *MessageHandler) syncKnowledgeDir() error {
+	if h.config.KnowledgeDir == "" {
+		return nil
+	}
+	added, updated, removed, err := h.knowledge.SyncDir(h.config.KnowledgeDir)
+	if err != nil {
+		return err
+	}
+	log.Printf("Indexed %s: %d documents added, %d updated, %d removed",
+		h.config.KnowledgeDir, added, updated, removed)
+	return nil
+}
+
+// indexAttachment adds Markdown and text files that are sent to Henry, by
+// DM or with a caption mentioning Henry, to the room's knowledge base.
+func (h *MessageHandler) indexAttachment(ctx context.Context, msg *domain.Message) bool {
+	file := msg.Attachment
+	if file == nil || !knowledge.IsDocument(file.Name, file.MimeType) {
+		return false
+	}
+	roomType, err := h.matrixService.GetRoomType(ctx, msg.RoomID)
+	if err != nil || !h.matrixService.IsAddressedToBot(msg.Content, roomType) {
+		return false
+	}
+	if decision := h.accessPolicy.Check(msg.RoomID, msg.SenderID); !decision.Allowed || !h.mayShare(msg) {
+		return false
+	}
+
+	reply := h.addDocument(ctx, msg)
+	if err := h.matrixService.SendThreadMessage(msg.RoomID, msg.ThreadID, reply); err != nil {
+		log.Printf("Failed to confirm knowledge upload: %v", err)
+	}
+	return true
+}
+
+func (h *MessageHandler) addDocument(ctx context.Context, msg *domain.Message) string {
+	lang := h.language(msg.RoomID, msg.SenderID)
+	file := msg.Attachment
+	if file.Size > maxDocumentSize {
+		return i18n.Text(lang, "kb.too_large", i18n.Vars{"name": file.Name, "max": maxDocumentSize >> 10})
+	}
+	text, err := h.downloadDocument(ctx, file.URL)
+	if err != nil {
+		log.Printf("Failed to fetch knowledge document %s: %v", file.Name, err)
+		return i18n.Text(lang, "kb.read_failed", i18n.Vars{"name": file.Name, "error": i18n.ErrorText(lang, err)})
+	}
+
+	doc, err := h.knowledge.Add(knowledge.Document{
+		RoomID:  msg.RoomID,
+		Name:    file.Name,
+		Source:  knowledge.SourceUpload,
+		URL:     file.URL,
+		AddedBy: msg.SenderID,
+	}, text)
+	if err != nil {
+		return i18n.Text(lang, "kb.index_failed", i18n.Vars{"name": file.Name, "error": i18n.ErrorText(lang, err)})
+	}
+	log.Printf("Added knowledge document %s (%s) with %d chunks to room %s", doc.ID, doc.Name, len(doc.Chunks), msg.RoomID)
+	return i18n.Text(lang, "kb.added", i18n.Vars{"name": doc.Name, "id": doc.ID})
+}
+
+func (h *MessageHandler) downloadDocument(ctx context.Context, url string) (string, error) {
+	data, err := h.matrixService.DownloadMedia(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxDocumentSize {
+		return "", i18n.NewError("kb.file_too_large", i18n.Vars{"max": maxDocumentSize >> 10})
+	}
+	if !utf8.Valid(data) {
+		return "", i18n.NewError("kb.not_utf8", nil)
+	}
+	return string(data), nil
+}
+
+// excerpts returns the knowledge base chunks that best match text.
+func (h *MessageHandler) excerpts(roomID, text string) []domain.Excerpt {
+	var excerpts []domain.Excerpt
+	length := 0
+	for _, result := range h.knowledge.Search(roomID, text, maxExcerpts) {
+		if length+len(result.Text) > maxExcerptsLength {
+			break
+		}
+		length += len(result.Text)
+		excerpts = append(excerpts, domain.Excerpt{Source: result.Source(), Text: result.Text})
+	}
+	return excerpts
+}
+
+func (h *MessageHandler) cmdKnowledge(ctx context.Context, inv *Invocation) (string, error) {
+	words := strings.Fields(inv.String("request"))
+	if len(words) == 0 {
+		words = []string{"list"}
+	}
+
+	switch strings.ToLower(words[0]) {
+	case "list":
+		docs := h.knowledge.List(inv.RoomID)
+		if len(docs) == 0 {
+			return inv.Text("kb.empty", nil), nil
+		}
+		lines := []string{inv.Text("kb.list", nil)}
+		for _, doc := range docs {
+			scope := inv.Text("kb.scope_room", nil)
+			if doc.RoomID == "" {
+				scope = inv.Text("kb.scope_all", nil)
+			}
+			lines = append(lines, inv.Text("kb.item", i18n.Vars{
+				"id":     doc.ID,
+				"name":   doc.Name,
+				"chunks": len(doc.Chunks),
+				"source": doc.Source,
+				"scope":  scope,
+			}))
+		}
+		return strings.Join(lines, "\n"), nil
+	case "remove", "delete":
+		if len(words) != 2 {
+			return inv.Text("command.usage", i18n.Vars{"usage": "kb remove <id>"}), nil
+		}
+		if !h.router.allowed(PermissionModerator, inv.RoomID, inv.SenderID) {
+			return inv.Text("kb.remove_denied", nil), nil
+		}
+		doc, ok := h.knowledge.Get(words[1])
+		if !ok || doc.RoomID != inv.RoomID {
+			return inv.Text("kb.not_found", i18n.Vars{"id": words[1]}), nil
+		}
+		if err := h.knowledge.Remove(doc.ID); err != nil {
+			return "", err
+		}
+		return inv.Text("kb.removed", i18n.Vars{"name": doc.Name}), nil
+	case "reindex":
+		if !h.router.allowed(PermissionModerator, inv.RoomID, inv.SenderID) {
+			return inv.Text("kb.reindex_denied", nil), nil
+		}
+		return h.reindex(ctx, inv)
+	default:
+		return inv.Text("command.usage", i18n.Vars{"usage": "kb [list], kb remove <id> | kb reindex"}), nil
+	}
+}
+
+// reindex re-reads the documentation directory and downloads the room's
+// uploaded documents again.
+func (h *MessageHandler) reindex(ctx context.Context, inv *Invocation) (string, error) {
+	if err := h.syncKnowledgeDir(); err != nil {
+		return "", err
+	}
+
+	count, failed := 0, 0
+	for _, doc := range h.knowledge.List(inv.RoomID) {
+		if doc.Source != knowledge.SourceUpload {
+			continue
+		}
+		text, err := h.downloadDocument(ctx, doc.URL)
+		if err == nil {
+			_, err = h.knowledge.Replace(doc.ID, text)
+		}
+		if err != nil {
+			log.Printf("Failed to re-index knowledge document %s: %v", doc.ID, err)
+			failed++
+			continue
+		}
+		count++
+	}
+
+	if failed > 0 {
+		return inv.Text("kb.reindexed_failed", i18n.Vars{"count": count, "failed": failed}), nil
+	}
+	return inv.Text("kb.reindexed", i18n.Vars{"count": count}), nil
+}
@@ -0,0 +1,81 @@
+package chat
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/huhndev/gohenry/i18n"
+)
+
+// supportedLocales are the languages Henry formats dates for. A region may
+// be added, as in en-US or de-AT.
+var supportedLocales = []string{"en", "de"}
+
+// germanWeekdays are the German short weekday names.
+var germanWeekdays = [...]string{"So.", "Mo.", "Di.", "Mi.", "Do.", "Fr.", "Sa."}
+
+// normalizeLocale checks a locale like "de", "de_AT" or "en-us" and
+// returns it as "de", "de-AT" or "en-US".
+func normalizeLocale(locale string) (string, error) {
+	parts := strings.Split(strings.ReplaceAll(locale, "_", "-"), "-")
+	language := strings.ToLower(parts[0])
+	if !containsFold(supportedLocales, language) || len(parts) > 2 {
+		return "", fmt.Errorf("unsupported locale %q", locale)
+	}
+	if len(parts) == 1 {
+		return language, nil
+	}
+	if len(parts[1]) != 2 {
+		return "", fmt.Errorf("unsupported locale %q", locale)
+	}
+	return language + "-" + strings.ToUpper(parts[1]), nil
+}
+
+// defaultLocale normalizes the configured locale, falling back to English.
+func defaultLocale(locale string) string {
+	normalized, err := normalizeLocale(locale)
+	if err != nil {
+		log.Printf("WARNING: Invalid HENRY_LOCALE: %v, using en", err)
+		return "en"
+	}
+	return normalized
+}
+
+// language picks the language of Henry's own messages: the room's
+// setting, then the user's locale, then HENRY_LOCALE.
+func (h *MessageHandler) language(roomID, userID string) string {
+	if lang := h.settings.Get(roomID).Language; lang != "" {
+		return lang
+	}
+	if lang := i18n.Language(h.users.OwnLocale(userID)); lang != "" {
+		return lang
+	}
+	if lang := i18n.Language(h.config.Locale); lang != "" {
+		return lang
+	}
+	return i18n.DefaultLanguage
+}
+
+// text renders a catalog message in the language of the room and user.
+func (h *MessageHandler) text(roomID, userID, key string, vars i18n.Vars) string {
+	return i18n.Text(h.language(roomID, userID), key, vars)
+}
+
+// localeLanguage returns the language part of a locale.
+func localeLanguage(locale string) string {
+	return strings.ToLower(strings.SplitN(locale, "-", 2)[0])
+}
+
+// formatTime formats a date and time the way the locale writes them.
+func formatTime(t time.Time, locale string) string {
+	switch {
+	case localeLanguage(locale) == "de":
+		return germanWeekdays[t.Weekday()] + " " + t.Format("02.01.2006 15:04 MST")
+	case locale == "en-US":
+		return t.Format("Mon 01/02/2006 3:04 PM MST")
+	default:
+		return t.Format("Mon 2006-01-02 15:04 MST")
+	}
+}
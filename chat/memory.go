@@ -0,0 +1,329 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/i18n"
+	"github.com/huhndev/gohenry/store"
+)
+
+const (
+	// maxMemoriesPerUser caps how much Henry remembers about one user.
+	maxMemoriesPerUser = 100
+	// maxInjectedMemories is how many memories go into one prompt.
+	maxInjectedMemories = 20
+	// maxMemoryLength is the longest single memory, in bytes.
+	maxMemoryLength = 500
+)
+
+// Memory is one fact Henry remembers about a user.
+type Memory struct {
+	ID        string    `json:"id"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// userMemories are one user's memories. IDs are numbered per user.
+type userMemories struct {
+	NextID   int      `json:"next_id"`
+	Memories []Memory `json:"memories"`
+}
+
+// MemoryStore keeps facts about users in memories.json, keyed by Matrix
+// user ID. Claude writes them through the remember tool; users manage
+// theirs with the memory command.
+type MemoryStore struct {
+	file *store.File
+
+	mu    sync.Mutex
+	users map[string]*userMemories
+}
+
+func NewMemoryStore(dataDir string) *MemoryStore {
+	s := &MemoryStore{
+		file:  store.NewFile(dataDir, "memories.json"),
+		users: make(map[string]*userMemories),
+	}
+	if err := s.file.Load(&s.users); err != nil {
+		log.Printf("WARNING: Failed to load memories: %v", err)
+	}
+	return s
+}
+
+// List returns the user's memories, oldest first.
+func (s *MemoryStore) List(userID string) []Memory {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.users[userID] == nil {
+		return nil
+	}
+	return append([]Memory(nil), s.users[userID].Memories...)
+}
+
+// Add stores a new memory. Repeating a known fact is not an error.
+func (s *MemoryStore) Add(userID, text string) (Memory, error) {
+	text, err := cleanMemory(text)
+	if err != nil {
+		return Memory{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user := s.users[userID]
+	if user == nil {
+		user = &userMemories{NextID: 1}
+		s.users[userID] = user
+	}
+	for _, m := range user.Memories {
+		if strings.EqualFold(m.Text, text) {
+			return m, nil
+		}
+	}
+	if len(user.Memories) >= maxMemoriesPerUser {
+		return Memory{}, i18n.NewError("memory.full", i18n.Vars{"max": maxMemoriesPerUser})
+	}
+
+	now := time.Now()
+	m := Memory{ID: strconv.Itoa(user.NextID), Text: text, CreatedAt: now, UpdatedAt: now}
+	user.NextID++
+	user.Memories = append(user.Memories, m)
+	return m, s.saveLocked()
+}
+
+// Edit replaces the text of a memory.
+func (s *MemoryStore) Edit(userID, id, text string) error {
+	text, err := cleanMemory(text)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.findLocked(userID, id)
+	if m == nil {
+		return i18n.NewError("memory.not_found", i18n.Vars{"id": id})
+	}
+	m.Text = text
+	m.UpdatedAt = time.Now()
+	return s.saveLocked()
+}
+
+// Delete removes a memory.
+func (s *MemoryStore) Delete(userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user := s.users[userID]
+	if user == nil {
+		return i18n.NewError("memory.not_found", i18n.Vars{"id": id})
+	}
+	for i, m := range user.Memories {
+		if m.ID == id {
+			user.Memories = append(user.Memories[:i], user.Memories[i+1:]...)
+			return s.saveLocked()
+		}
+	}
+	return i18n.NewError("memory.not_found", i18n.Vars{"id": id})
+}
+
+// Clear removes all of the user's memories.
+func (s *MemoryStore) Clear(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, userID)
+	return s.saveLocked()
+}
+
+// Forget deletes the user's memories and returns how many there were.
+func (s *MemoryStore) Forget(userID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user := s.users[userID]
+	if user == nil {
+		return 0, nil
+	}
+	delete(s.users, userID)
+	return len(user.Memories), s.saveLocked()
+}
+
+// promptMemories returns the sender's memories to answer text with. An
+// answer in a group room is read by everyone there, so the sender's
+// private memories only go into prompts for direct chats.
+func (h *MessageHandler) promptMemories(roomType domain.RoomType, senderID, text string) []string {
+	if roomType != domain.DirectRoom {
+		return nil
+	}
+	return h.memories.Relevant(senderID, text)
+}
+
+// Relevant returns up to maxInjectedMemories memories, preferring those
+// sharing words with text, then the most recently updated.
+func (s *MemoryStore) Relevant(userID, text string) []string {
+	memories := s.List(userID)
+	if len(memories) > maxInjectedMemories {
+		words := make(map[string]bool)
+		for _, w := range strings.Fields(strings.ToLower(text)) {
+			if len(w) > 3 {
+				words[strings.Trim(w, ".,!?;:\"'()")] = true
+			}
+		}
+		score := func(m Memory) int {
+			n := 0
+			for _, w := range strings.Fields(strings.ToLower(m.Text)) {
+				if words[strings.Trim(w, ".,!?;:\"'()")] {
+					n++
+				}
+			}
+			return n
+		}
+		sort.SliceStable(memories, func(i, j int) bool {
+			si, sj := score(memories[i]), score(memories[j])
+			if si != sj {
+				return si > sj
+			}
+			return memories[i].UpdatedAt.After(memories[j].UpdatedAt)
+		})
+		memories = memories[:maxInjectedMemories]
+	}
+
+	facts := make([]string, len(memories))
+	for i, m := range memories {
+		facts[i] = m.Text
+	}
+	return facts
+}
+
+func (s *MemoryStore) findLocked(userID, id string) *Memory {
+	user := s.users[userID]
+	if user == nil {
+		return nil
+	}
+	for i := range user.Memories {
+		if user.Memories[i].ID == id {
+			return &user.Memories[i]
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) saveLocked() error {
+	if err := s.file.Save(s.users); err != nil {
+		return fmt.Errorf("failed to save memories: %v", err)
+	}
+	return nil
+}
+
+func cleanMemory(text string) (string, error) {
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return "", i18n.NewError("memory.empty", nil)
+	}
+	if len(text) > maxMemoryLength {
+		return "", i18n.NewError("memory.too_long", i18n.Vars{"max": maxMemoryLength})
+	}
+	return text, nil
+}
+
+func (h *MessageHandler) cmdMemory(ctx context.Context, inv *Invocation) (string, error) {
+	words := strings.Fields(inv.String("request"))
+	if len(words) == 0 {
+		words = []string{"list"}
+	}
+
+	switch strings.ToLower(words[0]) {
+	case "list":
+		memories := h.memories.List(inv.SenderID)
+		if len(memories) == 0 {
+			return inv.Text("memory.none", nil), nil
+		}
+		lines := []string{inv.Text("memory.list", nil)}
+		for _, m := range memories {
+			lines = append(lines, fmt.Sprintf("- %s: %s", m.ID, m.Text))
+		}
+		if inv.RoomType == domain.DirectRoom {
+			return strings.Join(lines, "\n"), nil
+		}
+		// Memories are personal, so outside a DM they're sent privately.
+		dmRoom, err := h.matrixService.EnsureDirectRoom(inv.SenderID)
+		if err != nil {
+			return "", fmt.Errorf("failed to open direct chat for memories: %v", err)
+		}
+		if err := h.matrixService.SendMessage(dmRoom, strings.Join(lines, "\n")); err != nil {
+			return "", fmt.Errorf("failed to send memories: %v", err)
+		}
+		return inv.Text("memory.sent_dm", nil), nil
+	case "edit":
+		if len(words) < 3 {
+			return inv.Text("command.usage", i18n.Vars{"usage": "memory edit <id> <text>"}), nil
+		}
+		if err := h.memories.Edit(inv.SenderID, words[1], strings.Join(words[2:], " ")); err != nil {
+			return inv.Text("memory.edit_failed", i18n.Vars{"id": words[1], "error": i18n.ErrorText(inv.Lang, err)}), nil
+		}
+		return inv.Text("memory.edited", i18n.Vars{"id": words[1]}), nil
+	case "delete", "forget":
+		if len(words) != 2 {
+			return inv.Text("command.usage", i18n.Vars{"usage": "memory delete <id|all>"}), nil
+		}
+		if words[1] == "all" {
+			if err := h.memories.Clear(inv.SenderID); err != nil {
+				return "", err
+			}
+			return inv.Text("memory.cleared", nil), nil
+		}
+		if err := h.memories.Delete(inv.SenderID, words[1]); err != nil {
+			return inv.Text("memory.delete_failed", i18n.Vars{"id": words[1], "error": i18n.ErrorText(inv.Lang, err)}), nil
+		}
+		return inv.Text("memory.deleted", i18n.Vars{"id": words[1]}), nil
+	default:
+		return inv.Text("command.usage", i18n.Vars{
+			"usage": "memory [list], memory edit <id> <text> | memory delete <id|all>",
+		}), nil
+	}
+}
+
+// memoryTool lets Claude remember facts about the user it's talking to.
+func (h *MessageHandler) memoryTool(msg *domain.Message) domain.Tool {
+	return domain.Tool{
+		Name: "remember",
+		Description: "Save a lasting fact about the user who sent the last message, such as their name, " +
+			"preferences or circumstances, so you know it in future conversations. Use it when the user " +
+			"asks you to remember something or shares a preference that will matter later. Don't save " +
+			"passing details, secrets or facts about other people.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"fact": map[string]interface{}{
+					"type":        "string",
+					"description": "The fact, as a short third-person sentence, e.g. \"Prefers answers in German\"",
+				},
+			},
+			"required": []string{"fact"},
+		},
+		Run: func(ctx context.Context, input json.RawMessage) (string, error) {
+			var args struct {
+				Fact string `json:"fact"`
+			}
+			if err := json.Unmarshal(input, &args); err != nil {
+				return "", fmt.Errorf("invalid input: %v", err)
+			}
+			m, err := h.memories.Add(msg.SenderID, args.Fact)
+			if err != nil {
+				return "", err
+			}
+			log.Printf("Remembered memory %s for %s", m.ID, msg.SenderID)
+			return fmt.Sprintf(
+				"Saved as memory %s. The user can review their memories with %s memory.",
+				m.ID, h.config.CommandPrefix,
+			), nil
+		},
+	}
+}
@@ -0,0 +1,22 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/huhndev/gohenry/domain"
+)
+
+func TestMemoriesOnlyInDirectChats(t *testing.T) {
+	matrix := &fakeMatrix{accountData: make(map[string][]byte)}
+	h := newTestHandler(t, matrix)
+	if _, err := h.memories.Add(alice, "alice's salary is confidential"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := h.promptMemories(domain.GroupRoom, alice, "what's my salary?"); len(got) != 0 {
+		t.Errorf("memories injected in a group room: %v", got)
+	}
+	if got := h.promptMemories(domain.DirectRoom, alice, "what's my salary?"); len(got) != 1 {
+		t.Errorf("memories in a direct chat = %v, want alice's one memory", got)
+	}
+}
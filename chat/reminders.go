@@ -0,0 +1,247 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/i18n"
+	"github.com/huhndev/gohenry/scheduler"
+)
+
+// reminderJobKind is the scheduler job kind for reminders.
+const reminderJobKind = "reminder"
+
+// defaultSnooze is used when snooze is given no duration.
+const defaultSnooze = 10 * time.Minute
+
+// ReminderService lets users schedule reminders, by command or by asking
+// Claude in plain language. Due reminders mention the user in the room or
+// thread they were set in.
+type ReminderService struct {
+	handler   *MessageHandler
+	scheduler *scheduler.Scheduler
+}
+
+func NewReminderService(handler *MessageHandler, sched *scheduler.Scheduler) *ReminderService {
+	r := &ReminderService{handler: handler, scheduler: sched}
+
+	sched.Handle(reminderJobKind, r.fire)
+	handler.RegisterCommand(&Command{
+		Name:    "remind",
+		Aliases: []string{"reminder", "reminders"},
+		Args:    []Arg{{Name: "request", Type: ArgText, Optional: true}},
+		Handler: r.cmdRemind,
+	})
+	handler.RegisterTool(r.tool)
+	return r
+}
+
+func (r *ReminderService) cmdRemind(ctx context.Context, inv *Invocation) (string, error) {
+	request := inv.String("request")
+	words := strings.Fields(request)
+	if len(words) == 0 {
+		words = []string{"list"}
+	}
+
+	switch strings.ToLower(words[0]) {
+	case "list":
+		return r.list(inv), nil
+	case "cancel", "delete":
+		if len(words) != 2 {
+			return inv.Text("command.usage", i18n.Vars{"usage": "remind cancel <id>"}), nil
+		}
+		return r.cancel(inv, words[1]), nil
+	case "snooze":
+		if len(words) < 2 {
+			return inv.Text("command.usage", i18n.Vars{"usage": "remind snooze <id> [duration]"}), nil
+		}
+		return r.snooze(inv, words[1], strings.Join(words[2:], " ")), nil
+	}
+
+	text := strings.TrimSpace(request)
+	if strings.HasPrefix(strings.ToLower(text), "me ") {
+		text = strings.TrimSpace(text[3:])
+	}
+	idx := strings.Index(strings.ToLower(text), " to ")
+	if idx < 0 {
+		return inv.Text("remind.how", nil), nil
+	}
+
+	loc := r.handler.users.Location(inv.SenderID)
+	due, err := parseWhen(text[:idx], r.scheduler.Now().In(loc))
+	if err != nil {
+		return i18n.ErrorText(inv.Lang, err), nil
+	}
+
+	job, err := r.add(inv.RoomID, inv.ThreadID, inv.SenderID, strings.TrimSpace(text[idx+4:]), due, loc)
+	if err != nil {
+		return "", err
+	}
+	return inv.Text("remind.set", i18n.Vars{
+		"time": r.handler.users.Format(inv.SenderID, job.Due),
+		"id":   job.ID,
+	}), nil
+}
+
+func (r *ReminderService) add(
+	roomID, threadID, userID, text string,
+	due time.Time,
+	loc *time.Location,
+) (scheduler.Job, error) {
+	if text == "" {
+		return scheduler.Job{}, fmt.Errorf("reminder text is empty")
+	}
+	job, err := r.scheduler.Add(scheduler.Job{
+		Kind:     reminderJobKind,
+		Due:      due,
+		RoomID:   roomID,
+		ThreadID: threadID,
+		UserID:   userID,
+		Text:     text,
+		Timezone: loc.String(),
+	})
+	if err != nil {
+		return scheduler.Job{}, err
+	}
+	log.Printf("Reminder %s for %s set for %s", job.ID, userID, due)
+	return job, nil
+}
+
+func (r *ReminderService) list(inv *Invocation) string {
+	jobs := r.userReminders(inv.SenderID, true)
+	if len(jobs) == 0 {
+		return inv.Text("remind.none", nil)
+	}
+
+	lines := []string{inv.Text("remind.list", nil)}
+	for _, job := range jobs {
+		lines = append(lines, fmt.Sprintf("- %s: %s — %s", job.ID, r.handler.users.Format(inv.SenderID, job.Due), job.Text))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r *ReminderService) cancel(inv *Invocation, id string) string {
+	job, ok := r.userReminder(inv.SenderID, id)
+	if !ok {
+		return inv.Text("remind.not_found", i18n.Vars{"id": id})
+	}
+	if err := r.scheduler.Cancel(job.ID); err != nil {
+		log.Printf("Failed to cancel reminder %s: %v", job.ID, err)
+		return inv.Text("remind.cancel_failed", nil)
+	}
+	return inv.Text("remind.cancelled", i18n.Vars{"id": job.ID, "text": job.Text})
+}
+
+// snooze moves a reminder, pending or recently fired, to later.
+func (r *ReminderService) snooze(inv *Invocation, id, duration string) string {
+	userID := inv.SenderID
+	job, ok := r.userReminder(userID, id)
+	if !ok {
+		return inv.Text("remind.not_found", i18n.Vars{"id": id})
+	}
+
+	d := defaultSnooze
+	if duration != "" {
+		var err error
+		if d, err = parseDuration(strings.TrimPrefix(duration, "for ")); err != nil || d <= 0 {
+			return inv.Text("remind.invalid_duration", i18n.Vars{"duration": duration})
+		}
+	}
+
+	from := r.scheduler.Now()
+	if job.Pending() && job.Due.After(from) {
+		from = job.Due
+	}
+	job, err := r.scheduler.Reschedule(job.ID, from.Add(d))
+	if err != nil {
+		log.Printf("Failed to snooze reminder %s: %v", id, err)
+		return inv.Text("remind.snooze_failed", nil)
+	}
+	return inv.Text("remind.snoozed", i18n.Vars{"id": job.ID, "time": r.handler.users.Format(userID, job.Due)})
+}
+
+// fire sends a due reminder, mentioning the user where it was set.
+func (r *ReminderService) fire(ctx context.Context, job scheduler.Job) error {
+	message := r.handler.text(job.RoomID, job.UserID, "remind.fire", i18n.Vars{
+		"user":    job.UserID,
+		"text":    job.Text,
+		"command": r.handler.config.CommandPrefix + " remind snooze " + job.ID + " 10m",
+	})
+	return queuedAsSent(r.handler.matrixService.SendThreadMessage(job.RoomID, job.ThreadID, message))
+}
+
+// tool lets Claude set reminders for the user it's talking to. Claude
+// resolves the natural-language time itself, given the user's time zone.
+func (r *ReminderService) tool(msg *domain.Message) domain.Tool {
+	loc := r.handler.users.Location(msg.SenderID)
+	now := r.scheduler.Now().In(loc)
+
+	return domain.Tool{
+		Name: "create_reminder",
+		Description: fmt.Sprintf(
+			"Schedule a reminder for the user who sent the last message. It is posted in this "+
+				"conversation at the given time and mentions them. The user's time zone is %s, where "+
+				"it is now %s. Resolve relative times like \"tomorrow at 10\" in that time zone.",
+			loc, now.Format("Monday 2006-01-02 15:04 MST"),
+		),
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"time": map[string]interface{}{
+					"type":        "string",
+					"description": "When to remind, as an RFC 3339 timestamp with UTC offset, e.g. 2025-03-21T10:00:00+01:00",
+				},
+				"text": map[string]interface{}{
+					"type":        "string",
+					"description": "What to remind the user of, phrased as the reminder itself",
+				},
+			},
+			"required": []string{"time", "text"},
+		},
+		Run: func(ctx context.Context, input json.RawMessage) (string, error) {
+			var args struct {
+				Time string `json:"time"`
+				Text string `json:"text"`
+			}
+			if err := json.Unmarshal(input, &args); err != nil {
+				return "", fmt.Errorf("invalid input: %v", err)
+			}
+			due, err := time.Parse(time.RFC3339, args.Time)
+			if err != nil {
+				return "", fmt.Errorf("time must be RFC 3339: %v", err)
+			}
+			if !due.After(r.scheduler.Now()) {
+				return "", fmt.Errorf("%s is in the past", args.Time)
+			}
+
+			job, err := r.add(msg.RoomID, msg.ThreadID, msg.SenderID, args.Text, due, loc)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf(
+				"Reminder %s set for %s. The user can cancel it with %s remind cancel %s.",
+				job.ID, r.handler.users.Format(msg.SenderID, job.Due), r.handler.config.CommandPrefix, job.ID,
+			), nil
+		},
+	}
+}
+
+// userReminders returns the user's reminders, soonest first.
+func (r *ReminderService) userReminders(userID string, pendingOnly bool) []scheduler.Job {
+	return r.scheduler.List(func(job scheduler.Job) bool {
+		return job.Kind == reminderJobKind && job.UserID == userID && (!pendingOnly || job.Pending())
+	})
+}
+
+func (r *ReminderService) userReminder(userID, id string) (scheduler.Job, bool) {
+	job, ok := r.scheduler.Get(id)
+	if !ok || job.Kind != reminderJobKind || job.UserID != userID {
+		return scheduler.Job{}, false
+	}
+	return job, true
+}
@@ -0,0 +1,88 @@
+package chat
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/huhndev/gohenry/store"
+)
+
+// ResetMarker records where a user last reset the conversation. Context
+// assembly stops at the marker, so Claude never sees what came before it.
+type ResetMarker struct {
+	EventID   string `json:"event_id"`
+	Timestamp int64  `json:"timestamp"`
+	SenderID  string `json:"sender_id"`
+}
+
+// ResetStore keeps the latest marker per room and thread in resets.json.
+type ResetStore struct {
+	file *store.File
+
+	mu      sync.Mutex
+	markers map[string]ResetMarker
+}
+
+func NewResetStore(dataDir string) *ResetStore {
+	s := &ResetStore{
+		file:    store.NewFile(dataDir, "resets.json"),
+		markers: make(map[string]ResetMarker),
+	}
+	if err := s.file.Load(&s.markers); err != nil {
+		log.Printf("WARNING: Failed to load reset markers: %v", err)
+	}
+	return s
+}
+
+// resetKey scopes a marker to a thread, or to the room's main timeline.
+func resetKey(roomID, threadID string) string {
+	if threadID == "" {
+		return roomID
+	}
+	return roomID + "|" + threadID
+}
+
+// Get returns the latest marker for the room or thread.
+func (s *ResetStore) Get(roomID, threadID string) (ResetMarker, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	marker, ok := s.markers[resetKey(roomID, threadID)]
+	return marker, ok
+}
+
+// Set replaces the marker for the room or thread and saves it.
+func (s *ResetStore) Set(roomID, threadID string, marker ResetMarker) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.markers[resetKey(roomID, threadID)] = marker
+	return s.file.Save(s.markers)
+}
+
+// Forget removes the user's name from the markers they set. The markers
+// stay, since they also keep earlier messages out of everyone else's
+// context.
+func (s *ResetStore) Forget(userID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for key, marker := range s.markers {
+		if marker.SenderID == userID {
+			marker.SenderID = ""
+			s.markers[key] = marker
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	if err := s.file.Save(s.markers); err != nil {
+		return 0, fmt.Errorf("failed to save reset markers: %v", err)
+	}
+	return removed, nil
+}
+
+// covers reports whether a message was sent at or before the marker.
+func (m ResetMarker) covers(msgID string, timestamp int64) bool {
+	return msgID == m.EventID || timestamp <= m.Timestamp
+}
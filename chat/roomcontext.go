@@ -0,0 +1,57 @@
+package chat
+
+import (
+	"unicode/utf8"
+
+	"github.com/huhndev/gohenry/domain"
+)
+
+const (
+	// maxTopicLength and maxPinnedLength cap the topic and each pinned
+	// message in the prompt.
+	maxTopicLength  = 500
+	maxPinnedLength = 300
+	// maxRoomContextLength caps topic and pinned messages together.
+	maxRoomContextLength = 2000
+)
+
+// roomContext returns what Claude is told about the room: always its
+// name, and its topic and pinned messages unless the room turned that off.
+// Like history, the topic and pinned messages of users who haven't
+// consented are left out.
+func (h *MessageHandler) roomContext(roomID string) *domain.RoomInfo {
+	info := h.matrixService.GetRoomInfo(roomID)
+	room := &domain.RoomInfo{Name: info.Name}
+	if h.settings.Get(roomID).RoomContextOff {
+		return room
+	}
+
+	if h.mayShare(&domain.Message{SenderID: info.TopicSenderID}) {
+		room.Topic = truncate(info.Topic, maxTopicLength)
+	}
+	length := len(room.Topic)
+	for _, pinned := range info.Pinned {
+		if !h.mayShare(&domain.Message{SenderID: pinned.SenderID}) {
+			continue
+		}
+		pinned.Text = truncate(pinned.Text, maxPinnedLength)
+		if length+len(pinned.Text) > maxRoomContextLength {
+			break
+		}
+		length += len(pinned.Text)
+		room.Pinned = append(room.Pinned, pinned)
+	}
+	return room
+}
+
+// truncate shortens text to at most max bytes, marking the cut.
+func truncate(text string, max int) string {
+	if len(text) <= max {
+		return text
+	}
+	cut := max - len("…")
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + "…"
+}
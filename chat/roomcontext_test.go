@@ -0,0 +1,53 @@
+package chat
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/huhndev/gohenry/domain"
+)
+
+// roomInfoMatrix serves a fixed room info.
+type roomInfoMatrix struct {
+	*fakeMatrix
+	info domain.RoomInfo
+}
+
+func (m *roomInfoMatrix) GetRoomInfo(roomID string) *domain.RoomInfo {
+	info := m.info
+	return &info
+}
+
+func TestRoomContextLeavesOutDeclinedSenders(t *testing.T) {
+	matrix := &roomInfoMatrix{
+		fakeMatrix: &fakeMatrix{accountData: make(map[string][]byte)},
+		info: domain.RoomInfo{
+			Name:          "Team",
+			Topic:         "alice's topic",
+			TopicSenderID: alice,
+			Pinned: []domain.PinnedMessage{
+				{SenderID: alice, Text: "alice's rules"},
+				{SenderID: bob, Text: "bob's rules"},
+				{SenderID: "@henry:example.org", Text: "Henry's note"},
+			},
+		},
+	}
+	h := newTestHandler(t, matrix)
+
+	room := h.roomContext(testRoom)
+	if room.Topic != "alice's topic" || len(room.Pinned) != 3 {
+		t.Fatalf("room context without declines = %+v", room)
+	}
+
+	if err := h.consent.Decide(alice, consentDeclined, alice); err != nil {
+		t.Fatal(err)
+	}
+	room = h.roomContext(testRoom)
+	if room.Topic != "" {
+		t.Errorf("topic of a declined user was kept: %q", room.Topic)
+	}
+	want := []domain.PinnedMessage{matrix.info.Pinned[1], matrix.info.Pinned[2]}
+	if !reflect.DeepEqual(room.Pinned, want) {
+		t.Errorf("pinned = %+v, want %+v", room.Pinned, want)
+	}
+}
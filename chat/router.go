@@ -0,0 +1,158 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/huhndev/gohenry/config"
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/i18n"
+)
+
+// CommandRouter dispatches "!henry <command>" messages to their handlers
+// before anything is sent to Claude.
+type CommandRouter struct {
+	config        *config.Config
+	matrixService domain.MatrixService
+	commands      map[string]*Command
+	names         []string
+}
+
+func NewCommandRouter(cfg *config.Config, matrixService domain.MatrixService) *CommandRouter {
+	return &CommandRouter{
+		config:        cfg,
+		matrixService: matrixService,
+		commands:      make(map[string]*Command),
+	}
+}
+
+// Register adds a command. Registering a name twice replaces the earlier
+// command.
+func (r *CommandRouter) Register(cmd *Command) {
+	if _, exists := r.commands[cmd.Name]; !exists {
+		r.names = append(r.names, cmd.Name)
+		sort.Strings(r.names)
+	}
+	r.commands[cmd.Name] = cmd
+	for _, alias := range cmd.Aliases {
+		r.commands[alias] = cmd
+	}
+}
+
+// HasPrefix reports whether text starts with the command prefix.
+func (r *CommandRouter) HasPrefix(text string) bool {
+	prefix := r.config.CommandPrefix
+	if !strings.HasPrefix(strings.ToLower(text), strings.ToLower(prefix)) {
+		return false
+	}
+	rest := text[len(prefix):]
+	return rest == "" || strings.IndexAny(rest[:1], " \t\n") == 0
+}
+
+// Dispatch runs the command in text, which must start with the prefix.
+// handled is false for unknown commands; remainder is then the text after
+// the prefix, to be passed on to Claude.
+func (r *CommandRouter) Dispatch(
+	ctx context.Context,
+	inv *Invocation,
+	text string,
+) (reply string, remainder string, handled bool) {
+	body := strings.TrimSpace(text[len(r.config.CommandPrefix):])
+	if body == "" {
+		return r.help(inv), "", true
+	}
+
+	cmd, args, ok := r.lookup(text)
+	if !ok {
+		return "", body, false
+	}
+
+	if !r.allowed(cmd.Permission, inv.RoomID, inv.SenderID) {
+		log.Printf("Denied command %s for %s in room %s", cmd.Name, inv.SenderID, inv.RoomID)
+		return inv.Text("command.denied", i18n.Vars{
+			"who":     inv.Text(cmd.Permission.key(), nil),
+			"command": cmd.Name,
+		}), "", true
+	}
+
+	inv.Command = cmd
+	reply, err := r.run(ctx, inv, args)
+	if err != nil {
+		log.Printf("Command %s failed: %v", cmd.Name, err)
+		return inv.Text("command.failed", i18n.Vars{
+			"command": cmd.Name,
+			"error":   i18n.ErrorText(inv.Lang, err),
+		}), "", true
+	}
+	return reply, "", true
+}
+
+// run parses the arguments and calls the handler of inv.Command.
+// Argument errors are returned as a usage reply, not as an error.
+func (r *CommandRouter) run(ctx context.Context, inv *Invocation, args string) (string, error) {
+	values, err := inv.Command.parseArgs(args)
+	if err != nil {
+		return inv.Text("command.bad_args", i18n.Vars{
+			"error": i18n.ErrorText(inv.Lang, err),
+			"usage": inv.Command.Usage(r.config.CommandPrefix),
+		}), nil
+	}
+	inv.values = values
+
+	log.Printf("Running command %s for %s in room %s", inv.Command.Name, inv.SenderID, inv.RoomID)
+	return inv.Command.Handler(ctx, inv)
+}
+
+// lookup splits prefixed text into a known command and its arguments.
+func (r *CommandRouter) lookup(text string) (*Command, string, bool) {
+	body := strings.TrimSpace(text[len(r.config.CommandPrefix):])
+	name, args := body, ""
+	if idx := strings.IndexAny(body, " \t\n"); idx >= 0 {
+		name, args = body[:idx], body[idx+1:]
+	}
+	cmd, ok := r.commands[strings.ToLower(name)]
+	return cmd, args, ok
+}
+
+// allowed checks the sender's role against a command's permission level.
+func (r *CommandRouter) allowed(permission Permission, roomID, senderID string) bool {
+	if r.config.OwnerID != "" && senderID == r.config.OwnerID {
+		return true
+	}
+
+	switch permission {
+	case PermissionUser:
+		return true
+	case PermissionModerator, PermissionAdmin:
+		level, err := r.matrixService.GetUserPowerLevel(roomID, senderID)
+		if err != nil {
+			log.Printf("Failed to read power level of %s in %s: %v", senderID, roomID, err)
+			return false
+		}
+		if permission == PermissionAdmin {
+			return level >= adminPowerLevel
+		}
+		return level >= moderatorPowerLevel
+	default:
+		return false
+	}
+}
+
+// help lists the commands the sender is allowed to run, each described by
+// the catalog's "help.<command>" message.
+func (r *CommandRouter) help(inv *Invocation) string {
+	var b strings.Builder
+	b.WriteString(inv.Text("help.header", nil) + "\n")
+	for _, name := range r.names {
+		cmd := r.commands[name]
+		if cmd.Permission != PermissionUser && !r.allowed(cmd.Permission, inv.RoomID, inv.SenderID) {
+			continue
+		}
+		fmt.Fprintf(&b, "- %s: %s\n", cmd.Usage(r.config.CommandPrefix), inv.Text("help."+cmd.Name, nil))
+	}
+	b.WriteString(inv.Text("help.footer", nil))
+	return b.String()
+}
@@ -0,0 +1,18 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/huhndev/gohenry/i18n"
+)
+
+func TestEveryCommandHasHelp(t *testing.T) {
+	h, _, _ := newEraseTestHandler(t)
+	for _, name := range h.router.names {
+		for _, lang := range i18n.Languages() {
+			if !i18n.Has(lang, "help."+name) {
+				t.Errorf("command %s has no help.%s in %s", name, name, lang)
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package chat
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/huhndev/gohenry/i18n"
+	"github.com/huhndev/gohenry/scheduler"
+)
+
+// promptJobKind is the scheduler job kind for recurring room prompts.
+const promptJobKind = "prompt"
+
+// ScheduleService lets room admins schedule recurring Henry posts with a
+// cron-style schedule. At each run the prompt is answered by Claude, or
+// run as a command if it starts with the prefix, and posted to the room.
+type ScheduleService struct {
+	handler   *MessageHandler
+	scheduler *scheduler.Scheduler
+}
+
+func NewScheduleService(handler *MessageHandler, sched *scheduler.Scheduler) *ScheduleService {
+	s := &ScheduleService{handler: handler, scheduler: sched}
+
+	sched.Handle(promptJobKind, s.run)
+	sched.OnFailure(promptJobKind, s.reportFailure)
+	handler.RegisterCommand(&Command{
+		Name:       "schedule",
+		Aliases:    []string{"schedules", "cron"},
+		Args:       []Arg{{Name: "request", Type: ArgText, Optional: true}},
+		Permission: PermissionAdmin,
+		Handler:    s.cmdSchedule,
+	})
+	return s
+}
+
+func (s *ScheduleService) cmdSchedule(ctx context.Context, inv *Invocation) (string, error) {
+	request := strings.TrimSpace(inv.String("request"))
+	words := strings.Fields(request)
+	if len(words) == 0 {
+		words = []string{"list"}
+	}
+
+	switch strings.ToLower(words[0]) {
+	case "list":
+		return s.list(inv), nil
+	case "delete", "remove", "cancel":
+		if len(words) != 2 {
+			return inv.Text("command.usage", i18n.Vars{"usage": "schedule delete <id>"}), nil
+		}
+		return s.delete(inv, words[1]), nil
+	}
+
+	cron, prompt := splitCron(request)
+	if prompt == "" {
+		return inv.Text("schedule.how", i18n.Vars{"prefix": s.handler.config.CommandPrefix}), nil
+	}
+	if _, err := scheduler.ParseCron(cron); err != nil {
+		return inv.Text("schedule.invalid_cron", i18n.Vars{"error": i18n.ErrorText(inv.Lang, err)}), nil
+	}
+
+	loc := s.handler.users.Location(inv.SenderID)
+	job, err := s.scheduler.Add(scheduler.Job{
+		Kind:     promptJobKind,
+		RoomID:   inv.RoomID,
+		UserID:   inv.SenderID,
+		Text:     prompt,
+		Timezone: loc.String(),
+		Cron:     cron,
+	})
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Scheduled prompt %s in %s (%s)", job.ID, inv.RoomID, cron)
+	return inv.Text("schedule.added", i18n.Vars{
+		"id":    job.ID,
+		"cron":  cron,
+		"first": s.handler.users.Format(inv.SenderID, job.Due),
+	}), nil
+}
+
+func (s *ScheduleService) list(inv *Invocation) string {
+	jobs := s.scheduler.List(func(job scheduler.Job) bool {
+		return job.Kind == promptJobKind && job.RoomID == inv.RoomID
+	})
+	if len(jobs) == 0 {
+		return inv.Text("schedule.none", nil)
+	}
+
+	lines := []string{inv.Text("schedule.list", nil)}
+	for _, job := range jobs {
+		lines = append(lines, inv.Text("schedule.item", i18n.Vars{
+			"id":   job.ID,
+			"cron": job.Cron,
+			"next": s.handler.users.Format(inv.SenderID, job.Due),
+			"user": job.UserID,
+			"text": job.Text,
+		}))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (s *ScheduleService) delete(inv *Invocation, id string) string {
+	job, ok := s.scheduler.Get(id)
+	if !ok || job.Kind != promptJobKind || job.RoomID != inv.RoomID {
+		return inv.Text("schedule.not_found", i18n.Vars{"id": id})
+	}
+	if err := s.scheduler.Cancel(id); err != nil {
+		log.Printf("Failed to delete scheduled post %s: %v", id, err)
+		return inv.Text("schedule.delete_failed", nil)
+	}
+	return inv.Text("schedule.deleted", i18n.Vars{"id": id})
+}
+
+func (s *ScheduleService) run(ctx context.Context, job scheduler.Job) error {
+	reply, err := s.handler.RunPrompt(ctx, job.RoomID, job.UserID, job.Text)
+	if err != nil {
+		return err
+	}
+	if reply == "" {
+		return nil
+	}
+	return queuedAsSent(s.handler.matrixService.SendMessage(job.RoomID, reply))
+}
+
+// reportFailure tells the owner about any scheduled job that failed on
+// every attempt.
+func (s *ScheduleService) reportFailure(ctx context.Context, job scheduler.Job, err error) {
+	owner := s.handler.config.OwnerID
+	if owner == "" {
+		return
+	}
+	dmRoom, dmErr := s.handler.matrixService.EnsureDirectRoom(owner)
+	if dmErr != nil {
+		log.Printf("Cannot report failed %s job %s to owner: %v", job.Kind, job.ID, dmErr)
+		return
+	}
+	message := s.handler.text("", owner, "schedule.failed", i18n.Vars{
+		"kind":  job.Kind,
+		"id":    job.ID,
+		"room":  job.RoomID,
+		"user":  job.UserID,
+		"error": i18n.ErrorText(s.handler.language("", owner), err),
+		"text":  job.Text,
+	})
+	if err := s.handler.matrixService.SendMessage(dmRoom, message); err != nil {
+		log.Printf("Failed to report job failure to owner: %v", err)
+	}
+}
+
+// splitCron separates a leading cron expression, either an @alias or five
+// fields, from the prompt after it.
+func splitCron(text string) (cron, prompt string) {
+	words := strings.Fields(text)
+	n := 5
+	if len(words) > 0 && strings.HasPrefix(words[0], "@") {
+		n = 1
+	}
+	if len(words) <= n {
+		return text, ""
+	}
+	return strings.Join(words[:n], " "), strings.Join(words[n:], " ")
+}
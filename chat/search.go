@@ -0,0 +1,322 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/i18n"
+	"github.com/huhndev/gohenry/search"
+)
+
+const (
+	// searchResultLimit is how many messages a search returns.
+	searchResultLimit = 8
+	// searchSnippetLength caps each result's quoted text.
+	searchSnippetLength = 200
+)
+
+// searchQuery is a parsed search request.
+type searchQuery struct {
+	Text     string
+	From     string
+	AllRooms bool
+}
+
+// parseSearchQuery reads "[all] [from:<name>] <words>".
+func parseSearchQuery(request string) searchQuery {
+	var q searchQuery
+	var words []string
+	for i, word := range strings.Fields(request) {
+		switch {
+		case i == 0 && strings.EqualFold(word, "all"):
+			q.AllRooms = true
+		case strings.HasPrefix(strings.ToLower(word), "from:"):
+			q.From = strings.TrimPrefix(word[len("from:"):], "@")
+		default:
+			words = append(words, word)
+		}
+	}
+	q.Text = strings.Join(words, " ")
+	return q
+}
+
+func (h *MessageHandler) cmdSearch(ctx context.Context, inv *Invocation) (string, error) {
+	q := parseSearchQuery(inv.String("query"))
+	if q.Text == "" {
+		return inv.Text("command.usage", i18n.Vars{"usage": inv.Command.Usage(h.config.CommandPrefix)}), nil
+	}
+
+	hits, err := h.searchMessages(ctx, inv.RoomID, inv.SenderID, q)
+	if err != nil {
+		return "", err
+	}
+	if len(hits) == 0 {
+		return inv.Text("search.none", i18n.Vars{"query": q.Text}), nil
+	}
+	return inv.Text("search.header", nil) + "\n" + h.formatHits(hits, inv.SenderID, q.AllRooms), nil
+}
+
+// searchMessages searches the current room or, with AllRooms, the rooms
+// everyone who sees the answer is in, see searchScope. The local index is
+// tried first, then the homeserver's /search.
+func (h *MessageHandler) searchMessages(
+	ctx context.Context,
+	roomID, senderID string,
+	q searchQuery,
+) ([]search.Hit, error) {
+	rooms := map[string]int64{roomID: 0}
+	if q.AllRooms {
+		var err error
+		if rooms, err = h.searchScope(ctx, roomID, senderID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Rooms not backfilled yet are indexed in the background, so a first
+	// search doesn't wait for their history; the homeserver's /search
+	// fills in until then.
+	roomIDs := make([]string, 0, len(rooms))
+	for r := range rooms {
+		roomIDs = append(roomIDs, r)
+		if !h.messages.Backfilled(r) {
+			go h.backfill(context.Background(), r)
+		}
+	}
+
+	var hits []search.Hit
+	for _, hit := range h.messages.Search(rooms, q.Text, q.From, searchResultLimit) {
+		if h.consent.Allowed(hit.SenderID) || hit.SenderID == h.matrixService.GetBotUserID() {
+			hits = append(hits, hit)
+		}
+	}
+	if len(hits) >= searchResultLimit {
+		return hits, nil
+	}
+
+	remote, err := h.matrixService.SearchMessages(ctx, q.Text, roomIDs, searchResultLimit)
+	if err != nil {
+		log.Printf("Homeserver search unavailable: %v", err)
+		return hits, nil
+	}
+	seen := make(map[string]bool)
+	for _, hit := range hits {
+		seen[hit.ID] = true
+	}
+	for _, msg := range remote {
+		if seen[msg.ID] || len(hits) >= searchResultLimit {
+			continue
+		}
+		if since, ok := rooms[msg.RoomID]; !ok || msg.Timestamp < since {
+			continue
+		}
+		if !h.mayShare(msg) || h.erased(msg) {
+			continue
+		}
+		if q.From != "" && !strings.Contains(strings.ToLower(msg.SenderID), strings.ToLower(q.From)) {
+			continue
+		}
+		hits = append(hits, search.Hit{
+			Entry: search.Entry{
+				ID:        msg.ID,
+				SenderID:  msg.SenderID,
+				Body:      msg.Content,
+				Timestamp: msg.Timestamp,
+			},
+			RoomID: msg.RoomID,
+		})
+	}
+	return hits, nil
+}
+
+// searchScope returns the rooms an all-rooms search may show, each with
+// the oldest timestamp it may show from there. Hits are posted where the
+// search was asked, so every member of that room must be in a room for it
+// to be searched, and only messages from after the last of them joined
+// are shown. In a DM that's just the asker.
+func (h *MessageHandler) searchScope(ctx context.Context, roomID, senderID string) (map[string]int64, error) {
+	botID := h.matrixService.GetBotUserID()
+	audience := []string{senderID}
+	roomType, err := h.matrixService.GetRoomType(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if roomType != domain.DirectRoom {
+		members, err := h.matrixService.JoinedMembers(roomID)
+		if err != nil {
+			return nil, err
+		}
+		audience = audience[:0]
+		for member := range members {
+			if member != botID {
+				audience = append(audience, member)
+			}
+		}
+	}
+
+	joined, err := h.matrixService.JoinedRooms()
+	if err != nil {
+		return nil, err
+	}
+	rooms := map[string]int64{roomID: 0}
+	for _, r := range joined {
+		if r == roomID {
+			continue
+		}
+		members, err := h.matrixService.JoinedMembers(r)
+		if err != nil {
+			log.Printf("Skipping room %s in search: %v", r, err)
+			continue
+		}
+		since, shared := int64(0), true
+		for _, member := range audience {
+			joinedAt, ok := members[member]
+			if !ok {
+				shared = false
+				break
+			}
+			if joinedAt > since {
+				since = joinedAt
+			}
+		}
+		if shared {
+			rooms[r] = since
+		}
+	}
+	return rooms, nil
+}
+
+// HandleRedaction takes a redacted message out of the search index.
+func (h *MessageHandler) HandleRedaction(ctx context.Context, roomID, eventID string) {
+	if h.messages.Remove(roomID, eventID) {
+		log.Printf("Removed redacted message %s in %s from the search index", eventID, roomID)
+	}
+}
+
+// backfill indexes the room's history from /messages back to when Henry
+// joined, once. Only one backfill runs per room at a time.
+func (h *MessageHandler) backfill(ctx context.Context, roomID string) {
+	h.backfillMu.Lock()
+	if h.backfilling[roomID] {
+		h.backfillMu.Unlock()
+		return
+	}
+	h.backfilling[roomID] = true
+	h.backfillMu.Unlock()
+	defer func() {
+		h.backfillMu.Lock()
+		delete(h.backfilling, roomID)
+		h.backfillMu.Unlock()
+	}()
+
+	members, err := h.matrixService.JoinedMembers(roomID)
+	if err != nil {
+		log.Printf("Failed to backfill search index for %s: %v", roomID, err)
+		return
+	}
+	joinedAt, ok := members[h.matrixService.GetBotUserID()]
+	if !ok {
+		log.Printf("Not backfilling search index for %s: Henry isn't joined", roomID)
+		return
+	}
+
+	messages, err := h.matrixService.GetRoomHistory(ctx, roomID, "", joinedAt, h.config.SearchBackfill)
+	if err != nil {
+		log.Printf("Failed to backfill search index for %s: %v", roomID, err)
+		return
+	}
+	shared := messages[:0]
+	for _, msg := range messages {
+		if h.mayShare(msg) && !h.erased(msg) {
+			shared = append(shared, msg)
+		}
+	}
+	h.messages.Backfill(roomID, shared)
+	log.Printf("Backfilled search index for %s with %d messages", roomID, len(shared))
+}
+
+func (h *MessageHandler) formatHits(hits []search.Hit, userID string, showRoom bool) string {
+	var lines []string
+	for _, hit := range hits {
+		name := hit.SenderName
+		if name == "" {
+			name = hit.SenderID
+		}
+		where := ""
+		if showRoom {
+			where = " in " + h.matrixService.GetRoomName(hit.RoomID)
+		}
+		lines = append(lines, fmt.Sprintf("- %s, %s%s: %s\n  %s",
+			h.users.Format(userID, time.Unix(0, hit.Timestamp*int64(time.Millisecond))),
+			name, where, snippet(hit.Body), h.matrixService.Permalink(hit.RoomID, hit.ID),
+		))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// snippet shortens a message to one line of searchSnippetLength bytes.
+func snippet(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= searchSnippetLength {
+		return text
+	}
+	cut := searchSnippetLength
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + "…"
+}
+
+// searchTool lets Claude look up older messages for the user it's
+// talking to, limited to rooms that user is in.
+func (h *MessageHandler) searchTool(msg *domain.Message) domain.Tool {
+	return domain.Tool{
+		Name: "search_messages",
+		Description: "Full-text search of older chat messages, beyond the conversation you can see. " +
+			"Use it for questions like \"what did Mark say about the migration last month?\". " +
+			"Searches this room, or with all_rooms the rooms everyone here is in. Results have dates, senders and links; " +
+			"include the links when you quote results.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query": map[string]interface{}{
+					"type":        "string",
+					"description": "Keywords to search for",
+				},
+				"from": map[string]interface{}{
+					"type":        "string",
+					"description": "Only messages from senders whose name or user ID contains this",
+				},
+				"all_rooms": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Also search other rooms; in a group room only those every member here is in",
+				},
+			},
+			"required": []string{"query"},
+		},
+		Run: func(ctx context.Context, input json.RawMessage) (string, error) {
+			var args struct {
+				Query    string `json:"query"`
+				From     string `json:"from"`
+				AllRooms bool   `json:"all_rooms"`
+			}
+			if err := json.Unmarshal(input, &args); err != nil {
+				return "", fmt.Errorf("invalid input: %v", err)
+			}
+			q := searchQuery{Text: args.Query, From: strings.TrimPrefix(args.From, "@"), AllRooms: args.AllRooms}
+			hits, err := h.searchMessages(ctx, msg.RoomID, msg.SenderID, q)
+			if err != nil {
+				return "", err
+			}
+			if len(hits) == 0 {
+				return "No matching messages.", nil
+			}
+			return "Found:\n" + h.formatHits(hits, msg.SenderID, true), nil
+		},
+	}
+}
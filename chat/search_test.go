@@ -0,0 +1,86 @@
+package chat
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/huhndev/gohenry/domain"
+)
+
+// searchMatrix adds room membership to fakeMatrix. members maps room ID
+// to member and join time.
+type searchMatrix struct {
+	*fakeMatrix
+	direct  map[string]bool
+	members map[string]map[string]int64
+}
+
+func (m *searchMatrix) GetRoomType(ctx context.Context, roomID string) (domain.RoomType, error) {
+	if m.direct[roomID] {
+		return domain.DirectRoom, nil
+	}
+	return domain.GroupRoom, nil
+}
+
+func (m *searchMatrix) JoinedRooms() ([]string, error) {
+	var rooms []string
+	for roomID := range m.members {
+		rooms = append(rooms, roomID)
+	}
+	return rooms, nil
+}
+
+func (m *searchMatrix) JoinedMembers(roomID string) (map[string]int64, error) {
+	return m.members[roomID], nil
+}
+
+func TestSearchScope(t *testing.T) {
+	const carol = "@carol:example.org"
+	henry := (&fakeMatrix{}).GetBotUserID()
+	matrix := &searchMatrix{
+		fakeMatrix: &fakeMatrix{accountData: make(map[string][]byte)},
+		direct:     map[string]bool{"!dm:example.org": true},
+		members: map[string]map[string]int64{
+			"!dm:example.org":    {henry: 1, alice: 1},
+			"!team:example.org":  {henry: 1, alice: 10, bob: 20},
+			"!carol:example.org": {henry: 1, alice: 100, carol: 200},
+			"!old:example.org":   {henry: 1, alice: 50, bob: 300},
+		},
+	}
+	h := newTestHandler(t, matrix)
+
+	tests := []struct {
+		roomID string
+		want   map[string]int64
+	}{
+		{
+			// In a DM, every room of the asker, from when they joined.
+			"!dm:example.org",
+			map[string]int64{
+				"!dm:example.org":    0,
+				"!team:example.org":  10,
+				"!carol:example.org": 100,
+				"!old:example.org":   50,
+			},
+		},
+		{
+			// In a group room, only rooms bob is in too, from when the
+			// later of them joined. carol's room is left out.
+			"!team:example.org",
+			map[string]int64{
+				"!team:example.org": 0,
+				"!old:example.org":  300,
+			},
+		},
+	}
+	for _, test := range tests {
+		got, err := h.searchScope(context.Background(), test.roomID, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("scope from %s = %v, want %v", test.roomID, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package chat
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/huhndev/gohenry/store"
+)
+
+// RoomSettings are per-room overrides changed with the set command.
+type RoomSettings struct {
+	Model   string `json:"model,omitempty"`
+	Persona string `json:"persona,omitempty"`
+	// Language overrides the users' languages for Henry's own messages.
+	Language string `json:"language,omitempty"`
+	// RoomContextOff keeps the topic and pinned messages out of prompts.
+	RoomContextOff bool `json:"room_context_off,omitempty"`
+}
+
+// SettingsStore keeps room settings in room_settings.json.
+type SettingsStore struct {
+	file *store.File
+
+	mu    sync.Mutex
+	rooms map[string]RoomSettings
+}
+
+func NewSettingsStore(dataDir string) *SettingsStore {
+	s := &SettingsStore{
+		file:  store.NewFile(dataDir, "room_settings.json"),
+		rooms: make(map[string]RoomSettings),
+	}
+	if err := s.file.Load(&s.rooms); err != nil {
+		log.Printf("WARNING: Failed to load room settings: %v", err)
+	}
+	return s
+}
+
+func (s *SettingsStore) Get(roomID string) RoomSettings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rooms[roomID]
+}
+
+// Update applies fn to the room's settings and saves them.
+func (s *SettingsStore) Update(roomID string, fn func(*RoomSettings)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	settings := s.rooms[roomID]
+	fn(&settings)
+	s.rooms[roomID] = settings
+	return s.file.Save(s.rooms)
+}
+
+// Usage counts requests and tokens for one user in one room.
+type Usage struct {
+	Requests     int   `json:"requests"`
+	InputTokens  int   `json:"input_tokens"`
+	OutputTokens int   `json:"output_tokens"`
+	LastUsed     int64 `json:"last_used,omitempty"`
+}
+
+func (u *Usage) add(other Usage) {
+	u.Requests += other.Requests
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	if other.LastUsed > u.LastUsed {
+		u.LastUsed = other.LastUsed
+	}
+}
+
+// UsageTracker records Claude usage per room and user in usage.json.
+type UsageTracker struct {
+	file *store.File
+
+	mu    sync.Mutex
+	rooms map[string]map[string]*Usage
+}
+
+func NewUsageTracker(dataDir string) *UsageTracker {
+	t := &UsageTracker{
+		file:  store.NewFile(dataDir, "usage.json"),
+		rooms: make(map[string]map[string]*Usage),
+	}
+	if err := t.file.Load(&t.rooms); err != nil {
+		log.Printf("WARNING: Failed to load usage stats: %v", err)
+	}
+	return t
+}
+
+// Record adds one Claude request to the sender's tally.
+func (t *UsageTracker) Record(roomID, senderID string, inputTokens, outputTokens int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	users := t.rooms[roomID]
+	if users == nil {
+		users = make(map[string]*Usage)
+		t.rooms[roomID] = users
+	}
+	usage := users[senderID]
+	if usage == nil {
+		usage = &Usage{}
+		users[senderID] = usage
+	}
+	usage.add(Usage{
+		Requests:     1,
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+		LastUsed:     time.Now().UnixNano() / 1e6,
+	})
+
+	if err := t.file.Save(t.rooms); err != nil {
+		log.Printf("WARNING: Failed to save usage stats: %v", err)
+	}
+}
+
+// Forget deletes the user's counts in every room.
+func (t *UsageTracker) Forget(userID string) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	removed := 0
+	for roomID, users := range t.rooms {
+		if _, ok := users[userID]; ok {
+			delete(users, userID)
+			removed++
+		}
+		if len(users) == 0 {
+			delete(t.rooms, roomID)
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	if err := t.file.Save(t.rooms); err != nil {
+		return 0, fmt.Errorf("failed to save usage stats: %v", err)
+	}
+	return removed, nil
+}
+
+// Room returns the room total and a copy of the per-user counts.
+func (t *UsageTracker) Room(roomID string) (Usage, map[string]Usage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var total Usage
+	users := make(map[string]Usage)
+	for userID, usage := range t.rooms[roomID] {
+		users[userID] = *usage
+		total.add(*usage)
+	}
+	return total, users
+}
@@ -0,0 +1,538 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/i18n"
+	"github.com/huhndev/gohenry/scheduler"
+	"github.com/huhndev/gohenry/store"
+)
+
+// Scheduler job kinds used by standups. Text holds the standup name.
+const (
+	standupJobKind      = "standup"
+	standupNudgeJobKind = "standup-nudge"
+	standupCloseJobKind = "standup-close"
+	standupRetryJobKind = "standup-retry"
+)
+
+// standupRetryDelay is how long start waits before asking members again
+// whose direct chat couldn't be opened.
+const standupRetryDelay = 5 * time.Minute
+
+const standupInstructions = `TASK: You are writing the roll-up of an asynchronous team standup, not chatting.
+You get each member's answers to the standup questions.
+Write a short roll-up grouped by person. Start with a "Blockers" section listing
+every blocker or request for help with the person's name, or "none".
+Keep it factual and don't invent anything.`
+
+// StandupConfig is one standup in HENRY_STANDUP_FILE.
+type StandupConfig struct {
+	Name      string   `json:"name"`
+	RoomID    string   `json:"room_id"`
+	Members   []string `json:"members"`
+	Questions []string `json:"questions"`
+	// Schedule is a cron expression evaluated in Timezone.
+	Schedule string `json:"schedule"`
+	Timezone string `json:"timezone,omitempty"`
+	// WindowMinutes is how long answers are collected.
+	WindowMinutes int `json:"window_minutes,omitempty"`
+	// NudgeAfterMinutes is when members who haven't answered are nudged.
+	NudgeAfterMinutes int `json:"nudge_after_minutes,omitempty"`
+	// SkipDays lists weekdays ("saturday") and dates ("2025-12-24")
+	// without standup.
+	SkipDays []string `json:"skip_days,omitempty"`
+}
+
+// standupRun is the state of a standup that is collecting answers.
+type standupRun struct {
+	StartedAt time.Time           `json:"started_at"`
+	ClosesAt  time.Time           `json:"closes_at"`
+	DMRooms   map[string]string   `json:"dm_rooms"`
+	Answers   map[string][]string `json:"answers"`
+	Nudged    map[string]bool     `json:"nudged"`
+}
+
+// id identifies the run in its nudge, close and retry jobs, so a job left
+// over from an earlier run can't act on a newer one.
+func (r *standupRun) id() string {
+	return strconv.FormatInt(r.StartedAt.UnixNano()/1e6, 10)
+}
+
+// StandupService runs asynchronous standups: it asks every member the
+// questions by DM, takes each member's first reply as their answer, nudges
+// those who haven't answered once, and posts a roll-up to the team room.
+type StandupService struct {
+	handler   *MessageHandler
+	scheduler *scheduler.Scheduler
+	file      *store.File
+	standups  map[string]StandupConfig
+
+	mu   sync.Mutex
+	runs map[string]*standupRun
+}
+
+func NewStandupService(
+	handler *MessageHandler,
+	sched *scheduler.Scheduler,
+	standups []StandupConfig,
+) *StandupService {
+	s := &StandupService{
+		handler:   handler,
+		scheduler: sched,
+		file:      store.NewFile(handler.config.DataDir, "standups.json"),
+		standups:  make(map[string]StandupConfig),
+		runs:      make(map[string]*standupRun),
+	}
+	for _, cfg := range standups {
+		s.standups[cfg.Name] = cfg
+	}
+	if err := s.file.Load(&s.runs); err != nil {
+		log.Printf("WARNING: Failed to load standup state: %v", err)
+	}
+	if s.runs == nil {
+		s.runs = make(map[string]*standupRun)
+	}
+
+	sched.Handle(standupJobKind, s.start)
+	sched.Handle(standupNudgeJobKind, s.nudge)
+	sched.Handle(standupCloseJobKind, s.close)
+	sched.Handle(standupRetryJobKind, s.retry)
+	handler.RegisterInterceptor(s.collect)
+	handler.RegisterEraser("standups", s.forget)
+	s.syncJobs()
+	return s
+}
+
+// LoadStandups reads the standups from path, a JSON list of
+// StandupConfig, and fills in defaults. A missing file means no standups.
+func LoadStandups(path, defaultTimezone string) ([]StandupConfig, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read standup config: %v", err)
+	}
+
+	var standups []StandupConfig
+	if err := json.Unmarshal(data, &standups); err != nil {
+		return nil, fmt.Errorf("failed to parse standup config: %v", err)
+	}
+	seen := make(map[string]bool)
+	for i := range standups {
+		cfg := &standups[i]
+		if cfg.Name == "" || cfg.RoomID == "" || len(cfg.Members) == 0 || len(cfg.Questions) == 0 {
+			return nil, fmt.Errorf("standup %q needs a name, room_id, members and questions", cfg.Name)
+		}
+		if seen[cfg.Name] {
+			return nil, fmt.Errorf("standup %q is configured twice", cfg.Name)
+		}
+		seen[cfg.Name] = true
+		if _, err := scheduler.ParseCron(cfg.Schedule); err != nil {
+			return nil, fmt.Errorf("standup %q: %v", cfg.Name, err)
+		}
+		if cfg.Timezone == "" {
+			cfg.Timezone = defaultTimezone
+		}
+		if _, err := time.LoadLocation(cfg.Timezone); err != nil {
+			return nil, fmt.Errorf("standup %q: invalid time zone %q", cfg.Name, cfg.Timezone)
+		}
+		for _, day := range cfg.SkipDays {
+			day = strings.ToLower(strings.TrimSpace(day))
+			if _, err := time.Parse("2006-01-02", day); err != nil && weekday(day) < 0 {
+				return nil, fmt.Errorf("standup %q: skip day %q is neither a weekday nor a date", cfg.Name, day)
+			}
+		}
+		if cfg.WindowMinutes <= 0 {
+			cfg.WindowMinutes = 120
+		}
+		if cfg.NudgeAfterMinutes <= 0 || cfg.NudgeAfterMinutes >= cfg.WindowMinutes {
+			cfg.NudgeAfterMinutes = cfg.WindowMinutes / 2
+		}
+	}
+	log.Printf("Loaded %d standups from %s", len(standups), path)
+	return standups, nil
+}
+
+// syncJobs makes the scheduler's standup jobs match the config: one
+// recurring job per standup, with the current schedule.
+func (s *StandupService) syncJobs() {
+	existing := make(map[string]bool)
+	for _, job := range s.scheduler.List(func(job scheduler.Job) bool { return job.Kind == standupJobKind }) {
+		cfg, ok := s.standups[job.Text]
+		if ok && job.Cron == cfg.Schedule && job.Timezone == cfg.Timezone && job.RoomID == cfg.RoomID {
+			existing[job.Text] = true
+			continue
+		}
+		log.Printf("Removing outdated standup job %s (%s)", job.ID, job.Text)
+		if err := s.scheduler.Cancel(job.ID); err != nil {
+			log.Printf("Failed to remove standup job %s: %v", job.ID, err)
+		}
+	}
+
+	for name, cfg := range s.standups {
+		if existing[name] {
+			continue
+		}
+		job, err := s.scheduler.Add(scheduler.Job{
+			Kind:     standupJobKind,
+			RoomID:   cfg.RoomID,
+			Text:     name,
+			Cron:     cfg.Schedule,
+			Timezone: cfg.Timezone,
+		})
+		if err != nil {
+			log.Printf("Failed to schedule standup %s: %v", name, err)
+			continue
+		}
+		log.Printf("Scheduled standup %s, first run %s", name, job.Due)
+	}
+}
+
+// start sends the questions to every member and schedules the nudge and
+// the roll-up.
+func (s *StandupService) start(ctx context.Context, job scheduler.Job) error {
+	cfg, ok := s.standups[job.Text]
+	if !ok {
+		return nil
+	}
+
+	loc, _ := time.LoadLocation(cfg.Timezone)
+	now := s.scheduler.Now().In(loc)
+	if skipDay(cfg.SkipDays, now) {
+		log.Printf("Skipping standup %s on %s", cfg.Name, now.Format("Monday 2006-01-02"))
+		return nil
+	}
+
+	run := &standupRun{
+		StartedAt: now,
+		ClosesAt:  now.Add(time.Duration(cfg.WindowMinutes) * time.Minute),
+		DMRooms:   make(map[string]string),
+		Answers:   make(map[string][]string),
+		Nudged:    make(map[string]bool),
+	}
+
+	for _, member := range cfg.Members {
+		if dmRoom, ok := s.ask(cfg, member, run.ClosesAt); ok {
+			run.DMRooms[member] = dmRoom
+		}
+	}
+	if len(run.DMRooms) == 0 {
+		return fmt.Errorf("could not reach any member of standup %s", cfg.Name)
+	}
+
+	s.mu.Lock()
+	s.runs[cfg.Name] = run
+	s.persistLocked()
+	s.mu.Unlock()
+
+	jobs := map[string]time.Time{
+		standupNudgeJobKind: now.Add(time.Duration(cfg.NudgeAfterMinutes) * time.Minute),
+		standupCloseJobKind: run.ClosesAt,
+	}
+	if len(run.DMRooms) < len(cfg.Members) {
+		jobs[standupRetryJobKind] = now.Add(standupRetryDelay)
+	}
+	for kind, due := range jobs {
+		if _, err := s.scheduler.Add(scheduler.Job{
+			Kind:   kind,
+			Due:    due,
+			RoomID: cfg.RoomID,
+			Text:   cfg.Name,
+			Ref:    run.id(),
+		}); err != nil {
+			log.Printf("Failed to schedule %s for standup %s: %v", kind, cfg.Name, err)
+		}
+	}
+
+	log.Printf("Started standup %s with %d members", cfg.Name, len(run.DMRooms))
+	return nil
+}
+
+// runForLocked returns the run a nudge, close or retry job belongs to, or
+// nil if that run is over. Jobs scheduled before runs had IDs match the
+// current run.
+func (s *StandupService) runForLocked(job scheduler.Job) *standupRun {
+	run := s.runs[job.Text]
+	if run == nil || (job.Ref != "" && job.Ref != run.id()) {
+		return nil
+	}
+	return run
+}
+
+// ask opens the direct chat with a member and sends the questions. It
+// reports false if the member couldn't be reached.
+func (s *StandupService) ask(cfg StandupConfig, member string, closesAt time.Time) (string, bool) {
+	var questions strings.Builder
+	for i, q := range cfg.Questions {
+		fmt.Fprintf(&questions, "%d. %s\n", i+1, q)
+	}
+	message := s.handler.text("", member, "standup.questions", i18n.Vars{
+		"name":  cfg.Name,
+		"until": closesAt.Format("15:04 MST"),
+	}) + "\n" + questions.String()
+
+	dmRoom, err := s.handler.matrixService.EnsureDirectRoom(member)
+	if err != nil {
+		log.Printf("Failed to open DM with %s for standup %s: %v", member, cfg.Name, err)
+		return "", false
+	}
+	if err := s.handler.matrixService.SendMessage(dmRoom, message); err != nil {
+		log.Printf("Failed to send standup questions to %s: %v", member, err)
+		return "", false
+	}
+	return dmRoom, true
+}
+
+// retry asks the members start couldn't reach, every standupRetryDelay
+// until everyone is reached or the window is about to close.
+func (s *StandupService) retry(ctx context.Context, job scheduler.Job) error {
+	cfg, ok := s.standups[job.Text]
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	run := s.runForLocked(job)
+	var unreached []string
+	if run != nil {
+		for _, member := range cfg.Members {
+			if _, asked := run.DMRooms[member]; !asked {
+				unreached = append(unreached, member)
+			}
+		}
+	}
+	s.mu.Unlock()
+	if run == nil {
+		return nil
+	}
+
+	reached := make(map[string]string)
+	for _, member := range unreached {
+		if dmRoom, ok := s.ask(cfg, member, run.ClosesAt); ok {
+			reached[member] = dmRoom
+		}
+	}
+
+	s.mu.Lock()
+	if s.runs[job.Text] == run && len(reached) > 0 {
+		for member, dmRoom := range reached {
+			run.DMRooms[member] = dmRoom
+		}
+		s.persistLocked()
+	}
+	s.mu.Unlock()
+	log.Printf("Reached %d of %d remaining members of standup %s", len(reached), len(unreached), cfg.Name)
+
+	next := s.scheduler.Now().Add(standupRetryDelay)
+	if len(reached) < len(unreached) && next.Before(run.ClosesAt) {
+		if _, err := s.scheduler.Add(scheduler.Job{
+			Kind:   standupRetryJobKind,
+			Due:    next,
+			RoomID: cfg.RoomID,
+			Text:   cfg.Name,
+			Ref:    job.Ref,
+		}); err != nil {
+			log.Printf("Failed to schedule %s for standup %s: %v", standupRetryJobKind, cfg.Name, err)
+		}
+	}
+	return nil
+}
+
+// collect records a member's first DM after the questions as their answer.
+// Later messages and commands are handled as usual. It returns true if the
+// message was consumed as an answer.
+func (s *StandupService) collect(ctx context.Context, msg *domain.Message) bool {
+	content := strings.TrimSpace(msg.Content)
+	if content == "" || strings.HasPrefix(content, s.handler.config.CommandPrefix) || !s.handler.mayShare(msg) {
+		return false
+	}
+	s.mu.Lock()
+	var name string
+	var run *standupRun
+	for n, r := range s.runs {
+		if r.DMRooms[msg.SenderID] == msg.RoomID && len(r.Answers[msg.SenderID]) == 0 {
+			name, run = n, r
+			break
+		}
+	}
+	if run == nil {
+		s.mu.Unlock()
+		return false
+	}
+
+	run.Answers[msg.SenderID] = []string{msg.Content}
+	s.persistLocked()
+	closesAt := run.ClosesAt
+	s.mu.Unlock()
+
+	log.Printf("Recorded standup answer from %s for %s", msg.SenderID, name)
+	reply := s.handler.text(msg.RoomID, msg.SenderID, "standup.noted", i18n.Vars{
+		"name":  name,
+		"until": closesAt.Format("15:04 MST"),
+	})
+	if err := s.handler.matrixService.SendMessage(msg.RoomID, reply); err != nil {
+		log.Printf("Failed to acknowledge standup answer: %v", err)
+	}
+	return true
+}
+
+// nudge reminds members who haven't answered yet, once.
+func (s *StandupService) nudge(ctx context.Context, job scheduler.Job) error {
+	s.mu.Lock()
+	run := s.runForLocked(job)
+	if run == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	pending := make(map[string]string)
+	for member, dmRoom := range run.DMRooms {
+		if len(run.Answers[member]) == 0 && !run.Nudged[member] {
+			pending[member] = dmRoom
+			run.Nudged[member] = true
+		}
+	}
+	s.persistLocked()
+	closesAt := run.ClosesAt
+	s.mu.Unlock()
+
+	for member, dmRoom := range pending {
+		reply := s.handler.text(dmRoom, member, "standup.nudge", i18n.Vars{
+			"name":  job.Text,
+			"until": closesAt.Format("15:04 MST"),
+		})
+		if err := s.handler.matrixService.SendMessage(dmRoom, reply); err != nil {
+			log.Printf("Failed to nudge %s: %v", member, err)
+		}
+	}
+	return nil
+}
+
+// close posts the roll-up to the team room and ends the run.
+func (s *StandupService) close(ctx context.Context, job scheduler.Job) error {
+	s.mu.Lock()
+	run := s.runForLocked(job)
+	s.mu.Unlock()
+	if run == nil {
+		return nil
+	}
+	cfg := s.standups[job.Text]
+
+	members := make([]string, 0, len(run.DMRooms))
+	for member := range run.DMRooms {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+
+	var answers strings.Builder
+	fmt.Fprintf(&answers, "Questions:\n")
+	for i, q := range cfg.Questions {
+		fmt.Fprintf(&answers, "%d. %s\n", i+1, q)
+	}
+	var missing []string
+	for _, member := range members {
+		if len(run.Answers[member]) == 0 {
+			missing = append(missing, member)
+			continue
+		}
+		fmt.Fprintf(&answers, "\nAnswers from %s:\n%s\n", member, strings.Join(run.Answers[member], "\n"))
+	}
+
+	rollup := s.handler.text(job.RoomID, "", "standup.nobody", nil)
+	if len(missing) < len(members) {
+		resp, err := s.handler.aiService.GenerateResponse(ctx, domain.AIRequest{
+			Messages: []domain.ConversationMessage{{
+				Role:      domain.RoleUser,
+				Content:   answers.String(),
+				Timestamp: s.scheduler.Now().UnixNano() / 1e6,
+			}},
+			Instructions: standupInstructions,
+			MaxTokens:    summaryMaxTokens,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write standup roll-up: %v", err)
+		}
+		rollup = resp.Content
+	}
+
+	message := s.handler.text(job.RoomID, "", "standup.rollup", i18n.Vars{
+		"name": cfg.Name,
+		"date": run.StartedAt.Format("2006-01-02"),
+	}) + "\n\n" + rollup
+	if len(missing) > 0 {
+		message += "\n\n" + s.handler.text(job.RoomID, "", "standup.missing", i18n.Vars{
+			"members": strings.Join(missing, ", "),
+		})
+	}
+	if err := queuedAsSent(s.handler.matrixService.SendMessage(job.RoomID, message)); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.runs[job.Text] == run {
+		delete(s.runs, job.Text)
+		s.persistLocked()
+	}
+	s.mu.Unlock()
+	log.Printf("Closed standup %s", job.Text)
+	return nil
+}
+
+// skipDay reports whether t falls on one of the skip days, given as
+// weekday names or YYYY-MM-DD dates.
+func skipDay(skipDays []string, t time.Time) bool {
+	for _, day := range skipDays {
+		day = strings.ToLower(strings.TrimSpace(day))
+		if day == t.Format("2006-01-02") || weekday(day) == int(t.Weekday()) {
+			return true
+		}
+	}
+	return false
+}
+
+// forget deletes the user's answers and direct chat from running
+// standups. Members are configured by the admin and stay.
+func (s *StandupService) forget(userID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed, changed := 0, false
+	for _, run := range s.runs {
+		_, asked := run.DMRooms[userID]
+		_, answered := run.Answers[userID]
+		if !asked && !answered && !run.Nudged[userID] {
+			continue
+		}
+		removed += len(run.Answers[userID])
+		delete(run.Answers, userID)
+		delete(run.DMRooms, userID)
+		delete(run.Nudged, userID)
+		changed = true
+	}
+	if !changed {
+		return 0, nil
+	}
+	if err := s.file.Save(s.runs); err != nil {
+		return 0, fmt.Errorf("failed to save standup state: %v", err)
+	}
+	return removed, nil
+}
+
+func (s *StandupService) persistLocked() {
+	if err := s.file.Save(s.runs); err != nil {
+		log.Printf("WARNING: Failed to save standup state: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/huhndev/gohenry/scheduler"
+)
+
+// sendMatrix records the messages sent to each room.
+type sendMatrix struct {
+	*fakeMatrix
+	sent map[string][]string
+}
+
+func (m *sendMatrix) SendMessage(roomID, message string) error {
+	m.sent[roomID] = append(m.sent[roomID], message)
+	return nil
+}
+
+func TestStandupIgnoresJobsOfEarlierRuns(t *testing.T) {
+	matrix := &sendMatrix{fakeMatrix: &fakeMatrix{accountData: make(map[string][]byte)}, sent: make(map[string][]string)}
+	h := newTestHandler(t, matrix)
+	s := NewStandupService(h, scheduler.New(h.config.DataDir, scheduler.SystemClock{}), nil)
+
+	const dm = "!dm-alice:example.org"
+	started := time.Date(2026, 3, 2, 9, 30, 0, 0, time.UTC)
+	s.standups["backend"] = StandupConfig{Name: "backend", RoomID: testRoom, Members: []string{alice}, Questions: []string{"Status?"}}
+	run := &standupRun{
+		StartedAt: started,
+		ClosesAt:  started.Add(time.Hour),
+		DMRooms:   map[string]string{alice: dm},
+		Answers:   make(map[string][]string),
+		Nudged:    make(map[string]bool),
+	}
+	s.runs["backend"] = run
+
+	earlier := standupRun{StartedAt: started.Add(-24 * time.Hour)}
+	stale := scheduler.Job{RoomID: testRoom, Text: "backend", Ref: earlier.id()}
+	ctx := context.Background()
+	// With the stale ref, close would post a roll-up, which the fake
+	// can't do without an AI service, and nudge would message alice.
+	stale.Kind = standupCloseJobKind
+	if err := s.close(ctx, stale); err != nil {
+		t.Fatal(err)
+	}
+	stale.Kind = standupNudgeJobKind
+	if err := s.nudge(ctx, stale); err != nil {
+		t.Fatal(err)
+	}
+	if s.runs["backend"] != run || len(matrix.sent) != 0 {
+		t.Fatalf("a job of an earlier run acted on the current one: runs %v, sent %v", s.runs, matrix.sent)
+	}
+
+	current := scheduler.Job{Kind: standupNudgeJobKind, RoomID: testRoom, Text: "backend", Ref: run.id()}
+	if err := s.nudge(ctx, current); err != nil {
+		t.Fatal(err)
+	}
+	if len(matrix.sent[dm]) != 1 {
+		t.Errorf("the current run's nudge sent %v", matrix.sent)
+	}
+}
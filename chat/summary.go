@@ -0,0 +1,306 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/i18n"
+)
+
+const (
+	// defaultSummaryCount is used when no range is given.
+	defaultSummaryCount = 100
+	// summaryChunkChars bounds the transcript sent with one request, well
+	// below the model's context window.
+	summaryChunkChars = 24000
+	// summaryLineChars truncates single very long messages, in runes.
+	summaryLineChars = 1500
+	summaryMaxTokens = 1500
+)
+
+const chunkInstructions = `TASK: You are summarizing a part of a chat transcript, not chatting.
+Each line starts with a reference like [m12], a time and the speaker's name.
+Write a concise summary of what was discussed, decided and left open.
+Name participants by the names used in the transcript.
+After each key point, cite the line it comes from with its reference, e.g. [m12].
+Only cite references that appear in the transcript.`
+
+const mergeInstructions = `TASK: You are merging partial summaries of consecutive parts of one chat, oldest first.
+Write a single concise summary of what was discussed, decided and left open.
+Keep participant names and keep the [m12]-style references of the key points.`
+
+var summaryRefPattern = regexp.MustCompile(`\[m(\d+)\]`)
+
+// summaryRange is what the summarize command was asked to cover.
+type summaryRange struct {
+	count    int
+	since    time.Time
+	threadID string
+}
+
+func (h *MessageHandler) registerSummaryCommand() {
+	h.router.Register(&Command{
+		Name:    "summarize",
+		Aliases: []string{"summary", "tldr"},
+		Args:    []Arg{{Name: "range", Type: ArgText, Optional: true}},
+		Handler: h.cmdSummarize,
+	})
+}
+
+func (h *MessageHandler) cmdSummarize(ctx context.Context, inv *Invocation) (string, error) {
+	rng, err := parseSummaryRange(inv.String("range"), inv.ThreadID, time.Now().In(h.users.Location(inv.SenderID)))
+	if err != nil {
+		return i18n.ErrorText(inv.Lang, err), nil
+	}
+
+	limit := h.config.SummaryMaxMessages
+	if rng.count > 0 && rng.count < limit {
+		limit = rng.count
+	}
+	var since int64
+	if !rng.since.IsZero() {
+		since = rng.since.UnixNano() / 1e6
+	}
+
+	if err := h.matrixService.SendTyping(inv.RoomID, true, 60000); err != nil {
+		log.Printf("Error sending typing notification: %v", err)
+	}
+	defer func() {
+		if err := h.matrixService.SendTyping(inv.RoomID, false, 0); err != nil {
+			log.Printf("Error stopping typing notification: %v", err)
+		}
+	}()
+
+	messages, err := h.matrixService.GetRoomHistory(ctx, inv.RoomID, rng.threadID, since, limit+1)
+	if err != nil {
+		return "", err
+	}
+	// Leave out the summarize command itself, Henry's own commands and
+	// messages of users without consent.
+	var history []*domain.Message
+	for _, msg := range messages {
+		if msg.ID == inv.EventID || (!msg.IsFromBot && h.router.HasPrefix(strings.TrimSpace(msg.Content))) ||
+			!h.mayShare(msg) {
+			continue
+		}
+		history = append(history, msg)
+	}
+	if len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+	if len(history) == 0 {
+		return inv.Text("summary.empty", nil), nil
+	}
+
+	summary, err := h.summarizeHistory(ctx, inv.RoomID, inv.SenderID, history)
+	if err != nil {
+		return "", err
+	}
+
+	header := inv.Text("summary.header", i18n.Vars{
+		"count": len(history),
+		"since": h.users.Format(inv.SenderID, time.Unix(0, history[0].Timestamp*int64(time.Millisecond))),
+	})
+	return header + "\n\n" + summary, nil
+}
+
+// summarizeHistory summarizes history chunk by chunk, merges the partial
+// summaries and links the cited messages. Usage is billed to requesterID.
+func (h *MessageHandler) summarizeHistory(
+	ctx context.Context,
+	roomID, requesterID string,
+	history []*domain.Message,
+) (string, error) {
+	chunks := summaryChunks(history, h.users.Location(requesterID))
+	log.Printf("Summarizing %d messages in %d chunks for room %s", len(history), len(chunks), roomID)
+
+	partials := make([]string, 0, len(chunks))
+	for i, chunk := range chunks {
+		summary, err := h.summarize(ctx, roomID, requesterID, chunkInstructions, chunk)
+		if err != nil {
+			return "", fmt.Errorf("failed to summarize part %d of %d: %v", i+1, len(chunks), err)
+		}
+		partials = append(partials, summary)
+	}
+
+	summary := partials[0]
+	if len(partials) > 1 {
+		var merged strings.Builder
+		for i, partial := range partials {
+			fmt.Fprintf(&merged, "Part %d:\n%s\n\n", i+1, partial)
+		}
+		var err error
+		if summary, err = h.summarize(ctx, roomID, requesterID, mergeInstructions, merged.String()); err != nil {
+			return "", fmt.Errorf("failed to merge summaries: %v", err)
+		}
+	}
+
+	return h.linkReferences(summary, roomID, history), nil
+}
+
+// summarize sends one transcript or set of partial summaries to Claude.
+func (h *MessageHandler) summarize(
+	ctx context.Context,
+	roomID, requesterID string,
+	instructions, text string,
+) (string, error) {
+	resp, err := h.aiService.GenerateResponse(ctx, domain.AIRequest{
+		Messages: []domain.ConversationMessage{{
+			Role:      domain.RoleUser,
+			Content:   text,
+			Timestamp: time.Now().UnixNano() / 1e6,
+			SenderID:  requesterID,
+		}},
+		Model:        h.settings.Get(roomID).Model,
+		Instructions: instructions,
+		Location:     h.users.Location(requesterID),
+		Locale:       h.users.Locale(requesterID),
+		MaxTokens:    summaryMaxTokens,
+	})
+	if err != nil {
+		return "", err
+	}
+	h.usage.Record(roomID, requesterID, resp.InputTokens, resp.OutputTokens)
+	return resp.Content, nil
+}
+
+// summaryChunks renders the history as numbered transcript lines and splits
+// them into chunks that fit one request. Reference numbers are indexes
+// into history. Times are shown in loc.
+func summaryChunks(history []*domain.Message, loc *time.Location) []string {
+	var chunks []string
+	var chunk strings.Builder
+	for i, msg := range history {
+		text := strings.Join(strings.Fields(msg.Content), " ")
+		if runes := []rune(text); len(runes) > summaryLineChars {
+			text = string(runes[:summaryLineChars]) + "…"
+		}
+		name := msg.SenderName
+		if name == "" {
+			name = msg.SenderID
+		}
+		line := fmt.Sprintf("[m%d] %s %s: %s\n", i, formatSummaryTime(msg.Timestamp, loc), name, text)
+
+		if chunk.Len() > 0 && chunk.Len()+len(line) > summaryChunkChars {
+			chunks = append(chunks, chunk.String())
+			chunk.Reset()
+		}
+		chunk.WriteString(line)
+	}
+	if chunk.Len() > 0 {
+		chunks = append(chunks, chunk.String())
+	}
+	return chunks
+}
+
+// linkReferences replaces [m12]-style references with permalinks to the
+// cited messages. Unknown references are dropped.
+func (h *MessageHandler) linkReferences(summary, roomID string, history []*domain.Message) string {
+	linked := summaryRefPattern.ReplaceAllStringFunc(summary, func(ref string) string {
+		idx, err := strconv.Atoi(summaryRefPattern.FindStringSubmatch(ref)[1])
+		if err != nil || idx < 0 || idx >= len(history) {
+			return ""
+		}
+		return "(" + h.matrixService.Permalink(roomID, history[idx].ID) + ")"
+	})
+	return strings.ReplaceAll(linked, " \n", "\n")
+}
+
+func formatSummaryTime(ms int64, loc *time.Location) string {
+	return time.Unix(0, ms*int64(time.Millisecond)).In(loc).Format("2006-01-02 15:04")
+}
+
+// parseSummaryRange understands "", "this thread", "last 200 [messages]",
+// "200" and "since <time>".
+func parseSummaryRange(text, threadID string, now time.Time) (summaryRange, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	words := strings.Fields(text)
+
+	switch {
+	case text == "":
+		if threadID != "" {
+			return summaryRange{threadID: threadID}, nil
+		}
+		return summaryRange{count: defaultSummaryCount}, nil
+
+	case text == "thread" || text == "this thread":
+		if threadID == "" {
+			return summaryRange{}, i18n.NewError("summary.not_thread", nil)
+		}
+		return summaryRange{threadID: threadID}, nil
+
+	case words[0] == "since":
+		since, err := parseSince(strings.Join(words[1:], " "), now)
+		if err != nil {
+			return summaryRange{}, err
+		}
+		return summaryRange{since: since, threadID: threadID}, nil
+	}
+
+	if words[0] == "last" {
+		words = words[1:]
+	}
+	if len(words) > 0 {
+		if n, err := strconv.Atoi(words[0]); err == nil && n > 0 &&
+			(len(words) == 1 || (len(words) == 2 && strings.HasPrefix(words[1], "message"))) {
+			return summaryRange{count: n, threadID: threadID}, nil
+		}
+	}
+	return summaryRange{}, i18n.NewError("summary.invalid_range", i18n.Vars{"text": text})
+}
+
+// parseSince understands durations ("2h", "3d"), dates and times such as
+// "yesterday 9am", "today 14:00", "9:30", "2025-03-21" or
+// "2025-03-21 09:00", in the local time zone. A bare time in the future
+// means yesterday.
+func parseSince(text string, now time.Time) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return time.Time{}, i18n.NewError("summary.since_missing", nil)
+	}
+
+	if d, err := parseDuration(text); err == nil {
+		return now.Add(-d), nil
+	}
+
+	words := strings.Fields(text)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	explicitDay := true
+	switch words[0] {
+	case "today":
+		words = words[1:]
+	case "yesterday":
+		day = day.AddDate(0, 0, -1)
+		words = words[1:]
+	default:
+		if t, err := time.ParseInLocation("2006-01-02", words[0], now.Location()); err == nil {
+			day = t
+			words = words[1:]
+		} else {
+			explicitDay = false
+		}
+	}
+
+	if len(words) == 0 {
+		return day, nil
+	}
+	if len(words) > 1 {
+		return time.Time{}, i18n.NewError("time.invalid_clock", i18n.Vars{"text": text})
+	}
+
+	hour, minute, err := parseClock(words[0])
+	if err != nil {
+		return time.Time{}, err
+	}
+	t := day.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+	if !explicitDay && t.After(now) {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t, nil
+}
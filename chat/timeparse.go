@@ -0,0 +1,174 @@
+package chat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/huhndev/gohenry/i18n"
+)
+
+// defaultReminderHour is used when a day is given without a time.
+const defaultReminderHour = 9
+
+// parseWhen parses a future point in time relative to now, in now's time
+// zone: "in 2h", "in 30 minutes", "tomorrow at 10am", "friday 14:00",
+// "2025-03-21 09:00" or a bare "10am", which means its next occurrence.
+func parseWhen(text string, now time.Time) (time.Time, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	invalid := i18n.NewError("time.invalid_when", i18n.Vars{"text": text})
+
+	if strings.HasPrefix(text, "in ") {
+		d, err := parseDuration(strings.TrimPrefix(text, "in "))
+		if err != nil || d <= 0 {
+			return time.Time{}, invalid
+		}
+		return now.Add(d), nil
+	}
+
+	var words []string
+	for _, word := range strings.Fields(text) {
+		if word != "at" && word != "on" {
+			words = append(words, word)
+		}
+	}
+	if len(words) == 0 || len(words) > 2 {
+		return time.Time{}, invalid
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	day, explicitDay, isWeekday := today, true, false
+	switch {
+	case words[0] == "today":
+	case words[0] == "tomorrow":
+		day = today.AddDate(0, 0, 1)
+	case weekday(words[0]) >= 0:
+		ahead := (weekday(words[0]) - int(now.Weekday()) + 7) % 7
+		day = today.AddDate(0, 0, ahead)
+		isWeekday = true
+	default:
+		t, err := time.ParseInLocation("2006-01-02", words[0], now.Location())
+		if err != nil {
+			explicitDay = false
+		} else {
+			day = t
+		}
+	}
+	if explicitDay {
+		words = words[1:]
+	}
+
+	hour, minute := defaultReminderHour, 0
+	switch len(words) {
+	case 0:
+	case 1:
+		var err error
+		if hour, minute, err = parseClock(words[0]); err != nil {
+			return time.Time{}, invalid
+		}
+	default:
+		return time.Time{}, invalid
+	}
+
+	t := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, now.Location())
+	if !t.After(now) {
+		switch {
+		case !explicitDay:
+			t = t.AddDate(0, 0, 1)
+		case isWeekday:
+			t = t.AddDate(0, 0, 7)
+		default:
+			return time.Time{}, i18n.NewError("time.past", i18n.Vars{"time": t.Format("2006-01-02 15:04")})
+		}
+	}
+	return t, nil
+}
+
+// weekday returns the day of the week for a name like "monday" or "mon",
+// or -1.
+func weekday(name string) int {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		full := strings.ToLower(d.String())
+		if name == full || (len(name) >= 3 && strings.HasPrefix(full, name)) {
+			return int(d)
+		}
+	}
+	return -1
+}
+
+// durationUnits maps spelled-out units to their length.
+var durationUnits = map[string]time.Duration{
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute,
+	"minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+// parseDuration accepts Go durations plus days, e.g. "3d", and spelled-out
+// ones such as "2 hours" or "a day".
+func parseDuration(text string) (time.Duration, error) {
+	words := strings.Fields(text)
+	if len(words) == 2 {
+		unit, ok := durationUnits[words[1]]
+		if !ok {
+			return 0, fmt.Errorf("unknown unit %q", words[1])
+		}
+		n := 1
+		if words[0] != "a" && words[0] != "an" {
+			var err error
+			if n, err = strconv.Atoi(words[0]); err != nil {
+				return 0, err
+			}
+		}
+		return time.Duration(n) * unit, nil
+	}
+
+	if strings.HasSuffix(text, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(text, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(text)
+}
+
+// parseClock accepts "9am", "9:30pm", "14:00" and "14".
+func parseClock(text string) (hour, minute int, err error) {
+	invalid := i18n.NewError("time.invalid_clock", i18n.Vars{"text": text})
+
+	pm := strings.HasSuffix(text, "pm")
+	am := strings.HasSuffix(text, "am")
+	text = strings.TrimSuffix(strings.TrimSuffix(text, "pm"), "am")
+
+	// Atoi takes a sign, and time.Date would turn a negative hour into
+	// the evening before.
+	if strings.ContainsAny(text, "+-") {
+		return 0, 0, invalid
+	}
+	parts := strings.SplitN(text, ":", 2)
+	if hour, err = strconv.Atoi(parts[0]); err != nil {
+		return 0, 0, invalid
+	}
+	if len(parts) == 2 {
+		if minute, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0, invalid
+		}
+	}
+
+	if am || pm {
+		if hour < 1 || hour > 12 {
+			return 0, 0, invalid
+		}
+		hour %= 12
+		if pm {
+			hour += 12
+		}
+	}
+	if hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, 0, invalid
+	}
+	return hour, minute, nil
+}
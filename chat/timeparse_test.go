@@ -0,0 +1,72 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWhen(t *testing.T) {
+	// A Friday.
+	now := time.Date(2024, 3, 1, 14, 30, 0, 0, time.UTC)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 3, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		text string
+		want time.Time
+	}{
+		{"in 10m", now.Add(10 * time.Minute)},
+		{"in 2 hours", now.Add(2 * time.Hour)},
+		{"in a day", now.Add(24 * time.Hour)},
+		{"in 3d", now.Add(72 * time.Hour)},
+		{"tomorrow", at(2, defaultReminderHour, 0)},
+		{"tomorrow at 9:30pm", at(2, 21, 30)},
+		{"today at 18:00", at(1, 18, 0)},
+		{"  Today AT 6PM ", at(1, 18, 0)},
+		// A time that has passed today means tomorrow.
+		{"at 9am", at(2, 9, 0)},
+		{"15", at(1, 15, 0)},
+		{"12am", at(2, 0, 0)},
+		{"12pm", at(2, 12, 0)},
+		// A weekday that has passed this week means next week, today
+		// included.
+		{"monday", at(4, defaultReminderHour, 0)},
+		{"fri 16:00", at(1, 16, 0)},
+		{"on fri at 9am", at(8, 9, 0)},
+		{"2024-03-05 8:15", at(5, 8, 15)},
+	}
+	for _, test := range tests {
+		got, err := parseWhen(test.text, now)
+		if err != nil {
+			t.Errorf("parseWhen(%q): %v", test.text, err)
+			continue
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("parseWhen(%q) = %s, want %s", test.text, got, test.want)
+		}
+	}
+
+	for _, text := range []string{
+		"",
+		"in",
+		"in 0m",
+		"in -5m",
+		"in 5 parsecs",
+		"today at 10am",
+		"2024-02-28",
+		"tomorrow 25:00",
+		"tomorrow 13pm",
+		"tomorrow 9:60",
+		"tomorrow -3",
+		"tomorrow at -3",
+		"tomorrow +9",
+		"tomorrow 9:-5",
+		"next week sometime",
+		"whenever",
+	} {
+		if got, err := parseWhen(text, now); err == nil {
+			t.Errorf("parseWhen(%q) = %s, want an error", text, got)
+		}
+	}
+}
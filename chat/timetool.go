@@ -0,0 +1,98 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/huhndev/gohenry/domain"
+)
+
+// parseToolTime reads "now", RFC 3339, "2006-01-02 15:04" or "15:04"
+// (today) in loc.
+func parseToolTime(text string, loc *time.Location, now time.Time) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	if text == "" || strings.EqualFold(text, "now") {
+		return now, nil
+	}
+	if t, err := time.Parse(time.RFC3339, text); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", text, loc); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("15:04", text, loc); err == nil {
+		today := now.In(loc)
+		return time.Date(today.Year(), today.Month(), today.Day(), t.Hour(), t.Minute(), 0, 0, loc), nil
+	}
+	return time.Time{}, fmt.Errorf("can't read time %q; use now, 2006-01-02 15:04 or 15:04", text)
+}
+
+// convertTimeTool converts times between zones, defaulting to the time
+// zone and locale of the user Claude is answering.
+func (h *MessageHandler) convertTimeTool(msg *domain.Message) domain.Tool {
+	return domain.Tool{
+		Name: "convert_time",
+		Description: "Convert a date and time between time zones. Use it whenever the user mentions a time " +
+			"in another zone or asks what time it is somewhere. Zones are IANA names like Europe/Berlin; " +
+			"both default to the user's own time zone.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"time": map[string]interface{}{
+					"type":        "string",
+					"description": `"now", "2006-01-02 15:04", "15:04" for today, or RFC 3339`,
+				},
+				"from_zone": map[string]interface{}{
+					"type":        "string",
+					"description": "Zone the time is given in",
+				},
+				"to_zones": map[string]interface{}{
+					"type":        "array",
+					"items":       map[string]interface{}{"type": "string"},
+					"description": "Zones to convert to",
+				},
+			},
+			"required": []string{"time"},
+		},
+		Run: func(ctx context.Context, input json.RawMessage) (string, error) {
+			var args struct {
+				Time     string   `json:"time"`
+				FromZone string   `json:"from_zone"`
+				ToZones  []string `json:"to_zones"`
+			}
+			if err := json.Unmarshal(input, &args); err != nil {
+				return "", fmt.Errorf("invalid input: %v", err)
+			}
+
+			from := h.users.Location(msg.SenderID)
+			if args.FromZone != "" {
+				var err error
+				if from, err = time.LoadLocation(args.FromZone); err != nil {
+					return "", fmt.Errorf("unknown time zone %q", args.FromZone)
+				}
+			}
+			t, err := parseToolTime(args.Time, from, time.Now())
+			if err != nil {
+				return "", err
+			}
+
+			if len(args.ToZones) == 0 {
+				args.ToZones = []string{h.users.Location(msg.SenderID).String()}
+			}
+			locale := h.users.Locale(msg.SenderID)
+			lines := []string{fmt.Sprintf("%s: %s", from, formatTime(t.In(from), locale))}
+			for _, zone := range args.ToZones {
+				loc, err := time.LoadLocation(zone)
+				if err != nil {
+					lines = append(lines, fmt.Sprintf("%s: unknown time zone", zone))
+					continue
+				}
+				lines = append(lines, fmt.Sprintf("%s: %s", loc, formatTime(t.In(loc), locale)))
+			}
+			return strings.Join(lines, "\n"), nil
+		},
+	}
+}
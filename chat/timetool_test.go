@@ -0,0 +1,45 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseToolTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 28, 23, 30, 0, 0, time.UTC) // already the 29th in Berlin
+
+	tests := []struct {
+		text    string
+		want    time.Time
+		wantErr bool
+	}{
+		{text: "", want: now},
+		{text: " NOW ", want: now},
+		{text: "2026-04-01T09:00:00-04:00", want: time.Date(2026, 4, 1, 13, 0, 0, 0, time.UTC)},
+		{text: "2026-04-01 09:00", want: time.Date(2026, 4, 1, 9, 0, 0, 0, berlin)},
+		{text: "09:15", want: time.Date(2026, 3, 29, 9, 15, 0, 0, berlin)},
+		{text: "tomorrow", wantErr: true},
+		{text: "25:00", wantErr: true},
+		{text: "2026-04-01", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseToolTime(tt.text, berlin, now)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseToolTime(%q) = %v, want an error", tt.text, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseToolTime(%q): %v", tt.text, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseToolTime(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
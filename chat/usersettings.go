@@ -0,0 +1,294 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/i18n"
+	"github.com/huhndev/gohenry/store"
+)
+
+// userSettingsEventType is the account data event of Henry's own user that
+// holds all user settings. All users share the one event, and homeservers
+// may cap it at the 64 KiB of other events, which leaves room for about
+// 600 users; saveLocked warns when it's getting close.
+const userSettingsEventType = "dev.huhn.henry.user_settings"
+
+const (
+	// userSettingsWarnSize is the event size above which saving warns.
+	userSettingsWarnSize = 48 * 1024
+	// userSettingsRetryDelay is the wait after the first failed load; it
+	// doubles up to userSettingsMaxRetryDelay.
+	userSettingsRetryDelay    = 10 * time.Second
+	userSettingsMaxRetryDelay = 10 * time.Minute
+)
+
+// UserSettings are per-user preferences.
+type UserSettings struct {
+	Timezone string `json:"timezone,omitempty"`
+	Locale   string `json:"locale,omitempty"`
+}
+
+// userSettingsContent is the content of the account data event.
+type userSettingsContent struct {
+	Users map[string]UserSettings `json:"users"`
+}
+
+// UserSettingsStore keeps user settings in Henry's account data, so they
+// live on the homeserver rather than on local disk. The store is created
+// before Henry connects; until Load succeeds everyone gets the defaults
+// and changes are refused. Changes are saved under the lock, so two of
+// them can't overwrite each other's users.
+type UserSettingsStore struct {
+	matrixService   domain.MatrixService
+	legacyFile      *store.File
+	defaultLocation *time.Location
+	defaultLocale   string
+
+	mu     sync.Mutex
+	loaded bool
+	users  map[string]UserSettings
+}
+
+func NewUserSettingsStore(
+	matrixService domain.MatrixService,
+	dataDir, defaultTimezone, defaultLocale string,
+) *UserSettingsStore {
+	s := &UserSettingsStore{
+		matrixService:   matrixService,
+		legacyFile:      store.NewFile(dataDir, "user_settings.json"),
+		defaultLocation: time.Local,
+		defaultLocale:   defaultLocale,
+		users:           make(map[string]UserSettings),
+	}
+	if loc, err := time.LoadLocation(defaultTimezone); err == nil {
+		s.defaultLocation = loc
+	}
+	return s
+}
+
+// Load reads the settings from account data, retrying with backoff until
+// it succeeds or ctx is done. Call it once Henry is connected.
+func (s *UserSettingsStore) Load(ctx context.Context) {
+	delay := userSettingsRetryDelay
+	for {
+		err := s.load()
+		if err == nil {
+			return
+		}
+		log.Printf("WARNING: Failed to load user settings, using defaults and retrying in %s: %v", delay, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > userSettingsMaxRetryDelay {
+			delay = userSettingsMaxRetryDelay
+		}
+	}
+}
+
+// load fetches the settings without holding the lock. Settings from the
+// user_settings.json of older versions are moved over.
+func (s *UserSettingsStore) load() error {
+	var content userSettingsContent
+	if err := s.matrixService.GetAccountData(userSettingsEventType, &content); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loaded = true
+	if content.Users != nil {
+		s.users = content.Users
+	}
+	log.Printf("Loaded settings of %d users", len(s.users))
+
+	legacy := make(map[string]UserSettings)
+	if err := s.legacyFile.Load(&legacy); err != nil || len(legacy) == 0 {
+		return nil
+	}
+	for userID, settings := range legacy {
+		if _, ok := s.users[userID]; !ok {
+			s.users[userID] = settings
+		}
+	}
+	if err := s.saveLocked(); err != nil {
+		log.Printf("WARNING: Failed to migrate user settings: %v", err)
+		return nil
+	}
+	if err := os.Remove(s.legacyFile.Path()); err != nil {
+		log.Printf("WARNING: Failed to remove %s: %v", s.legacyFile.Path(), err)
+	}
+	log.Printf("Moved settings of %d users from %s to account data", len(legacy), s.legacyFile.Path())
+	return nil
+}
+
+// Get returns the user's settings with defaults filled in.
+func (s *UserSettingsStore) Get(userID string) UserSettings {
+	s.mu.Lock()
+	settings := s.users[userID]
+	s.mu.Unlock()
+
+	if settings.Locale == "" {
+		settings.Locale = s.defaultLocale
+	}
+	if settings.Timezone == "" {
+		settings.Timezone = s.defaultLocation.String()
+	}
+	return settings
+}
+
+// Location returns the user's time zone, or the configured default.
+func (s *UserSettingsStore) Location(userID string) *time.Location {
+	tz := s.Get(userID).Timezone
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		log.Printf("Invalid stored time zone %q for %s: %v", tz, userID, err)
+		return s.defaultLocation
+	}
+	return loc
+}
+
+// Locale returns the user's locale, or the configured default.
+func (s *UserSettingsStore) Locale(userID string) string {
+	return s.Get(userID).Locale
+}
+
+// OwnLocale returns the locale the user chose themselves, or "" if they
+// use the default.
+func (s *UserSettingsStore) OwnLocale(userID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.users[userID].Locale
+}
+
+// Format formats t in the user's time zone and locale.
+func (s *UserSettingsStore) Format(userID string, t time.Time) string {
+	return formatTime(t.In(s.Location(userID)), s.Locale(userID))
+}
+
+// SetTimezone validates and stores the user's time zone. An empty zone
+// restores the default. "Local" is refused: it names the server's zone,
+// not one the user is in.
+func (s *UserSettingsStore) SetTimezone(userID, tz string) error {
+	if tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil || tz == "Local" {
+			return i18n.NewError("timezone.unknown", i18n.Vars{"zone": tz})
+		}
+	}
+	return s.update(userID, func(settings *UserSettings) { settings.Timezone = tz })
+}
+
+// SetLocale validates and stores the user's locale. An empty locale
+// restores the default.
+func (s *UserSettingsStore) SetLocale(userID, locale string) error {
+	if locale != "" {
+		normalized, err := normalizeLocale(locale)
+		if err != nil {
+			return i18n.NewError("locale.unknown", i18n.Vars{"locale": locale})
+		}
+		locale = normalized
+	}
+	return s.update(userID, func(settings *UserSettings) { settings.Locale = locale })
+}
+
+// Forget deletes the user's settings.
+func (s *UserSettingsStore) Forget(userID string) (int, error) {
+	removed := 0
+	err := s.update(userID, func(settings *UserSettings) {
+		if *settings != (UserSettings{}) {
+			removed = 1
+		}
+		*settings = UserSettings{}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+func (s *UserSettingsStore) update(userID string, fn func(*UserSettings)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.loaded {
+		return i18n.NewError("usersettings.unavailable", nil)
+	}
+
+	previous, existed := s.users[userID]
+	settings := previous
+	fn(&settings)
+	if settings == (UserSettings{}) {
+		delete(s.users, userID)
+	} else {
+		s.users[userID] = settings
+	}
+	if err := s.saveLocked(); err != nil {
+		if existed {
+			s.users[userID] = previous
+		} else {
+			delete(s.users, userID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *UserSettingsStore) saveLocked() error {
+	content := userSettingsContent{Users: s.users}
+	if data, err := json.Marshal(content); err == nil && len(data) > userSettingsWarnSize {
+		log.Printf("WARNING: User settings take %d bytes of account data, homeservers may refuse more than 64 KiB", len(data))
+	}
+	if err := s.matrixService.SetAccountData(userSettingsEventType, content); err != nil {
+		return fmt.Errorf("failed to save user settings: %v", err)
+	}
+	return nil
+}
+
+func (h *MessageHandler) cmdTimezone(ctx context.Context, inv *Invocation) (string, error) {
+	if !inv.Has("zone") {
+		loc := h.users.Location(inv.SenderID)
+		return inv.Text("timezone.show", i18n.Vars{
+			"zone": loc.String(),
+			"time": time.Now().In(loc).Format("15:04"),
+		}), nil
+	}
+
+	zone := inv.String("zone")
+	if zone == "default" {
+		zone = ""
+	}
+	if err := h.users.SetTimezone(inv.SenderID, zone); err != nil {
+		return inv.Text("timezone.invalid", i18n.Vars{"error": i18n.ErrorText(inv.Lang, err)}), nil
+	}
+	return inv.Text("timezone.set", i18n.Vars{"zone": h.users.Location(inv.SenderID).String()}), nil
+}
+
+func (h *MessageHandler) cmdLocale(ctx context.Context, inv *Invocation) (string, error) {
+	if !inv.Has("locale") {
+		return inv.Text("locale.show", i18n.Vars{
+			"locale":  h.users.Locale(inv.SenderID),
+			"example": h.users.Format(inv.SenderID, time.Now()),
+		}), nil
+	}
+
+	locale := inv.String("locale")
+	if locale == "default" {
+		locale = ""
+	}
+	if err := h.users.SetLocale(inv.SenderID, locale); err != nil {
+		return inv.Text("locale.invalid", i18n.Vars{
+			"error":   i18n.ErrorText(inv.Lang, err),
+			"locales": strings.Join(supportedLocales, ", "),
+		}), nil
+	}
+	inv.Lang = h.language(inv.RoomID, inv.SenderID)
+	return inv.Text("locale.set", i18n.Vars{"locale": h.users.Locale(inv.SenderID)}), nil
+}
@@ -0,0 +1,155 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/huhndev/gohenry/i18n"
+)
+
+// failingMatrix fails to save account data while fail is set.
+type failingMatrix struct {
+	*fakeMatrix
+	fail bool
+}
+
+func (m *failingMatrix) SetAccountData(eventType string, v interface{}) error {
+	if m.fail {
+		return errors.New("homeserver unavailable")
+	}
+	return m.fakeMatrix.SetAccountData(eventType, v)
+}
+
+func newTestUserSettings(t *testing.T, dir string) (*UserSettingsStore, *failingMatrix) {
+	matrix := &failingMatrix{fakeMatrix: &fakeMatrix{accountData: make(map[string][]byte)}}
+	return NewUserSettingsStore(matrix, dir, "UTC", "en"), matrix
+}
+
+func TestUserSettingsRefusedBeforeLoad(t *testing.T) {
+	s, matrix := newTestUserSettings(t, t.TempDir())
+
+	err := s.SetTimezone(alice, "Europe/Berlin")
+	if i18n.ErrorText("en", err) != i18n.Text("en", "usersettings.unavailable", nil) {
+		t.Fatalf("SetTimezone before Load = %v", err)
+	}
+	if _, err := s.Forget(alice); err == nil {
+		t.Error("Forget before Load succeeded")
+	}
+	if len(matrix.accountData) != 0 {
+		t.Errorf("account data written before Load: %s", matrix.accountData[userSettingsEventType])
+	}
+	if got := s.Get(alice); got.Timezone != "UTC" || got.Locale != "en" {
+		t.Errorf("settings before Load = %+v, want the defaults", got)
+	}
+
+	s.Load(context.Background())
+	if err := s.SetTimezone(alice, "Europe/Berlin"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserSettingsRollBackFailedSave(t *testing.T) {
+	s, matrix := newTestUserSettings(t, t.TempDir())
+	s.Load(context.Background())
+	if err := s.SetTimezone(alice, "Europe/Berlin"); err != nil {
+		t.Fatal(err)
+	}
+
+	matrix.fail = true
+	if err := s.SetTimezone(alice, "Asia/Tokyo"); err == nil {
+		t.Error("SetTimezone succeeded without saving")
+	}
+	if err := s.SetLocale(bob, "de"); err == nil {
+		t.Error("SetLocale succeeded without saving")
+	}
+	if _, err := s.Forget(alice); err == nil {
+		t.Error("Forget succeeded without saving")
+	}
+
+	if got := s.Get(alice).Timezone; got != "Europe/Berlin" {
+		t.Errorf("alice's time zone = %s after failed changes, want Europe/Berlin", got)
+	}
+	if got := s.OwnLocale(bob); got != "" {
+		t.Errorf("bob's failed locale %q was kept", got)
+	}
+
+	matrix.fail = false
+	if err := s.SetLocale(bob, "de"); err != nil {
+		t.Fatal(err)
+	}
+	var saved userSettingsContent
+	if err := json.Unmarshal(matrix.accountData[userSettingsEventType], &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Users[alice].Timezone != "Europe/Berlin" || saved.Users[bob].Locale != "de" {
+		t.Errorf("saved settings = %+v", saved.Users)
+	}
+}
+
+func TestUserSettingsMigrateLegacyFile(t *testing.T) {
+	dir := t.TempDir()
+	legacy := `{"` + alice + `": {"timezone": "Europe/Berlin"}, "` + bob + `": {"locale": "de"}}`
+	legacyPath := filepath.Join(dir, "user_settings.json")
+	if err := ioutil.WriteFile(legacyPath, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, matrix := newTestUserSettings(t, dir)
+	// Bob has changed his settings since, in account data.
+	if err := matrix.SetAccountData(userSettingsEventType, userSettingsContent{
+		Users: map[string]UserSettings{bob: {Timezone: "America/New_York"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed save keeps the file for the next start.
+	matrix.fail = true
+	s.Load(context.Background())
+	if _, err := os.Stat(legacyPath); err != nil {
+		t.Fatalf("legacy file removed although saving failed: %v", err)
+	}
+
+	matrix.fail = false
+	s.Load(context.Background())
+	if got := s.Get(alice); got.Timezone != "Europe/Berlin" {
+		t.Errorf("alice's settings weren't migrated: %+v", got)
+	}
+	if got := s.Get(bob); got.Timezone != "America/New_York" || got.Locale != "en" {
+		t.Errorf("account data of bob was overwritten: %+v", got)
+	}
+	if _, err := os.Stat(legacyPath); !os.IsNotExist(err) {
+		t.Errorf("legacy file not removed: %v", err)
+	}
+	var saved userSettingsContent
+	if err := json.Unmarshal(matrix.accountData[userSettingsEventType], &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Users[alice].Timezone != "Europe/Berlin" {
+		t.Errorf("migrated settings not saved: %+v", saved.Users)
+	}
+}
+
+func TestSetTimezoneValidates(t *testing.T) {
+	s, _ := newTestUserSettings(t, t.TempDir())
+	s.Load(context.Background())
+
+	for _, zone := range []string{"Local", "Mars/Olympus", "+02:00"} {
+		if err := s.SetTimezone(alice, zone); err == nil {
+			t.Errorf("SetTimezone(%q) succeeded", zone)
+		}
+	}
+	if err := s.SetTimezone(alice, "Europe/Berlin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetTimezone(alice, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Get(alice).Timezone; got != "UTC" {
+		t.Errorf("time zone after reset = %s, want the default", got)
+	}
+}
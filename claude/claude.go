@@ -11,17 +11,21 @@ import (
 	"time"
 
 	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/redact"
 )
 
 const (
-	apiURL    = "https://api.anthropic.com/v1/messages"
-	apiModel  = "claude-3-7-sonnet-20250219"
-	maxTokens = 1024
+	apiURL           = "https://api.anthropic.com/v1/messages"
+	defaultMaxTokens = 1024
 )
 
+// DefaultModel is used when HENRY_CLAUDE_MODEL is not set
+const DefaultModel = "claude-3-7-sonnet-20250219"
+
+// message content is either a string or a list of content blocks.
 type message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
 }
 
 type request struct {
@@ -30,32 +34,51 @@ type request struct {
 	MaxTokens   int       `json:"max_tokens"`
 	Temperature float64   `json:"temperature"`
 	System      string    `json:"system"`
+	Tools       []toolDef `json:"tools,omitempty"`
+}
+
+type contentBlock struct {
+	Type  string          `json:"type"`
+	Text  string          `json:"text,omitempty"`
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
 }
 
 type response struct {
-	ID      string `json:"id"`
-	Type    string `json:"type"`
-	Role    string `json:"role"`
-	Content []struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
-	} `json:"content"`
-	StopReason string `json:"stop_reason"`
-	Model      string `json:"model"`
+	ID         string         `json:"id"`
+	Type       string         `json:"type"`
+	Role       string         `json:"role"`
+	Content    []contentBlock `json:"content"`
+	StopReason string         `json:"stop_reason"`
+	Model      string         `json:"model"`
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
 }
 
 // Service implements the AIService interface using Claude
 type Service struct {
 	apiKey     string
+	model      string
 	httpClient *http.Client
+	redactor   *redact.Redactor
 }
 
-func NewService(apiKey string) (*Service, error) {
+// NewService creates the Claude client. Everything sent to Claude first
+// goes through redactor, which may be nil.
+func NewService(apiKey string, model string, redactor *redact.Redactor) (*Service, error) {
 	if apiKey == "" {
 		return nil, fmt.Errorf("Claude API key is required")
 	}
+	if model == "" {
+		model = DefaultModel
+	}
 	return &Service{
-		apiKey: apiKey,
+		apiKey:   apiKey,
+		model:    model,
+		redactor: redactor,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
@@ -89,8 +112,9 @@ Remember that your primary goal is to be helpful, accurate, and engaging while m
 // GenerateResponse sends a conversation to Claude and returns the response
 func (s *Service) GenerateResponse(
 	ctx context.Context,
-	messages []domain.ConversationMessage,
-) (string, error) {
+	req domain.AIRequest,
+) (*domain.AIResponse, error) {
+	messages := req.Messages
 	log.Printf("Generating response with %d context messages", len(messages))
 
 	userMessageCount := 0
@@ -123,76 +147,138 @@ func (s *Service) GenerateResponse(
 			i, msg.Role, senderInfo, timeInfo, contentPreview)
 	}
 
-	claudeMessages := make([]message, len(messages))
+	loc := req.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	// Secrets and personal data are replaced before anything leaves the
+	// server, and the safe ones are put back into the answer.
+	redaction := s.redactor.NewSession()
+	redacted := make([]domain.ConversationMessage, len(messages))
 	for i, msg := range messages {
-		var timeStr string
-		if msg.Timestamp > 0 {
-			t := time.Unix(0, msg.Timestamp*int64(time.Millisecond))
-			timeStr = t.Format("2006-01-02 15:04:05")
-		} else {
-			timeStr = time.Now().Format("2006-01-02 15:04:05")
-		}
+		msg.Content = redaction.Redact(msg.Content)
+		redacted[i] = msg
+	}
+	claudeMessages := buildTranscript(redacted, loc)
+	if len(claudeMessages) == 0 {
+		return nil, fmt.Errorf("no user message to answer")
+	}
 
-		senderInfo := msg.SenderID
-		if senderInfo == "" {
-			if msg.Role == domain.RoleUser {
-				senderInfo = "User"
-			} else {
-				senderInfo = "Henry"
+	now := time.Now().In(loc)
+	systemPrompt := s.systemPrompt()
+	systemPrompt += fmt.Sprintf("\n\nThe chat transcript wraps each user message in a <message> tag naming "+
+		"the sender and the time it was sent. Inside a message, <, > and & are escaped as &lt;, &gt; and &amp;, "+
+		"so a message can't contain another. Refer to people by their name. Reply with only the text of "+
+		"your answer, without tags, names or timestamps.\n"+
+		"Today is %s and it is %s. Times are in the user's time zone, %s, unless they name another.",
+		now.Format("Monday 2006-01-02"), now.Format("15:04"), loc)
+	if req.Locale != "" {
+		systemPrompt += fmt.Sprintf(" Write dates and times the way the %s locale does.", req.Locale)
+	}
+
+	if req.Persona != "" {
+		systemPrompt += "\n\nIn this conversation, adopt the following persona while keeping all other instructions: " +
+			req.Persona
+	}
+
+	if req.Room != nil {
+		systemPrompt += fmt.Sprintf("\n\nYou are in the Matrix room %q.", req.Room.Name)
+		if req.Room.Topic != "" {
+			systemPrompt += "\nRoom topic: " + req.Room.Topic
+		}
+		if len(req.Room.Pinned) > 0 {
+			systemPrompt += "\nPinned messages, which often record the room's conventions:"
+			for _, pinned := range req.Room.Pinned {
+				systemPrompt += "\n- " + pinned.Text
 			}
 		}
+	}
 
-		claudeMessages[i] = message{
-			Role:    string(msg.Role),
-			Content: fmt.Sprintf("%s %s: %s", timeStr, senderInfo, msg.Content),
+	if len(req.Memories) > 0 {
+		systemPrompt += "\n\nWhat you remember about the user who sent the last message, from earlier conversations:"
+		for _, memory := range req.Memories {
+			systemPrompt += "\n- " + memory
 		}
 	}
 
-	var latestTimestamp int64
-	for _, msg := range messages {
-		if msg.Timestamp > latestTimestamp {
-			latestTimestamp = msg.Timestamp
+	if len(req.Excerpts) > 0 {
+		systemPrompt += "\n\nExcerpts from this room's knowledge base that may help with the last message. " +
+			"Use them if they're relevant and cite the source in brackets, e.g. [deploy.md § Rollback]. " +
+			"Don't mention excerpts that don't help."
+		for _, excerpt := range req.Excerpts {
+			systemPrompt += fmt.Sprintf("\n\n[%s]\n%s", excerpt.Source, excerpt.Text)
 		}
 	}
 
-	var currentDate, currentTime string
-	if latestTimestamp > 0 {
-		t := time.Unix(0, latestTimestamp*int64(time.Millisecond))
-		currentDate = t.Format("2006-01-02")
-		currentTime = t.Format("15:04")
-	} else {
-		now := time.Now()
-		currentDate = now.Format("2006-01-02")
-		currentTime = now.Format("15:04")
+	if req.Instructions != "" {
+		systemPrompt += "\n\n" + req.Instructions
 	}
 
-	systemPrompt := s.systemPrompt()
-	systemPrompt += fmt.Sprintf("\n\nCRITICAL INSTRUCTION:\n"+
-		"1. NEVER include timestamp or username prefixes in your responses. NEVER respond in the format '2025-03-21 10:42:05 @henry:henhouse.im: [content]'. Always respond with ONLY the message content.\n"+
-		"2. If asked about the current date, use: %s\n"+
-		"3. If asked about the current time, use: %s (without seconds)",
-		currentDate, currentTime)
+	model := req.Model
+	if model == "" {
+		model = s.model
+	}
 
-	return s.sendRequest(claudeMessages, systemPrompt)
-}
+	maxTokens := req.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = defaultMaxTokens
+	}
 
-func (s *Service) sendRequest(messages []message, systemPrompt string) (string, error) {
 	reqBody := request{
-		Model:       apiModel,
-		Messages:    messages,
+		Model:       model,
+		Messages:    claudeMessages,
 		MaxTokens:   maxTokens,
 		Temperature: 0.7,
-		System:      systemPrompt,
+		System:      redaction.Redact(systemPrompt),
+		Tools:       toolDefs(req.Tools),
 	}
 
+	// Logged on every return, so failed requests are accounted for too.
+	defer func() {
+		if summary := redaction.Summary(); summary != "" {
+			log.Printf("Redacted before sending to Claude: %s", summary)
+		}
+	}()
+
+	result := &domain.AIResponse{}
+	for round := 0; ; round++ {
+		claudeResp, err := s.sendRequest(ctx, reqBody)
+		if err != nil {
+			return nil, err
+		}
+		result.Model = claudeResp.Model
+		result.InputTokens += claudeResp.Usage.InputTokens
+		result.OutputTokens += claudeResp.Usage.OutputTokens
+
+		if claudeResp.StopReason != "tool_use" || round >= maxToolRounds {
+			for _, content := range claudeResp.Content {
+				if content.Type == "text" {
+					result.Content += content.Text
+				}
+			}
+			if result.Content == "" {
+				return nil, fmt.Errorf("empty response from Claude")
+			}
+			result.Content = redaction.Restore(result.Content)
+			return result, nil
+		}
+
+		reqBody.Messages = append(reqBody.Messages,
+			message{Role: string(domain.RoleAssistant), Content: claudeResp.Content},
+			message{Role: string(domain.RoleUser), Content: runTools(ctx, req.Tools, claudeResp.Content, redaction)},
+		)
+	}
+}
+
+func (s *Service) sendRequest(ctx context.Context, reqBody request) (*response, error) {
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %v", err)
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(jsonBody))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -201,34 +287,27 @@ func (s *Service) sendRequest(messages []message, systemPrompt string) (string,
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
+		return nil, fmt.Errorf("failed to send request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %v", err)
+		return nil, fmt.Errorf("failed to read response: %v", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API error: %s (status %d)", string(body), resp.StatusCode)
+		return nil, fmt.Errorf("API error: %s (status %d)", string(body), resp.StatusCode)
 	}
 
 	var claudeResp response
 	if err := json.Unmarshal(body, &claudeResp); err != nil {
-		return "", fmt.Errorf("failed to parse response: %v", err)
+		return nil, fmt.Errorf("failed to parse response: %v", err)
 	}
 
 	if len(claudeResp.Content) == 0 {
-		return "", fmt.Errorf("empty response from Claude")
-	}
-
-	var responseText string
-	for _, content := range claudeResp.Content {
-		if content.Type == "text" {
-			responseText += content.Text
-		}
+		return nil, fmt.Errorf("empty response from Claude")
 	}
 
-	return responseText, nil
+	return &claudeResp, nil
 }
@@ -0,0 +1,117 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/redact"
+)
+
+// maxToolRounds caps how many times Claude may call tools for one answer.
+const maxToolRounds = 5
+
+type toolDef struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+type toolResult struct {
+	Type      string `json:"type"`
+	ToolUseID string `json:"tool_use_id"`
+	Content   string `json:"content"`
+	IsError   bool   `json:"is_error,omitempty"`
+}
+
+func toolDefs(tools []domain.Tool) []toolDef {
+	defs := make([]toolDef, 0, len(tools))
+	for _, tool := range tools {
+		defs = append(defs, toolDef{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: tool.InputSchema,
+		})
+	}
+	return defs
+}
+
+// runTools executes the tool calls in a response and returns their results
+// as content blocks for the next request. Tools get the real values behind
+// the placeholders Claude passes, and their output is redacted like the
+// rest of the context.
+func runTools(
+	ctx context.Context,
+	tools []domain.Tool,
+	content []contentBlock,
+	redaction *redact.Session,
+) []toolResult {
+	var results []toolResult
+	for _, block := range content {
+		if block.Type != "tool_use" {
+			continue
+		}
+
+		result := toolResult{Type: "tool_result", ToolUseID: block.ID}
+		tool := findTool(tools, block.Name)
+		if tool == nil {
+			result.Content = fmt.Sprintf("unknown tool %q", block.Name)
+			result.IsError = true
+			results = append(results, result)
+			continue
+		}
+
+		log.Printf("Claude called tool %s with %s", block.Name, string(block.Input))
+		output, err := tool.Run(ctx, restoreInput(block.Input, redaction))
+		if err != nil {
+			log.Printf("Tool %s failed: %v", block.Name, err)
+			result.Content = redaction.Redact(err.Error())
+			result.IsError = true
+		} else {
+			result.Content = redaction.Redact(output)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func findTool(tools []domain.Tool, name string) *domain.Tool {
+	for i := range tools {
+		if tools[i].Name == name {
+			return &tools[i]
+		}
+	}
+	return nil
+}
+
+// restoreInput restores placeholders in the string values of a tool call's
+// JSON input.
+func restoreInput(input json.RawMessage, redaction *redact.Session) json.RawMessage {
+	var value interface{}
+	if err := json.Unmarshal(input, &value); err != nil {
+		return input
+	}
+	restored, err := json.Marshal(restoreValue(value, redaction))
+	if err != nil {
+		return input
+	}
+	return restored
+}
+
+func restoreValue(value interface{}, redaction *redact.Session) interface{} {
+	switch v := value.(type) {
+	case string:
+		return redaction.Restore(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = restoreValue(v[i], redaction)
+		}
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = restoreValue(v[key], redaction)
+		}
+	}
+	return value
+}
@@ -0,0 +1,87 @@
+package claude
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/huhndev/gohenry/domain"
+)
+
+// messageEscaper escapes user content, so a message can't close its own
+// tag and forge one from another participant.
+var messageEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// buildTranscript turns the conversation into Messages API turns. User
+// messages are wrapped in <message> tags naming the sender, so Claude can
+// tell participants apart; Henry's own messages stay bare, so there's no
+// prefix format to copy. Consecutive messages of one role are merged, as
+// the API needs alternating turns starting with the user. Times are
+// shown in loc.
+func buildTranscript(messages []domain.ConversationMessage, loc *time.Location) []message {
+	names := participantNames(messages)
+
+	var turns []message
+	var current strings.Builder
+	role := ""
+	flush := func() {
+		if role != "" && current.Len() > 0 {
+			turns = append(turns, message{Role: role, Content: current.String()})
+		}
+		current.Reset()
+	}
+
+	for _, msg := range messages {
+		if string(msg.Role) != role {
+			flush()
+			role = string(msg.Role)
+		}
+		if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+		if msg.Role == domain.RoleAssistant {
+			current.WriteString(msg.Content)
+			continue
+		}
+
+		timestamp := time.Now()
+		if msg.Timestamp > 0 {
+			timestamp = time.Unix(0, msg.Timestamp*int64(time.Millisecond))
+		}
+		fmt.Fprintf(&current, "<message from=%q time=%q>\n%s\n</message>",
+			names[msg.SenderID], timestamp.In(loc).Format("2006-01-02 15:04"), messageEscaper.Replace(msg.Content))
+	}
+	flush()
+
+	for len(turns) > 0 && turns[0].Role != string(domain.RoleUser) {
+		turns = turns[1:]
+	}
+	return turns
+}
+
+// participantNames picks the name shown for each sender: the display
+// name, with the user ID added where two senders share a name.
+func participantNames(messages []domain.ConversationMessage) map[string]string {
+	names := make(map[string]string)
+	senders := make(map[string]map[string]bool)
+	for _, msg := range messages {
+		name := msg.SenderName
+		if name == "" {
+			name = msg.SenderID
+		}
+		if name == "" {
+			name = "User"
+		}
+		names[msg.SenderID] = name
+		if senders[name] == nil {
+			senders[name] = make(map[string]bool)
+		}
+		senders[name][msg.SenderID] = true
+	}
+	for id, name := range names {
+		if len(senders[name]) > 1 && name != id {
+			names[id] = fmt.Sprintf("%s (%s)", name, id)
+		}
+	}
+	return names
+}
@@ -5,30 +5,89 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/huhndev/gohenry/claude"
 )
 
 type Config struct {
-	MatrixHomeserver    string
-	MatrixUserID        string
-	MatrixAccessToken   string
-	MatrixPassword      string
-	SyncTokenFile       string
-	ClaudeAPIKey        string
-	ContextMessageCount int
-	AllowedDomain       string
-	OwnerID             string
+	MatrixHomeserver        string
+	MatrixUserID            string
+	MatrixAccessToken       string
+	MatrixPassword          string
+	SyncTokenFile           string
+	SessionFile             string
+	FilterFile              string
+	DataDir                 string
+	ClaudeAPIKey            string
+	ContextMessageCount     int
+	AllowedDomain           string
+	OwnerID                 string
+	MaxMessageLength        int
+	AttachmentThreshold     int
+	CodeAttachmentThreshold int
+	AppServiceRegistration  string
+	AppServiceListen        string
+	AppServiceURL           string
+	InvitePolicy            string
+	InviteAllowedUsers      []string
+	InviteAllowedServers    []string
+	InviteAllowedRooms      []string
+	AccessPolicyFile        string
+	AccessDeniedReply       string
+	CommandPrefix           string
+	ClaudeModel             string
+	ClaudeModels            []string
+	SummaryMaxMessages      int
+	DigestAwayMinutes       int
+	DigestMinMessages       int
+	Timezone                string
+	Locale                  string
+	StandupFile             string
+	KnowledgeDir            string
+	SearchMaxMessages       int
+	SearchBackfill          int
+	AllowRoomMentions       bool
+	MaxMentions             int
+	ConsentRequired         bool
+	ConsentNoticeFile       string
+	Redact                  []string
+	RedactFile              string
 }
 
 func LoadConfig() (*Config, error) {
 	config := &Config{
-		MatrixHomeserver:  os.Getenv("HENRY_MATRIX_HOMESERVER"),
-		MatrixUserID:      os.Getenv("HENRY_MATRIX_USER_ID"),
-		MatrixAccessToken: os.Getenv("HENRY_MATRIX_ACCESS_TOKEN"),
-		MatrixPassword:    os.Getenv("HENRY_MATRIX_PASSWORD"),
-		ClaudeAPIKey:      os.Getenv("HENRY_CLAUDE_API_KEY"),
-		OwnerID:           os.Getenv("HENRY_OWNER_ID"),
-		AllowedDomain:     "henhouse.im",
-		SyncTokenFile:     os.Getenv("HENRY_SYNC_TOKEN_FILE"),
+		MatrixHomeserver:       os.Getenv("HENRY_MATRIX_HOMESERVER"),
+		MatrixUserID:           os.Getenv("HENRY_MATRIX_USER_ID"),
+		MatrixAccessToken:      os.Getenv("HENRY_MATRIX_ACCESS_TOKEN"),
+		MatrixPassword:         os.Getenv("HENRY_MATRIX_PASSWORD"),
+		ClaudeAPIKey:           os.Getenv("HENRY_CLAUDE_API_KEY"),
+		OwnerID:                os.Getenv("HENRY_OWNER_ID"),
+		AllowedDomain:          "henhouse.im",
+		SyncTokenFile:          os.Getenv("HENRY_SYNC_TOKEN_FILE"),
+		SessionFile:            os.Getenv("HENRY_SESSION_FILE"),
+		FilterFile:             os.Getenv("HENRY_FILTER_FILE"),
+		DataDir:                os.Getenv("HENRY_DATA_DIR"),
+		AppServiceRegistration: os.Getenv("HENRY_APPSERVICE_REGISTRATION"),
+		AppServiceListen:       os.Getenv("HENRY_APPSERVICE_LISTEN"),
+		AppServiceURL:          os.Getenv("HENRY_APPSERVICE_URL"),
+		InvitePolicy:           os.Getenv("HENRY_INVITE_POLICY"),
+		InviteAllowedUsers:     listFromEnv("HENRY_INVITE_ALLOWED_USERS"),
+		InviteAllowedServers:   listFromEnv("HENRY_INVITE_ALLOWED_SERVERS"),
+		InviteAllowedRooms:     listFromEnv("HENRY_INVITE_ALLOWED_ROOMS"),
+		AccessPolicyFile:       os.Getenv("HENRY_ACCESS_POLICY_FILE"),
+		AccessDeniedReply:      os.Getenv("HENRY_ACCESS_DENIED_REPLY"),
+		CommandPrefix:          os.Getenv("HENRY_COMMAND_PREFIX"),
+		ClaudeModel:            os.Getenv("HENRY_CLAUDE_MODEL"),
+		ClaudeModels:           listFromEnv("HENRY_CLAUDE_MODELS"),
+		Timezone:               os.Getenv("HENRY_TIMEZONE"),
+		Locale:                 os.Getenv("HENRY_LOCALE"),
+		StandupFile:            os.Getenv("HENRY_STANDUP_FILE"),
+		KnowledgeDir:           os.Getenv("HENRY_KNOWLEDGE_DIR"),
+		ConsentNoticeFile:      os.Getenv("HENRY_CONSENT_NOTICE_FILE"),
+		Redact:                 listFromEnv("HENRY_REDACT"),
+		RedactFile:             os.Getenv("HENRY_REDACT_FILE"),
 	}
 
 	if config.MatrixHomeserver == "" {
@@ -37,22 +96,52 @@ func LoadConfig() (*Config, error) {
 	if config.MatrixUserID == "" {
 		return nil, errors.New("HENRY_MATRIX_USER_ID environment variable must be set")
 	}
-	if config.MatrixAccessToken == "" && config.MatrixPassword == "" {
-		return nil, errors.New("either HENRY_MATRIX_ACCESS_TOKEN or HENRY_MATRIX_PASSWORD must be set")
+	if config.MatrixAccessToken == "" && config.MatrixPassword == "" &&
+		config.AppServiceRegistration == "" {
+		return nil, errors.New(
+			"one of HENRY_MATRIX_ACCESS_TOKEN, HENRY_MATRIX_PASSWORD or HENRY_APPSERVICE_REGISTRATION must be set",
+		)
 	}
 	if config.ClaudeAPIKey == "" {
 		return nil, errors.New("HENRY_CLAUDE_API_KEY environment variable must be set")
 	}
 
-	contextCountStr := os.Getenv("HENRY_CONTEXT_MESSAGE_COUNT")
-	if contextCountStr == "" {
-		config.ContextMessageCount = 10
-	} else {
-		contextCount, err := strconv.Atoi(contextCountStr)
-		if err != nil {
-			return nil, fmt.Errorf("invalid HENRY_CONTEXT_MESSAGE_COUNT: %v", err)
-		}
-		config.ContextMessageCount = contextCount
+	var err error
+	if config.ContextMessageCount, err = intFromEnv("HENRY_CONTEXT_MESSAGE_COUNT", 10); err != nil {
+		return nil, err
+	}
+	if config.MaxMessageLength, err = intFromEnv("HENRY_MAX_MESSAGE_LENGTH", 4000); err != nil {
+		return nil, err
+	}
+	if config.AttachmentThreshold, err = intFromEnv("HENRY_ATTACHMENT_THRESHOLD", 16000); err != nil {
+		return nil, err
+	}
+	if config.CodeAttachmentThreshold, err = intFromEnv("HENRY_CODE_ATTACHMENT_THRESHOLD", 0); err != nil {
+		return nil, err
+	}
+	if config.SummaryMaxMessages, err = intFromEnv("HENRY_SUMMARY_MAX_MESSAGES", 1000); err != nil {
+		return nil, err
+	}
+	if config.DigestAwayMinutes, err = intFromEnv("HENRY_DIGEST_AWAY_MINUTES", 240); err != nil {
+		return nil, err
+	}
+	if config.DigestMinMessages, err = intFromEnv("HENRY_DIGEST_MIN_MESSAGES", 20); err != nil {
+		return nil, err
+	}
+	if config.SearchMaxMessages, err = intFromEnv("HENRY_SEARCH_MAX_MESSAGES", 20000); err != nil {
+		return nil, err
+	}
+	if config.SearchBackfill, err = intFromEnv("HENRY_SEARCH_BACKFILL", 2000); err != nil {
+		return nil, err
+	}
+	if config.AllowRoomMentions, err = boolFromEnv("HENRY_ALLOW_ROOM_MENTIONS", false); err != nil {
+		return nil, err
+	}
+	if config.MaxMentions, err = intFromEnv("HENRY_MAX_MENTIONS", 10); err != nil {
+		return nil, err
+	}
+	if config.ConsentRequired, err = boolFromEnv("HENRY_CONSENT_REQUIRED", true); err != nil {
+		return nil, err
 	}
 
 	if allowedDomain := os.Getenv("HENRY_ALLOWED_DOMAIN"); allowedDomain != "" {
@@ -63,5 +152,94 @@ func LoadConfig() (*Config, error) {
 		config.SyncTokenFile = "sync_token.txt"
 	}
 
+	if config.SessionFile == "" {
+		config.SessionFile = "session.json"
+	}
+
+	if config.FilterFile == "" {
+		config.FilterFile = "filter.json"
+	}
+
+	if config.AppServiceListen == "" {
+		config.AppServiceListen = ":8009"
+	}
+
+	if config.AppServiceURL == "" {
+		config.AppServiceURL = "http://localhost:8009"
+	}
+
+	switch config.InvitePolicy {
+	case "":
+		config.InvitePolicy = "allowlist"
+	case "open", "allowlist", "ask":
+	default:
+		return nil, fmt.Errorf("invalid HENRY_INVITE_POLICY %q: must be open, allowlist or ask", config.InvitePolicy)
+	}
+
+	if len(config.InviteAllowedServers) == 0 {
+		config.InviteAllowedServers = []string{config.AllowedDomain}
+	}
+
+	if config.DataDir == "" {
+		config.DataDir = "data"
+	}
+
+	if config.Timezone == "" {
+		config.Timezone = "Local"
+	}
+	if _, err := time.LoadLocation(config.Timezone); err != nil {
+		return nil, fmt.Errorf("invalid HENRY_TIMEZONE %q: %v", config.Timezone, err)
+	}
+
+	if config.Locale == "" {
+		config.Locale = "en"
+	}
+
+	if config.CommandPrefix == "" {
+		config.CommandPrefix = "!henry"
+	}
+
+	if config.ClaudeModel == "" {
+		config.ClaudeModel = claude.DefaultModel
+	}
+
+	if len(config.ClaudeModels) == 0 {
+		config.ClaudeModels = []string{config.ClaudeModel}
+	}
+
 	return config, nil
 }
+
+func intFromEnv(name string, defaultValue int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return n, nil
+}
+
+func boolFromEnv(name string, defaultValue bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return b, nil
+}
+
+func listFromEnv(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -2,10 +2,18 @@ package domain
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"time"
 
 	"maunium.net/go/mautrix/event"
 )
 
+// ErrStillQueued is returned by a send that timed out waiting for the
+// homeserver. The message stays queued and goes out once the homeserver
+// is reachable, so it must not be sent again.
+var ErrStillQueued = errors.New("homeserver unreachable, the message is still queued and will be sent later")
+
 // Message represents a chat message in the system
 type Message struct {
 	ID        string
@@ -14,6 +22,20 @@ type Message struct {
 	Content   string
 	Timestamp int64
 	IsFromBot bool
+	// ThreadID is the root event of the message's thread, if any
+	ThreadID string
+	// SenderName is the sender's display name, where known
+	SenderName string
+	// Attachment is the file sent with an m.file message
+	Attachment *Attachment
+}
+
+// Attachment is a file sent to a room. Content holds its caption, if any.
+type Attachment struct {
+	Name     string
+	MimeType string
+	URL      string
+	Size     int
 }
 
 type MessageRole string
@@ -29,6 +51,8 @@ type ConversationMessage struct {
 	Content   string
 	Timestamp int64
 	SenderID  string
+	// SenderName is the sender's display name, where known
+	SenderName string
 }
 
 type RoomType string
@@ -46,26 +70,112 @@ type Room struct {
 	Members []string
 }
 
+// RoomInfo describes the room a conversation happens in
+type RoomInfo struct {
+	Name  string
+	Topic string
+	// TopicSenderID is who set the topic
+	TopicSenderID string
+	// Pinned holds the room's pinned messages
+	Pinned []PinnedMessage
+}
+
+// PinnedMessage is the text of a pinned message and who sent it
+type PinnedMessage struct {
+	SenderID string
+	Text     string
+}
+
+// Excerpt is a passage from a document, with the source to cite
+type Excerpt struct {
+	Source string
+	Text   string
+}
+
+// AIRequest is a conversation to be answered, with per-room overrides
+type AIRequest struct {
+	Messages []ConversationMessage
+	// Model overrides the configured default model when set
+	Model string
+	// Persona is an extra personality instruction for the system prompt
+	Persona string
+	// Instructions describe a specific task, such as summarizing, and are
+	// added to the system prompt
+	Instructions string
+	// Memories are facts remembered about the user being answered
+	Memories []string
+	// Room is the room being answered in; Topic and Pinned are empty
+	// if the room turned room context off
+	Room *RoomInfo
+	// Location and Locale are the time zone and date style of the user
+	// being answered; the server's zone is used if Location is nil
+	Location *time.Location
+	Locale   string
+	// Excerpts are knowledge base passages that may answer the question
+	Excerpts []Excerpt
+	// MaxTokens overrides the default answer length when set
+	MaxTokens int
+	// Tools are functions Claude may call while answering
+	Tools []Tool
+}
+
+// Tool is a function the AI can call. Run gets the JSON arguments matching
+// InputSchema and returns a text result for the AI.
+type Tool struct {
+	Name        string
+	Description string
+	InputSchema map[string]interface{}
+	Run         func(ctx context.Context, input json.RawMessage) (string, error)
+}
+
+// AIResponse is the generated answer and what it cost
+type AIResponse struct {
+	Content      string
+	Model        string
+	InputTokens  int
+	OutputTokens int
+}
+
 // AIService defines the interface for AI (Claude) interactions
 type AIService interface {
-	GenerateResponse(ctx context.Context, messages []ConversationMessage) (string, error)
+	GenerateResponse(ctx context.Context, req AIRequest) (*AIResponse, error)
 }
 
 // MatrixService defines the interface for Matrix interactions
 type MatrixService interface {
 	Connect(ctx context.Context) error
 	Disconnect() error
+	Logout() error
 	SetMessageHandler(handler func(ctx context.Context, evt *event.Event))
+	SetInviteHandler(handler func(ctx context.Context, roomID, inviterID string))
+	SetReactionHandler(handler func(ctx context.Context, roomID, senderID, eventID, key string))
+	SetReceiptHandler(handler func(ctx context.Context, roomID, userID, eventID string, ts int64))
+	SetRedactionHandler(handler func(ctx context.Context, roomID, eventID string))
 	ListenForMessages(ctx context.Context) error
 	JoinRoom(roomID string) error
+	RejectInvite(roomID string) error
 	SendMessage(roomID string, content string) error
+	SendThreadMessage(roomID, threadID string, content string) error
 	GetRoomContext(ctx context.Context, roomID string, limit int) ([]*Message, error)
+	GetRoomHistory(ctx context.Context, roomID, threadID string, since int64, limit int) ([]*Message, error)
+	Permalink(roomID, eventID string) string
+	DownloadMedia(ctx context.Context, url string) ([]byte, error)
+	SearchMessages(ctx context.Context, query string, roomIDs []string, limit int) ([]*Message, error)
+	JoinedRooms() ([]string, error)
+	JoinedMembers(roomID string) (map[string]int64, error)
+	GetRoomName(roomID string) string
+	GetRoomInfo(roomID string) *RoomInfo
+	GetDisplayName(roomID, userID string) string
+	GetAccountData(eventType string, v interface{}) error
+	SetAccountData(eventType string, v interface{}) error
 	GetRoomType(ctx context.Context, roomID string) (RoomType, error)
 	CheckAndJoinInvitedRooms(ctx context.Context) error
-	IsFromAllowedDomain(userID string) bool
+	GetUserPowerLevel(roomID string, userID string) (int, error)
 	IsAddressedToBot(content string, roomType RoomType) bool
 	CreateRoom(name string, topic string, inviteUsers []string, isDirect bool) (string, error)
 	InviteUser(roomID string, userID string) error
+	EnsureDirectRoom(userID string) (string, error)
+	SendPrompt(roomID string, content string, reactions []string) (string, error)
 	GetBotUserID() string
 	SendTyping(roomID string, typing bool, timeout int) error
 }
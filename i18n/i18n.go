@@ -0,0 +1,151 @@
+// Package i18n renders Henry's own messages from the catalogs bundled in
+// locales/. Each catalog maps a message key to a text/template; variables
+// are passed as Vars and used as {{.name}}.
+package i18n
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// DefaultLanguage is used for keys a catalog lacks.
+const DefaultLanguage = "en"
+
+//go:embed locales/*.json
+var files embed.FS
+
+// Vars are the variables of a message template.
+type Vars map[string]interface{}
+
+// catalogs maps language and key to a parsed template.
+var catalogs = mustLoad()
+
+func mustLoad() map[string]map[string]*template.Template {
+	entries, err := files.ReadDir("locales")
+	if err != nil {
+		panic(fmt.Sprintf("i18n: %v", err))
+	}
+	loaded := make(map[string]map[string]*template.Template)
+	for _, entry := range entries {
+		lang := strings.TrimSuffix(entry.Name(), ".json")
+		data, err := files.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(fmt.Sprintf("i18n: %v", err))
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("i18n: invalid %s: %v", entry.Name(), err))
+		}
+		loaded[lang] = make(map[string]*template.Template, len(messages))
+		for key, text := range messages {
+			tmpl, err := template.New(key).Option("missingkey=error").Parse(text)
+			if err != nil {
+				panic(fmt.Sprintf("i18n: invalid %s in %s: %v", key, entry.Name(), err))
+			}
+			loaded[lang][key] = tmpl
+		}
+	}
+	for _, problem := range drift(loaded) {
+		log.Printf("WARNING: %s", problem)
+	}
+	return loaded
+}
+
+// drift lists the keys in which the catalogs differ from the default one:
+// messages left untranslated, and translations of messages it doesn't
+// have, which are never shown.
+func drift(catalogs map[string]map[string]*template.Template) []string {
+	var problems []string
+	for lang, messages := range catalogs {
+		for key := range catalogs[DefaultLanguage] {
+			if _, ok := messages[key]; !ok {
+				problems = append(problems, fmt.Sprintf("Message %s has no %s translation", key, lang))
+			}
+		}
+		for key := range messages {
+			if _, ok := catalogs[DefaultLanguage][key]; !ok {
+				problems = append(problems, fmt.Sprintf("Message %s in %s is missing from %s", key, lang, DefaultLanguage))
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// Languages lists the bundled languages.
+func Languages() []string {
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Language returns the bundled language of a locale such as "de-AT", or
+// "" if there is none.
+func Language(locale string) string {
+	lang := strings.ToLower(strings.SplitN(strings.ReplaceAll(locale, "_", "-"), "-", 2)[0])
+	if _, ok := catalogs[lang]; !ok {
+		return ""
+	}
+	return lang
+}
+
+// Has reports whether the catalog of lang has the message key.
+func Has(lang, key string) bool {
+	_, ok := catalogs[lang][key]
+	return ok
+}
+
+// Text renders the message key in lang, falling back to DefaultLanguage
+// and finally to the key itself.
+func Text(lang, key string, vars Vars) string {
+	tmpl, ok := catalogs[lang][key]
+	if !ok {
+		if tmpl, ok = catalogs[DefaultLanguage][key]; !ok {
+			log.Printf("WARNING: Unknown message %s", key)
+			return key
+		}
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, vars); err != nil {
+		log.Printf("WARNING: Failed to render message %s in %s: %v", key, lang, err)
+		return key
+	}
+	return b.String()
+}
+
+// Error is an error whose message comes from the catalog, so it can be
+// shown to users in their language.
+type Error struct {
+	Key  string
+	Vars Vars
+}
+
+// NewError returns an Error for the message key.
+func NewError(key string, vars Vars) *Error {
+	return &Error{Key: key, Vars: vars}
+}
+
+func (e *Error) Error() string {
+	return Text(DefaultLanguage, e.Key, e.Vars)
+}
+
+// ErrorText renders err in lang if it is, or wraps, an Error, and returns
+// err's own message otherwise.
+func ErrorText(lang string, err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return Text(lang, e.Key, e.Vars)
+	}
+	return err.Error()
+}
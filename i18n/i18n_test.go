@@ -0,0 +1,9 @@
+package i18n
+
+import "testing"
+
+func TestCatalogsHaveTheSameKeys(t *testing.T) {
+	for _, problem := range drift(catalogs) {
+		t.Error(problem)
+	}
+}
@@ -0,0 +1,103 @@
+package knowledge
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters, the usual defaults.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// stopwords are left out of the index. English and German, since that's
+// what Henry's users write.
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"but": true, "by": true, "can": true, "do": true, "for": true, "from": true, "how": true,
+	"i": true, "if": true, "in": true, "is": true, "it": true, "of": true, "on": true,
+	"or": true, "that": true, "the": true, "this": true, "to": true, "was": true, "we": true,
+	"what": true, "when": true, "where": true, "which": true, "who": true, "with": true,
+	"you": true, "der": true, "die": true, "das": true, "und": true, "ist": true, "ein": true,
+	"eine": true, "nicht": true, "mit": true, "zu": true, "den": true, "von": true, "wie": true,
+	"wir": true, "ich": true, "es": true, "im": true, "auf": true, "für": true,
+}
+
+// Tokenize lowercases text and splits it into indexable terms.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := words[:0]
+	for _, w := range words {
+		if len(w) > 1 && !stopwords[w] {
+			terms = append(terms, w)
+		}
+	}
+	return terms
+}
+
+// analyze fills in the chunk's term frequencies. The heading counts as
+// part of the text.
+func (c *Chunk) analyze() {
+	terms := Tokenize(c.Heading + " " + c.Text)
+	c.terms = make(map[string]int, len(terms))
+	for _, t := range terms {
+		c.terms[t]++
+	}
+	c.length = len(terms)
+}
+
+// rank scores chunks against the query with Okapi BM25. Chunks matching
+// no query term score 0.
+func rank(chunks []*Chunk, query string) []float64 {
+	scores := make([]float64, len(chunks))
+	if len(chunks) == 0 {
+		return scores
+	}
+
+	total := 0
+	for _, c := range chunks {
+		total += c.length
+	}
+	avgLength := float64(total) / float64(len(chunks))
+	if avgLength == 0 {
+		return scores
+	}
+
+	seen := make(map[string]bool)
+	for _, term := range Tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		df := 0
+		for _, c := range chunks {
+			if c.terms[term] > 0 {
+				df++
+			}
+		}
+		if df == 0 {
+			continue
+		}
+		for i, c := range chunks {
+			scores[i] += TermScore(c.terms[term], df, len(chunks), c.length, avgLength)
+		}
+	}
+	return scores
+}
+
+// TermScore is the BM25 score of one query term for a text of length
+// terms containing it tf times, among n texts of which df contain it.
+func TermScore(tf, df, n, length int, avgLength float64) float64 {
+	if tf == 0 || df == 0 || avgLength == 0 {
+		return 0
+	}
+	f := float64(tf)
+	idf := math.Log(1 + (float64(n)-float64(df)+0.5)/(float64(df)+0.5))
+	norm := 1 - bm25B + bm25B*float64(length)/avgLength
+	return idf * f * (bm25K1 + 1) / (f + bm25K1*norm)
+}
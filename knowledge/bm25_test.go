@@ -0,0 +1,48 @@
+package knowledge
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"How do I deploy the API?", []string{"deploy", "api"}},
+		{"Wie ist der Zugang für Übergaben?", []string{"zugang", "übergaben"}},
+		{"v2.3 rollback-plan, step 4", []string{"v2", "rollback", "plan", "step"}},
+		{"a I x", []string{}},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTermScoreOrdering(t *testing.T) {
+	base := TermScore(1, 2, 10, 50, 50)
+	if base <= 0 {
+		t.Fatalf("matching term scored %v", base)
+	}
+	tests := []struct {
+		name   string
+		higher float64
+	}{
+		{"more occurrences", TermScore(3, 2, 10, 50, 50)},
+		{"rarer term", TermScore(1, 1, 10, 50, 50)},
+		{"shorter text", TermScore(1, 2, 10, 20, 50)},
+	}
+	for _, tt := range tests {
+		if tt.higher <= base {
+			t.Errorf("%s scored %v, not above %v", tt.name, tt.higher, base)
+		}
+	}
+	for _, zero := range []float64{TermScore(0, 2, 10, 50, 50), TermScore(1, 0, 10, 50, 50), TermScore(1, 2, 10, 50, 0)} {
+		if zero != 0 {
+			t.Errorf("degenerate input scored %v", zero)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package knowledge
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// maxChunkLength is the longest chunk in bytes. Chunks are cut at
+// paragraph boundaries where possible.
+const maxChunkLength = 1500
+
+// Chunk is a retrievable piece of a document.
+type Chunk struct {
+	// Heading is the Markdown heading the chunk is under, if any.
+	Heading string `json:"heading,omitempty"`
+	Text    string `json:"text"`
+
+	terms  map[string]int
+	length int
+}
+
+// chunkMarkdown splits a Markdown document into chunks. A heading always
+// starts a new chunk; paragraphs are packed together up to maxChunkLength.
+// Headings inside code blocks are left alone.
+func chunkMarkdown(text string) []Chunk {
+	var chunks []Chunk
+	var heading string
+	var current strings.Builder
+
+	flush := func() {
+		body := strings.TrimSpace(current.String())
+		current.Reset()
+		for body != "" {
+			part := body
+			if len(part) > maxChunkLength {
+				cut := strings.LastIndexAny(part[:maxChunkLength], " \n")
+				if cut <= 0 {
+					cut = maxChunkLength
+					for cut > 0 && !utf8.RuneStart(part[cut]) {
+						cut--
+					}
+				}
+				part = part[:cut]
+			}
+			chunks = append(chunks, Chunk{Heading: heading, Text: strings.TrimSpace(part)})
+			body = strings.TrimSpace(body[len(part):])
+		}
+	}
+
+	inFence := false
+	paragraph := []string{}
+	endParagraph := func() {
+		if len(paragraph) == 0 {
+			return
+		}
+		p := strings.Join(paragraph, "\n")
+		paragraph = paragraph[:0]
+		if current.Len() > 0 && current.Len()+2+len(p) > maxChunkLength {
+			flush()
+		}
+		if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(p)
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+		}
+		switch {
+		case !inFence && strings.HasPrefix(trimmed, "#"):
+			endParagraph()
+			flush()
+			heading = strings.TrimSpace(strings.TrimLeft(trimmed, "#"))
+		case !inFence && trimmed == "":
+			endParagraph()
+		default:
+			paragraph = append(paragraph, line)
+		}
+	}
+	endParagraph()
+	flush()
+	return chunks
+}
@@ -0,0 +1,64 @@
+package knowledge
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestChunkMarkdownHeadings(t *testing.T) {
+	text := "Intro text.\n\n# Deploy\n\nRun make.\r\n\r\n## Rollback\n\n```sh\n# not a heading\n\ngit revert\n```\n"
+	chunks := chunkMarkdown(text)
+	want := []Chunk{
+		{Text: "Intro text."},
+		{Heading: "Deploy", Text: "Run make."},
+		{Heading: "Rollback", Text: "```sh\n# not a heading\n\ngit revert\n```"},
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("got %d chunks, want %d: %+v", len(chunks), len(want), chunks)
+	}
+	for i := range want {
+		if chunks[i].Heading != want[i].Heading || chunks[i].Text != want[i].Text {
+			t.Errorf("chunk %d = %+v, want %+v", i, chunks[i], want[i])
+		}
+	}
+}
+
+func TestChunkMarkdownBoundaries(t *testing.T) {
+	paragraph := strings.TrimSpace(strings.Repeat("word ", 100))
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{"paragraphs packed", paragraph + "\n\n" + paragraph, 1},
+		{"new chunk when the next paragraph doesn't fit", strings.Repeat(paragraph+"\n\n", 4), 2},
+		{"long paragraph cut at spaces", strings.Repeat("word ", 700), 3},
+		{"long word cut on a rune boundary", "x" + strings.Repeat("ä", 1000), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := chunkMarkdown(tt.text)
+			if len(chunks) != tt.want {
+				t.Errorf("got %d chunks, want %d", len(chunks), tt.want)
+			}
+			var joined strings.Builder
+			for _, c := range chunks {
+				if len(c.Text) > maxChunkLength {
+					t.Errorf("chunk is %d bytes, over %d", len(c.Text), maxChunkLength)
+				}
+				if !utf8.ValidString(c.Text) {
+					t.Errorf("chunk cuts a rune: %q", c.Text[len(c.Text)-4:])
+				}
+				joined.WriteString(c.Text)
+			}
+			if strip(joined.String()) != strip(tt.text) {
+				t.Error("chunks lost text")
+			}
+		})
+	}
+}
+
+func strip(text string) string {
+	return strings.Join(strings.Fields(text), "")
+}
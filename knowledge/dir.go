@@ -0,0 +1,119 @@
+package knowledge
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IsDocument reports whether a file name looks like a text document Henry
+// can index.
+func IsDocument(name, mimeType string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".md", ".markdown", ".txt", ".rst", ".adoc":
+		return true
+	}
+	return mimeType == "text/markdown" || mimeType == "text/plain"
+}
+
+// SyncDir makes the index match the documents under dir. Files directly
+// in dir, or in folders not named after a room, belong to every room;
+// files in a folder named after a room ID, like "!abc:henhouse.im", only
+// to that room. It returns how many documents were added, updated and
+// removed.
+func (idx *Index) SyncDir(dir string) (added, updated, removed int, err error) {
+	found := make(map[string]string)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !IsDocument(info.Name(), "") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		found[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to read knowledge dir: %v", err)
+	}
+
+	existing := make(map[string]Document)
+	for _, doc := range idx.all(SourceDir) {
+		existing[doc.Path] = doc
+	}
+
+	for path, doc := range existing {
+		text, ok := found[path]
+		switch {
+		case !ok:
+			if err := idx.Remove(doc.ID); err != nil {
+				return added, updated, removed, err
+			}
+			removed++
+		case doc.Hash != hash(text):
+			if _, err := idx.Replace(doc.ID, text); err != nil {
+				return added, updated, removed, err
+			}
+			updated++
+		}
+	}
+
+	for path, text := range found {
+		if _, ok := existing[path]; ok {
+			continue
+		}
+		doc := Document{
+			RoomID: roomOfPath(path),
+			Name:   filepath.Base(path),
+			Source: SourceDir,
+			Path:   path,
+		}
+		if _, err := idx.Add(doc, text); err != nil {
+			log.Printf("Skipping knowledge file %s: %v", path, err)
+			continue
+		}
+		added++
+	}
+	return added, updated, removed, nil
+}
+
+// all returns the documents from one source.
+func (idx *Index) all(source string) []Document {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var docs []Document
+	for _, doc := range idx.state.Documents {
+		if doc.Source == source {
+			docs = append(docs, *doc)
+		}
+	}
+	return docs
+}
+
+// roomOfPath returns the room a dir document belongs to, or "" for all
+// rooms.
+func roomOfPath(path string) string {
+	first := strings.SplitN(path, "/", 2)[0]
+	if strings.HasPrefix(first, "!") && strings.Contains(first, ":") && first != path {
+		return first
+	}
+	return ""
+}
+
+func hash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,239 @@
+// Package knowledge keeps room knowledge bases: Markdown and text
+// documents, chunked and searched locally with BM25.
+package knowledge
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/huhndev/gohenry/store"
+)
+
+// Document sources.
+const (
+	SourceUpload = "upload"
+	SourceDir    = "dir"
+)
+
+// Document is an indexed document. Documents without a room belong to
+// every room.
+type Document struct {
+	ID     string `json:"id"`
+	RoomID string `json:"room_id,omitempty"`
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	// Path is the file of a dir document, relative to the knowledge dir.
+	Path string `json:"path,omitempty"`
+	// URL is the mxc:// URL of an uploaded document.
+	URL     string    `json:"url,omitempty"`
+	Hash    string    `json:"hash"`
+	AddedBy string    `json:"added_by,omitempty"`
+	AddedAt time.Time `json:"added_at"`
+	Chunks  []Chunk   `json:"chunks"`
+}
+
+// Result is a chunk that matched a search.
+type Result struct {
+	DocumentID string
+	Name       string
+	Heading    string
+	Text       string
+	Score      float64
+}
+
+// Source names the result for citations, e.g. "deploy.md § Rollback".
+func (r Result) Source() string {
+	if r.Heading == "" {
+		return r.Name
+	}
+	return r.Name + " § " + r.Heading
+}
+
+// state is the format of knowledge.json.
+type state struct {
+	NextID    int                  `json:"next_id"`
+	Documents map[string]*Document `json:"documents"`
+}
+
+// Index holds all documents with their chunks. It's saved to
+// knowledge.json on every change; term frequencies are recomputed on load.
+type Index struct {
+	file *store.File
+
+	mu    sync.RWMutex
+	state state
+}
+
+func NewIndex(dataDir string) *Index {
+	idx := &Index{
+		file:  store.NewFile(dataDir, "knowledge.json"),
+		state: state{NextID: 1, Documents: make(map[string]*Document)},
+	}
+	if err := idx.file.Load(&idx.state); err != nil {
+		log.Printf("WARNING: Failed to load knowledge base: %v", err)
+	}
+	if idx.state.Documents == nil {
+		idx.state.Documents = make(map[string]*Document)
+	}
+	for _, doc := range idx.state.Documents {
+		analyze(doc)
+	}
+	return idx
+}
+
+// Add indexes text as a new document and returns it.
+func (idx *Index) Add(doc Document, text string) (Document, error) {
+	doc.Chunks = chunkMarkdown(text)
+	if len(doc.Chunks) == 0 {
+		return Document{}, fmt.Errorf("%s has no text", doc.Name)
+	}
+	doc.Hash = hash(text)
+	doc.AddedAt = time.Now()
+	analyze(&doc)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	doc.ID = strconv.Itoa(idx.state.NextID)
+	idx.state.NextID++
+	idx.state.Documents[doc.ID] = &doc
+	if err := idx.saveLocked(); err != nil {
+		delete(idx.state.Documents, doc.ID)
+		return Document{}, err
+	}
+	return doc, nil
+}
+
+// Replace re-indexes an existing document with new text.
+func (idx *Index) Replace(id, text string) (Document, error) {
+	chunks := chunkMarkdown(text)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	doc, ok := idx.state.Documents[id]
+	if !ok {
+		return Document{}, fmt.Errorf("no document %s", id)
+	}
+	previous := *doc
+	doc.Chunks = chunks
+	doc.Hash = hash(text)
+	analyze(doc)
+	if err := idx.saveLocked(); err != nil {
+		*doc = previous
+		return Document{}, err
+	}
+	return *doc, nil
+}
+
+// Remove deletes a document.
+func (idx *Index) Remove(id string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	doc, ok := idx.state.Documents[id]
+	if !ok {
+		return fmt.Errorf("no document %s", id)
+	}
+	delete(idx.state.Documents, id)
+	if err := idx.saveLocked(); err != nil {
+		idx.state.Documents[id] = doc
+		return err
+	}
+	return nil
+}
+
+// Forget deletes the documents the user uploaded and returns how many
+// there were.
+func (idx *Index) Forget(userID string) (int, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	removed := 0
+	for id, doc := range idx.state.Documents {
+		if doc.AddedBy == userID {
+			delete(idx.state.Documents, id)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, idx.saveLocked()
+}
+
+// Get returns a document.
+func (idx *Index) Get(id string) (Document, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	doc, ok := idx.state.Documents[id]
+	if !ok {
+		return Document{}, false
+	}
+	return *doc, true
+}
+
+// List returns the documents visible in a room, by name.
+func (idx *Index) List(roomID string) []Document {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var docs []Document
+	for _, doc := range idx.state.Documents {
+		if doc.RoomID == "" || doc.RoomID == roomID {
+			docs = append(docs, *doc)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Name < docs[j].Name })
+	return docs
+}
+
+// Search returns the best chunks for query among the documents visible in
+// the room, best first.
+func (idx *Index) Search(roomID, query string, limit int) []Result {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var chunks []*Chunk
+	var docs []*Document
+	for _, doc := range idx.state.Documents {
+		if doc.RoomID != "" && doc.RoomID != roomID {
+			continue
+		}
+		for i := range doc.Chunks {
+			chunks = append(chunks, &doc.Chunks[i])
+			docs = append(docs, doc)
+		}
+	}
+
+	var results []Result
+	for i, score := range rank(chunks, query) {
+		if score <= 0 {
+			continue
+		}
+		results = append(results, Result{
+			DocumentID: docs[i].ID,
+			Name:       docs[i].Name,
+			Heading:    chunks[i].Heading,
+			Text:       chunks[i].Text,
+			Score:      score,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+func (idx *Index) saveLocked() error {
+	if err := idx.file.Save(idx.state); err != nil {
+		return fmt.Errorf("failed to save knowledge base: %v", err)
+	}
+	return nil
+}
+
+func analyze(doc *Document) {
+	for i := range doc.Chunks {
+		doc.Chunks[i].analyze()
+	}
+}
@@ -0,0 +1,103 @@
+package knowledge
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestForget(t *testing.T) {
+	dir := t.TempDir()
+	idx := NewIndex(dir)
+	for _, doc := range []Document{
+		{Name: "alice.md", Source: SourceUpload, AddedBy: "@alice:example.org"},
+		{Name: "bob.md", Source: SourceUpload, AddedBy: "@bob:example.org"},
+		{Name: "shared.md", Source: SourceDir},
+	} {
+		if _, err := idx.Add(doc, "Deploy with make deploy."); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err := idx.Forget("@alice:example.org"); err != nil || n != 1 {
+		t.Fatalf("Forget = %d, %v; want 1", n, err)
+	}
+	if n, err := idx.Forget("@alice:example.org"); err != nil || n != 0 {
+		t.Fatalf("second Forget = %d, %v; want 0", n, err)
+	}
+
+	reloaded := NewIndex(dir)
+	var names []string
+	for _, doc := range reloaded.List("!room:example.org") {
+		names = append(names, doc.Name)
+	}
+	if len(names) != 2 || names[0] != "bob.md" || names[1] != "shared.md" {
+		t.Errorf("documents after Forget = %v", names)
+	}
+	for _, result := range reloaded.Search("!room:example.org", "deploy", 10) {
+		if result.Name == "alice.md" {
+			t.Error("forgotten document still found")
+		}
+	}
+}
+
+func TestSearchRanksAndScopesByRoom(t *testing.T) {
+	idx := NewIndex(t.TempDir())
+	add := func(name, roomID, text string) {
+		if _, err := idx.Add(Document{Name: name, RoomID: roomID, Source: SourceUpload}, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("deploy.md", "", "# Rollback\n\nTo roll back a deploy, revert the release tag and deploy again.")
+	add("lunch.md", "", "# Lunch\n\nThe canteen opens at noon.")
+	add("other.md", "!other:example.org", "Deploy rollback notes for another team.")
+
+	results := idx.Search("!room:example.org", "how to rollback a deploy", 10)
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1: %+v", len(results), results)
+	}
+	if results[0].Source() != "deploy.md § Rollback" {
+		t.Errorf("source = %q", results[0].Source())
+	}
+}
+
+func TestSyncDir(t *testing.T) {
+	dir := t.TempDir()
+	write := func(path, text string) {
+		full := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(full, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("all.md", "For everyone.")
+	write("!team:example.org/team.md", "For the team.")
+	write("guides/guide.txt", "A guide.")
+	write("image.png", "not a document")
+
+	idx := NewIndex(t.TempDir())
+	if added, updated, removed, err := idx.SyncDir(dir); err != nil || added != 3 || updated != 0 || removed != 0 {
+		t.Fatalf("first sync = %d, %d, %d, %v; want 3 added", added, updated, removed, err)
+	}
+	rooms := make(map[string]string)
+	for _, doc := range idx.all(SourceDir) {
+		rooms[doc.Path] = doc.RoomID
+	}
+	want := map[string]string{"all.md": "", "!team:example.org/team.md": "!team:example.org", "guides/guide.txt": ""}
+	for path, room := range want {
+		if got, ok := rooms[path]; !ok || got != room {
+			t.Errorf("%s: room %q (indexed %v), want %q", path, got, ok, room)
+		}
+	}
+
+	write("all.md", "For everyone, updated.")
+	if err := os.Remove(filepath.Join(dir, "guides", "guide.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if added, updated, removed, err := idx.SyncDir(dir); err != nil || added != 0 || updated != 1 || removed != 1 {
+		t.Fatalf("second sync = %d, %d, %d, %v; want 1 updated, 1 removed", added, updated, removed, err)
+	}
+}
@@ -6,10 +6,14 @@ import (
 	"fmt"
 	"log"
 	"os"
+	_ "time/tzdata"
 
+	"github.com/huhndev/gohenry/access"
+	"github.com/huhndev/gohenry/chat"
 	"github.com/huhndev/gohenry/claude"
 	"github.com/huhndev/gohenry/config"
 	"github.com/huhndev/gohenry/matrix"
+	"github.com/huhndev/gohenry/redact"
 )
 
 func main() {
@@ -21,6 +25,10 @@ func main() {
 
 	debugCmd := flag.NewFlagSet("debug", flag.ExitOnError)
 
+	logoutCmd := flag.NewFlagSet("logout", flag.ExitOnError)
+
+	registerCmd := flag.NewFlagSet("register-appservice", flag.ExitOnError)
+
 	inviteCmd := flag.NewFlagSet("invite", flag.ExitOnError)
 	inviteUserID := inviteCmd.String("user", "", "User ID to invite")
 	inviteRoomID := inviteCmd.String("room", "", "Room ID to invite user to")
@@ -34,6 +42,8 @@ func main() {
 		fmt.Println("  ./gohenry debug                     Show connection status and debugging info")
 		fmt.Println("  ./gohenry invite <user_id>          Create a room and invite a user")
 		fmt.Println("  ./gohenry invite -user <user_id> -room <room_id> [-create]  Invite a user to a room")
+		fmt.Println("  ./gohenry logout                    Log out and remove the stored session")
+		fmt.Println("  ./gohenry register-appservice       Generate an appservice registration file")
 		fmt.Println("\nFor more information, see README.md")
 		return
 	}
@@ -48,12 +58,31 @@ func main() {
 		log.Fatalf("Failed to create Matrix service: %v", err)
 	}
 
-	aiService, err := claude.NewService(cfg.ClaudeAPIKey)
+	redactRules, err := redact.LoadRules(cfg.RedactFile)
+	if err != nil {
+		log.Fatalf("Failed to load redaction rules: %v", err)
+	}
+	redactor, err := redact.New(cfg.Redact, redactRules)
+	if err != nil {
+		log.Fatalf("Failed to set up redaction: %v", err)
+	}
+
+	aiService, err := claude.NewService(cfg.ClaudeAPIKey, cfg.ClaudeModel, redactor)
 	if err != nil {
 		log.Fatalf("Failed to create Claude service: %v", err)
 	}
 
-	bot := NewBot(cfg, matrixService, aiService)
+	accessPolicy, err := access.Load(cfg, matrixService.GetUserPowerLevel)
+	if err != nil {
+		log.Fatalf("Failed to load access policy: %v", err)
+	}
+
+	standups, err := chat.LoadStandups(cfg.StandupFile, cfg.Timezone)
+	if err != nil {
+		log.Fatalf("Failed to load standups: %v", err)
+	}
+
+	bot := NewBot(cfg, matrixService, aiService, accessPolicy, standups)
 
 	ctx := context.Background()
 
@@ -98,6 +127,21 @@ func main() {
 			log.Fatalf("Failed to invite user: %v", err)
 		}
 
+	case "register-appservice":
+		registerCmd.Parse(os.Args[2:])
+		reg, err := matrix.GenerateRegistration(cfg)
+		if err != nil {
+			log.Fatalf("Failed to generate registration: %v", err)
+		}
+		log.Printf("Wrote appservice registration %q to %s", reg.ID, cfg.AppServiceRegistration)
+		log.Printf("Add it to app_service_config_files in your homeserver config and restart it")
+
+	case "logout":
+		logoutCmd.Parse(os.Args[2:])
+		if err := bot.Logout(ctx); err != nil {
+			log.Fatalf("Failed to log out: %v", err)
+		}
+
 	default:
 		if err := bot.Run(ctx); err != nil {
 			log.Fatalf("Error running bot: %v", err)
@@ -0,0 +1,29 @@
+package matrix
+
+import (
+	"errors"
+	"fmt"
+
+	"maunium.net/go/mautrix"
+)
+
+// GetAccountData reads an account data event of Henry's user into v. A
+// missing event leaves v untouched.
+func (c *Client) GetAccountData(eventType string, v interface{}) error {
+	err := c.client.GetAccountData(eventType, v)
+	if errors.Is(err, mautrix.MNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get account data %s: %v", eventType, err)
+	}
+	return nil
+}
+
+// SetAccountData replaces an account data event of Henry's user.
+func (c *Client) SetAccountData(eventType string, v interface{}) error {
+	if err := c.client.SetAccountData(eventType, v); err != nil {
+		return fmt.Errorf("failed to set account data %s: %v", eventType, err)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package matrix
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/appservice"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/huhndev/gohenry/config"
+)
+
+const (
+	appServiceID = "henry"
+	// processedTxnLimit bounds how many transaction IDs are remembered for
+	// deduplicating retries from the homeserver.
+	processedTxnLimit = 256
+)
+
+// GenerateRegistration writes a new appservice registration for the bot
+// user to cfg.AppServiceRegistration. The file must then be added to the
+// homeserver's app_service_config_files. An existing file is never
+// overwritten, since that would invalidate the tokens the homeserver has.
+func GenerateRegistration(cfg *config.Config) (*appservice.Registration, error) {
+	if cfg.AppServiceRegistration == "" {
+		return nil, fmt.Errorf("HENRY_APPSERVICE_REGISTRATION must be set")
+	}
+	if _, err := os.Stat(cfg.AppServiceRegistration); err == nil {
+		return nil, fmt.Errorf("registration file %s already exists", cfg.AppServiceRegistration)
+	}
+
+	localpart, _, err := id.UserID(cfg.MatrixUserID).Parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid HENRY_MATRIX_USER_ID: %v", err)
+	}
+
+	rateLimited := false
+	reg := appservice.CreateRegistration()
+	reg.ID = appServiceID
+	reg.URL = cfg.AppServiceURL
+	reg.SenderLocalpart = localpart
+	reg.RateLimited = &rateLimited
+	reg.EphemeralEvents = true
+	reg.SoruEphemeralEvents = true
+	reg.Namespaces.UserIDs.Register(
+		regexp.MustCompile("^"+regexp.QuoteMeta(cfg.MatrixUserID)+"$"),
+		true,
+	)
+
+	if err := reg.Save(cfg.AppServiceRegistration); err != nil {
+		return nil, fmt.Errorf("failed to save registration: %v", err)
+	}
+	return reg, nil
+}
+
+func (c *Client) isAppService() bool {
+	return c.config.AppServiceRegistration != ""
+}
+
+// connectAppService sets up a client that acts as the appservice's sender
+// user. There is no login and no device; requests are authenticated with
+// the as_token and exempt from rate limits via the registration.
+func (c *Client) connectAppService(ctx context.Context) error {
+	reg, err := appservice.LoadRegistration(c.config.AppServiceRegistration)
+	if err != nil {
+		return fmt.Errorf("failed to load appservice registration: %v", err)
+	}
+
+	localpart, _, err := id.UserID(c.config.MatrixUserID).Parse()
+	if err != nil {
+		return fmt.Errorf("invalid HENRY_MATRIX_USER_ID: %v", err)
+	}
+	if localpart != reg.SenderLocalpart {
+		return fmt.Errorf(
+			"registration sender_localpart %q does not match user ID %s",
+			reg.SenderLocalpart, c.config.MatrixUserID,
+		)
+	}
+
+	client, err := mautrix.NewClient(
+		c.config.MatrixHomeserver,
+		id.UserID(c.config.MatrixUserID),
+		reg.AppToken,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create Matrix client: %v", err)
+	}
+	client.SetAppServiceUserID = true
+
+	if _, err := client.Whoami(); err != nil {
+		return fmt.Errorf("homeserver rejected appservice token: %v", err)
+	}
+
+	c.registration = reg
+	c.client = client
+	c.userID = client.UserID
+	log.Printf("Matrix appservice client initialized with user ID: %s", c.userID)
+
+	c.outbox.start(ctx, client)
+	return nil
+}
+
+// listenAppService serves the appservice API until ctx is cancelled.
+func (c *Client) listenAppService(ctx context.Context) error {
+	handler := NewTransactionHandler(
+		c.registration.ServerToken,
+		c.userID,
+		func(evt *event.Event) { c.handleEvent(ctx, evt) },
+	)
+
+	server := &http.Server{
+		Addr:    c.config.AppServiceListen,
+		Handler: handler,
+	}
+
+	go func() {
+		log.Printf("Listening for appservice transactions on %s", c.config.AppServiceListen)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Appservice listener error: %v", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down appservice listener: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// TransactionHandler implements the homeserver-facing side of the
+// appservice API: it authenticates requests with the hs_token,
+// deduplicates retried transactions and passes each event to handle.
+type TransactionHandler struct {
+	serverToken string
+	botUserID   id.UserID
+	handle      func(evt *event.Event)
+
+	mu        sync.Mutex
+	processed map[string]bool
+	order     []string
+}
+
+func NewTransactionHandler(
+	serverToken string,
+	botUserID id.UserID,
+	handle func(evt *event.Event),
+) *TransactionHandler {
+	return &TransactionHandler{
+		serverToken: serverToken,
+		botUserID:   botUserID,
+		handle:      handle,
+		processed:   make(map[string]bool),
+	}
+}
+
+func (h *TransactionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/_matrix/app/v1")
+
+	if !h.checkToken(r) {
+		writeAppServiceError(w, http.StatusForbidden, "M_FORBIDDEN", "Invalid homeserver token")
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(path, "/transactions/") && r.Method == http.MethodPut:
+		h.putTransaction(w, r, strings.TrimPrefix(path, "/transactions/"))
+	case strings.HasPrefix(path, "/users/") && r.Method == http.MethodGet:
+		if id.UserID(strings.TrimPrefix(path, "/users/")) == h.botUserID {
+			writeJSON(w, http.StatusOK, struct{}{})
+			return
+		}
+		writeAppServiceError(w, http.StatusNotFound, "M_NOT_FOUND", "No such user")
+	case path == "/ping" && r.Method == http.MethodPost:
+		writeJSON(w, http.StatusOK, struct{}{})
+	default:
+		writeAppServiceError(w, http.StatusNotFound, "M_UNRECOGNIZED", "Unrecognized endpoint")
+	}
+}
+
+func (h *TransactionHandler) checkToken(r *http.Request) bool {
+	token := r.URL.Query().Get("access_token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.serverToken)) == 1
+}
+
+func (h *TransactionHandler) putTransaction(w http.ResponseWriter, r *http.Request, txnID string) {
+	if txnID == "" {
+		writeAppServiceError(w, http.StatusBadRequest, "M_MISSING_PARAM", "Missing transaction ID")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeAppServiceError(w, http.StatusBadRequest, "M_NOT_JSON", "Failed to read body")
+		return
+	}
+
+	var txn appservice.Transaction
+	if err := json.Unmarshal(body, &txn); err != nil {
+		writeAppServiceError(w, http.StatusBadRequest, "M_BAD_JSON", "Failed to parse body")
+		return
+	}
+
+	// A retry that arrives while the first attempt is still dispatching
+	// finds the ID taken and is acknowledged without running again.
+	if !h.reserve(txnID) {
+		writeJSON(w, http.StatusOK, struct{}{})
+		return
+	}
+
+	log.Printf("Received appservice transaction %s with %d events", txnID, len(txn.Events))
+	for _, evt := range txn.Events {
+		if evt.StateKey != nil {
+			evt.Type.Class = event.StateEventType
+		} else {
+			evt.Type.Class = event.MessageEventType
+		}
+		if err := evt.Content.ParseRaw(evt.Type); err != nil &&
+			!errors.Is(err, event.ErrUnsupportedContentType) {
+			log.Printf("Failed to parse content of event %s: %v", evt.ID, err)
+		}
+		h.handle(evt)
+	}
+
+	ephemeral := txn.EphemeralEvents
+	if ephemeral == nil {
+		ephemeral = txn.MSC2409EphemeralEvents
+	}
+	for _, evt := range ephemeral {
+		evt.Type.Class = event.EphemeralEventType
+		if err := evt.Content.ParseRaw(evt.Type); err != nil &&
+			!errors.Is(err, event.ErrUnsupportedContentType) {
+			log.Printf("Failed to parse content of %s event: %v", evt.Type.Type, err)
+		}
+		h.handle(evt)
+	}
+
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+// reserve records txnID as processed and reports whether it was new.
+func (h *TransactionHandler) reserve(txnID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.processed[txnID] {
+		return false
+	}
+	h.processed[txnID] = true
+	h.order = append(h.order, txnID)
+	if len(h.order) > processedTxnLimit {
+		delete(h.processed, h.order[0])
+		h.order = h.order[1:]
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeAppServiceError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]string{
+		"errcode": code,
+		"error":   message,
+	})
+}
@@ -0,0 +1,130 @@
+package matrix
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+)
+
+const testServerToken = "hs-secret"
+
+func newTestTransactionServer(t *testing.T) (*httptest.Server, *[]*event.Event) {
+	var events []*event.Event
+	handler := NewTransactionHandler(testServerToken, "@henry:example.org", func(evt *event.Event) {
+		events = append(events, evt)
+	})
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server, &events
+}
+
+func putTransaction(t *testing.T, server *httptest.Server, txnID, token, body string) int {
+	req, err := http.NewRequest(http.MethodPut, server.URL+"/_matrix/app/v1/transactions/"+txnID, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+const testTransaction = `{"events": [{
+	"type": "m.room.message",
+	"event_id": "$1",
+	"room_id": "!room:example.org",
+	"sender": "@alice:example.org",
+	"origin_server_ts": 1700000000000,
+	"content": {"msgtype": "m.text", "body": "hello"}
+}]}`
+
+func TestTransactionRejectsBadToken(t *testing.T) {
+	server, events := newTestTransactionServer(t)
+
+	for _, token := range []string{"", "wrong"} {
+		if status := putTransaction(t, server, "1", token, testTransaction); status != http.StatusForbidden {
+			t.Errorf("token %q: status %d, want 403", token, status)
+		}
+	}
+	if len(*events) != 0 {
+		t.Errorf("unauthenticated transaction dispatched %d events", len(*events))
+	}
+}
+
+func TestTransactionDispatchesEvents(t *testing.T) {
+	server, events := newTestTransactionServer(t)
+
+	if status := putTransaction(t, server, "1", testServerToken, testTransaction); status != http.StatusOK {
+		t.Fatalf("status %d, want 200", status)
+	}
+	if len(*events) != 1 {
+		t.Fatalf("dispatched %d events, want 1", len(*events))
+	}
+	evt := (*events)[0]
+	if evt.ID != "$1" || evt.Type.Class != event.MessageEventType {
+		t.Errorf("event = %s of class %v", evt.ID, evt.Type.Class)
+	}
+	content, ok := evt.Content.Parsed.(*event.MessageEventContent)
+	if !ok || content.Body != "hello" {
+		t.Errorf("content not parsed: %#v", evt.Content.Parsed)
+	}
+}
+
+func TestTransactionIgnoresReplay(t *testing.T) {
+	server, events := newTestTransactionServer(t)
+
+	for i := 0; i < 2; i++ {
+		if status := putTransaction(t, server, "42", testServerToken, testTransaction); status != http.StatusOK {
+			t.Fatalf("attempt %d: status %d, want 200", i+1, status)
+		}
+	}
+	if len(*events) != 1 {
+		t.Errorf("replayed transaction dispatched %d events, want 1", len(*events))
+	}
+
+	if status := putTransaction(t, server, "43", testServerToken, testTransaction); status != http.StatusOK {
+		t.Fatalf("status %d, want 200", status)
+	}
+	if len(*events) != 2 {
+		t.Errorf("new transaction not dispatched, got %d events", len(*events))
+	}
+}
+
+func TestTransactionIgnoresRetryDuringDispatch(t *testing.T) {
+	dispatching := make(chan struct{})
+	release := make(chan struct{})
+	var dispatched int32
+	handler := NewTransactionHandler(testServerToken, "@henry:example.org", func(evt *event.Event) {
+		if atomic.AddInt32(&dispatched, 1) == 1 {
+			close(dispatching)
+			<-release
+		}
+	})
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	first := make(chan int)
+	go func() {
+		first <- putTransaction(t, server, "7", testServerToken, testTransaction)
+	}()
+	<-dispatching
+	if status := putTransaction(t, server, "7", testServerToken, testTransaction); status != http.StatusOK {
+		t.Errorf("retry: status %d, want 200", status)
+	}
+	close(release)
+	if status := <-first; status != http.StatusOK {
+		t.Errorf("first attempt: status %d, want 200", status)
+	}
+	if n := atomic.LoadInt32(&dispatched); n != 1 {
+		t.Errorf("transaction dispatched %d times, want once", n)
+	}
+}
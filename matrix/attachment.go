@@ -0,0 +1,160 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/huhndev/gohenry/domain"
+)
+
+const (
+	// answerFileName is the name under which an oversized answer is sent.
+	answerFileName = "henry-answer.md"
+	// attachmentExcerptLength caps the excerpt posted next to the file.
+	attachmentExcerptLength = 600
+)
+
+// delivery sends the messages making up one answer to a room, in order.
+// Once a send times out with the message still queued, the rest are
+// queued behind it without waiting for the homeserver again.
+type delivery struct {
+	client *Client
+	roomID string
+	queued bool
+}
+
+func (c *Client) newDelivery(roomID string) *delivery {
+	return &delivery{client: c, roomID: roomID}
+}
+
+func (d *delivery) send(content map[string]interface{}) error {
+	if d.queued {
+		_, err := d.client.outbox.enqueue(d.roomID, event.EventMessage, content)
+		return err
+	}
+	if _, err := d.client.outbox.send(d.roomID, event.EventMessage, content); err != nil {
+		if !errors.Is(err, domain.ErrStillQueued) {
+			return err
+		}
+		d.queued = true
+	}
+	return nil
+}
+
+// result reports domain.ErrStillQueued if any message is still waiting
+// for the homeserver.
+func (d *delivery) result() error {
+	if d.queued {
+		return domain.ErrStillQueued
+	}
+	return nil
+}
+
+// sendText sends a message, split into numbered parts if it's too long.
+func (c *Client) sendText(d *delivery, threadID string, message string) error {
+	parts := splitMessage(message, c.config.MaxMessageLength)
+	if len(parts) > 1 {
+		log.Printf("Splitting %d byte message into %d parts for room %s",
+			len(message), len(parts), d.roomID)
+	}
+	for _, part := range parts {
+		m := c.linkMentions(d.roomID, part)
+		content := map[string]interface{}{
+			"msgtype":    "m.text",
+			"body":       m.body,
+			"m.mentions": mentionsContent(m),
+		}
+		if m.formattedBody != "" {
+			content["format"] = "org.matrix.custom.html"
+			content["formatted_body"] = m.formattedBody
+		}
+		addThreadRelation(content, threadID)
+		if err := d.send(content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mentionsContent builds m.mentions. It's always sent, so clients don't
+// fall back to notifying everyone whose name appears in the body.
+func mentionsContent(m mentions) map[string]interface{} {
+	content := map[string]interface{}{}
+	if len(m.userIDs) > 0 {
+		content["user_ids"] = m.userIDs
+	}
+	if m.room {
+		content["room"] = true
+	}
+	return content
+}
+
+// sendAsAttachment uploads the full answer as a Markdown file and posts an
+// excerpt that tells the reader where the rest is. The upload comes first,
+// so a failed upload leaves nothing posted; sent reports whether anything
+// reached the room or is queued for it, in which case the caller must not
+// resend the text.
+func (c *Client) sendAsAttachment(d *delivery, threadID string, message string) (sent bool, err error) {
+	file, err := c.uploadFile(answerFileName, "text/markdown", []byte(message))
+	if err != nil {
+		return false, err
+	}
+
+	excerpt := summarizeForAttachment(message, attachmentExcerptLength)
+	intro := fmt.Sprintf("%s\n\n(Full answer attached as %s)", excerpt, answerFileName)
+	if err := c.sendText(d, threadID, intro); err != nil {
+		return false, err
+	}
+	addThreadRelation(file, threadID)
+	if err := d.send(file); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// sendFile uploads data to the media repository and posts it as m.file.
+func (c *Client) sendFile(d *delivery, threadID, fileName, mimeType string, data []byte) error {
+	content, err := c.uploadFile(fileName, mimeType, data)
+	if err != nil {
+		return err
+	}
+	addThreadRelation(content, threadID)
+	return d.send(content)
+}
+
+// uploadFile uploads data to the media repository and returns the content
+// of an m.file event for it.
+func (c *Client) uploadFile(fileName, mimeType string, data []byte) (map[string]interface{}, error) {
+	upload, err := c.client.UploadBytesWithName(data, mimeType, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload %s: %v", fileName, err)
+	}
+	return map[string]interface{}{
+		"msgtype":  "m.file",
+		"body":     fileName,
+		"filename": fileName,
+		"url":      upload.ContentURI.String(),
+		"info": map[string]interface{}{
+			"mimetype": mimeType,
+			"size":     len(data),
+		},
+	}, nil
+}
+
+// DownloadMedia fetches an mxc:// URL from the media repository.
+func (c *Client) DownloadMedia(ctx context.Context, url string) ([]byte, error) {
+	uri, err := id.ParseContentURI(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid media URL %q: %v", url, err)
+	}
+	data, err := c.client.DownloadBytesContext(ctx, uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %v", url, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,64 @@
+package matrix
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/huhndev/gohenry/config"
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/store"
+)
+
+func TestAttachmentQueuedDuringOutageIsNotResplit(t *testing.T) {
+	defer func(timeout time.Duration) { queueSendTimeout = timeout }(queueSendTimeout)
+	queueSendTimeout = 50 * time.Millisecond
+
+	var mu sync.Mutex
+	down := true
+	// Uploads aren't JSON, so their body is empty; only messages fail.
+	hs := newTestHomeserver(t, func(body string, _ int) int {
+		mu.Lock()
+		defer mu.Unlock()
+		if down && body != "" {
+			return http.StatusTooManyRequests
+		}
+		return http.StatusOK
+	})
+	c := &Client{
+		userID:     "@henry:example.org",
+		config:     &config.Config{AttachmentThreshold: 100, MaxMessageLength: 4000},
+		roomStates: newRoomStateCache(),
+		client:     hs.client(t),
+		outbox:     startTestOutbox(t, hs, store.NewFile(t.TempDir(), "outbox.json")),
+	}
+
+	answer := strings.Repeat("A long answer. ", 30)
+	if err := c.SendThreadMessage(testQueueRoom, "", answer); err != domain.ErrStillQueued {
+		t.Fatalf("SendThreadMessage during an outage returned %v, want ErrStillQueued", err)
+	}
+
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	waitIdle(t, c.outbox)
+
+	var delivered []string
+	for _, body := range hs.sent() {
+		if body != "" {
+			delivered = append(delivered, body)
+		}
+	}
+	// The intro is retried until the homeserver is back, then the file.
+	last := delivered[len(delivered)-2:]
+	if !strings.HasSuffix(last[0], "(Full answer attached as "+answerFileName+")") || last[1] != answerFileName {
+		t.Errorf("delivered %q, want the intro and then the file", last)
+	}
+	for _, body := range delivered {
+		if body == answer {
+			t.Error("answer was also sent as text")
+		}
+	}
+}
@@ -2,19 +2,24 @@ package matrix
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/appservice"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
 	"github.com/huhndev/gohenry/config"
 	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/store"
 )
 
 func loadSyncToken(tokenFile string) (string, error) {
@@ -37,33 +42,46 @@ type Client struct {
 	client            *mautrix.Client
 	userID            id.UserID
 	config            *config.Config
-	allowedDomain     string
 	messageHandler    func(ctx context.Context, evt *event.Event)
-	syncToken         string
+	inviteHandler     func(ctx context.Context, roomID, inviterID string)
+	reactionHandler   func(ctx context.Context, roomID, senderID, eventID, key string)
+	receiptHandler    func(ctx context.Context, roomID, userID, eventID string, ts int64)
+	redactionHandler  func(ctx context.Context, roomID, eventID string)
+	syncStore         *fileSyncStore
+	outbox            *outbox
+	registration      *appservice.Registration
+	eventMu           sync.Mutex
+	directMu          sync.Mutex
 	lastProcessedTime int64
 	startupTime       int64
+	searchUnsupported atomic.Bool
+	roomStates        *roomStateCache
 }
 
 func NewClient(cfg *config.Config) *Client {
-	syncToken, err := loadSyncToken(cfg.SyncTokenFile)
-	if err != nil {
-		log.Printf("WARNING: Failed to load sync token: %v", err)
-	} else if syncToken != "" {
-		log.Printf("Loaded sync token: %s", syncToken)
+	syncStore := newFileSyncStore(cfg.SyncTokenFile, cfg.FilterFile, filterHash(syncFilter()))
+	if token := syncStore.LoadNextBatch(""); token != "" {
+		log.Printf("Loaded sync token: %s", token)
 	}
 
 	startupTime := time.Now().UnixNano() / 1e6
 
 	return &Client{
 		config:            cfg,
-		allowedDomain:     cfg.AllowedDomain,
-		syncToken:         syncToken,
+		syncStore:         syncStore,
+		outbox:            newOutbox(store.NewFile(cfg.DataDir, "outbox.json")),
+		roomStates:        newRoomStateCache(),
 		startupTime:       startupTime,
 		lastProcessedTime: startupTime,
 	}
 }
 
 func (c *Client) Connect(ctx context.Context) error {
+	if c.isAppService() {
+		log.Printf("Connecting in appservice mode to %s", c.config.MatrixHomeserver)
+		return c.connectAppService(ctx)
+	}
+
 	log.Printf(
 		"Creating Matrix client for %s with homeserver %s",
 		c.config.MatrixUserID,
@@ -73,7 +91,7 @@ func (c *Client) Connect(ctx context.Context) error {
 	client, err := mautrix.NewClient(
 		c.config.MatrixHomeserver,
 		id.UserID(c.config.MatrixUserID),
-		c.config.MatrixAccessToken,
+		"",
 	)
 	if err != nil {
 		log.Printf("ERROR: Failed to create Matrix client: %v", err)
@@ -81,71 +99,112 @@ func (c *Client) Connect(ctx context.Context) error {
 	}
 	log.Printf("Matrix client created successfully")
 
-	if c.config.MatrixPassword != "" {
-		log.Printf("Attempting to log in with password")
+	if err := c.authenticate(client); err != nil {
+		return err
+	}
 
-		username := strings.TrimPrefix(string(c.config.MatrixUserID), "@")
-		if idx := strings.Index(username, ":"); idx >= 0 {
-			username = username[:idx]
-		}
+	client.Store = c.syncStore
+	client.Syncer.(*mautrix.DefaultSyncer).FilterJSON = syncFilter()
+
+	c.client = client
+	c.userID = client.UserID
+	log.Printf("Matrix client initialized with user ID: %s (device %s)", c.userID, client.DeviceID)
+
+	c.outbox.start(ctx, client)
+
+	return nil
+}
 
-		log.Printf("Logging in as user: %s", username)
-
-		resp, err := client.Login(&mautrix.ReqLogin{
-			Type: "m.login.password",
-			Identifier: mautrix.UserIdentifier{
-				Type: "m.id.user",
-				User: username,
-			},
-			Password:         c.config.MatrixPassword,
-			StoreCredentials: true,
-		})
+// authenticate sets credentials on the client. A configured access token
+// wins, then a session stored by an earlier run, and only then a fresh
+// password login, so restarts keep using the same device.
+func (c *Client) authenticate(client *mautrix.Client) error {
+	if c.config.MatrixAccessToken != "" {
+		log.Printf("Using configured access token for authentication")
+		client.AccessToken = c.config.MatrixAccessToken
+		resp, err := client.Whoami()
 		if err != nil {
-			log.Printf("ERROR: Failed to log in to Matrix: %v", err)
+			return fmt.Errorf("failed to verify access token: %v", err)
+		}
+		client.UserID = resp.UserID
+		client.DeviceID = resp.DeviceID
+		return nil
+	}
 
-			if c.syncToken != "" {
-				log.Printf("Clearing sync token due to login failure")
-				c.syncToken = ""
-				if err := saveSyncToken(c.config.SyncTokenFile, ""); err != nil {
-					log.Printf("WARNING: Failed to clear sync token file: %v", err)
-				}
-			}
+	stored, err := loadSession(c.config.SessionFile)
+	if err != nil {
+		log.Printf("WARNING: Failed to load session: %v", err)
+	}
+
+	if stored.matches(c.config.MatrixHomeserver, c.config.MatrixUserID) {
+		log.Printf("Reusing stored session for device %s", stored.DeviceID)
+		client.AccessToken = stored.AccessToken
+		client.DeviceID = id.DeviceID(stored.DeviceID)
 
-			return fmt.Errorf("failed to log in to Matrix: %v", err)
+		resp, err := client.Whoami()
+		if err == nil {
+			client.UserID = resp.UserID
+			return nil
 		}
-		client.SetCredentials(resp.UserID, resp.AccessToken)
-		log.Printf("Successfully logged in as %s", resp.UserID)
-		log.Printf("Access token: %s", resp.AccessToken)
-	} else if c.config.MatrixAccessToken != "" {
-		log.Printf("Using existing access token for authentication (no password available)")
-	} else {
+		if !errors.Is(err, mautrix.MUnknownToken) {
+			return fmt.Errorf("failed to verify stored session: %v", err)
+		}
+
+		log.Printf("Stored session is no longer valid, logging in again")
+		client.AccessToken = ""
+	}
+
+	if c.config.MatrixPassword == "" {
 		return fmt.Errorf("no access token or password available for authentication")
 	}
+	return c.login(client, stored)
+}
+
+func (c *Client) login(client *mautrix.Client, previous *session) error {
+	log.Printf("Attempting to log in with password")
 
-	log.Printf("Skipping initial sync for faster startup...")
+	username := strings.TrimPrefix(string(c.config.MatrixUserID), "@")
+	if idx := strings.Index(username, ":"); idx >= 0 {
+		username = username[:idx]
+	}
 
-	syncCtx, cancelSync := context.WithTimeout(ctx, 5*time.Second)
-	defer cancelSync()
+	log.Printf("Logging in as user: %s", username)
+
+	req := &mautrix.ReqLogin{
+		Type: "m.login.password",
+		Identifier: mautrix.UserIdentifier{
+			Type: "m.id.user",
+			User: username,
+		},
+		Password:                 c.config.MatrixPassword,
+		InitialDeviceDisplayName: "Henry",
+		StoreCredentials:         true,
+	}
+	if previous != nil && previous.UserID == c.config.MatrixUserID {
+		req.DeviceID = id.DeviceID(previous.DeviceID)
+	}
 
-	syncErrorCh := make(chan error, 1)
-	go func() {
-		syncErrorCh <- client.SyncWithContext(syncCtx)
-	}()
+	resp, err := client.Login(req)
+	if err != nil {
+		log.Printf("ERROR: Failed to log in to Matrix: %v", err)
 
-	select {
-	case err := <-syncErrorCh:
-		if err != nil && err != context.DeadlineExceeded && err != context.Canceled {
-			log.Printf("WARNING: Sync returned error: %v", err)
-		} else if err == nil {
-			log.Printf("Initial sync completed successfully")
+		if c.syncStore.LoadNextBatch("") != "" {
+			log.Printf("Clearing sync token due to login failure")
+			c.syncStore.SaveNextBatch("", "")
 		}
-	case <-time.After(100 * time.Millisecond):
-		log.Printf("Sync has started in background")
-	}
 
-	c.client = client
-	c.userID = id.UserID(c.config.MatrixUserID)
-	log.Printf("Matrix client initialized with user ID: %s", c.userID)
+		return fmt.Errorf("failed to log in to Matrix: %v", err)
+	}
+	log.Printf("Successfully logged in as %s (device %s)", resp.UserID, resp.DeviceID)
+
+	if err := saveSession(c.config.SessionFile, &session{
+		Homeserver:  c.config.MatrixHomeserver,
+		UserID:      c.config.MatrixUserID,
+		DeviceID:    string(resp.DeviceID),
+		AccessToken: resp.AccessToken,
+	}); err != nil {
+		log.Printf("WARNING: Failed to save session: %v", err)
+	}
 
 	return nil
 }
@@ -154,66 +213,162 @@ func (c *Client) SetMessageHandler(handler func(ctx context.Context, evt *event.
 	c.messageHandler = handler
 }
 
-func (c *Client) ListenForMessages(ctx context.Context) error {
-	if c.messageHandler == nil {
-		return fmt.Errorf("message handler not set")
+// SetInviteHandler replaces the default of joining every room the bot is
+// invited to. The handler decides whether to join or reject.
+func (c *Client) SetInviteHandler(handler func(ctx context.Context, roomID, inviterID string)) {
+	c.inviteHandler = handler
+}
+
+// SetReactionHandler registers a callback for reactions from other users.
+func (c *Client) SetReactionHandler(
+	handler func(ctx context.Context, roomID, senderID, eventID, key string),
+) {
+	c.reactionHandler = handler
+}
+
+// SetReceiptHandler registers a callback for read receipts of other users.
+func (c *Client) SetReceiptHandler(
+	handler func(ctx context.Context, roomID, userID, eventID string, ts int64),
+) {
+	c.receiptHandler = handler
+}
+
+// SetRedactionHandler registers a callback for redactions, including
+// Henry's own, with the ID of the redacted event.
+func (c *Client) SetRedactionHandler(handler func(ctx context.Context, roomID, eventID string)) {
+	c.redactionHandler = handler
+}
+
+// handleEvent processes an event from /sync or an appservice transaction:
+// it accepts invites and hands new messages from other users to the
+// message handler.
+func (c *Client) handleEvent(ctx context.Context, evt *event.Event) {
+	c.updateRoomState(evt)
+
+	// Invites are exempt from the startup filter: an invite that arrived
+	// while Henry was down is still waiting for an answer.
+	if c.isInviteForBot(evt) {
+		c.handleInvite(ctx, evt)
+		return
 	}
 
-	syncer := c.client.Syncer.(*mautrix.DefaultSyncer)
+	c.eventMu.Lock()
+	if evt.Timestamp > 0 && evt.Timestamp < c.startupTime {
+		c.eventMu.Unlock()
+		return
+	}
+	if evt.Timestamp > 0 && evt.Timestamp <= c.lastProcessedTime {
+		c.eventMu.Unlock()
+		return
+	}
+	if evt.Timestamp > 0 {
+		c.lastProcessedTime = evt.Timestamp
+	}
+	c.eventMu.Unlock()
 
-	log.Printf("Setting up Matrix event handlers...")
+	if evt.Type == event.EphemeralEventReceipt {
+		c.handleReceipts(ctx, evt)
+		return
+	}
 
-	syncer.OnEvent(func(source mautrix.EventSource, evt *event.Event) {
-		if evt.Timestamp > 0 && evt.Timestamp < c.startupTime {
-			return
+	if evt.Type == event.EventRedaction {
+		if redacted := redactedEventID(evt); redacted != "" && c.redactionHandler != nil {
+			go c.redactionHandler(ctx, string(evt.RoomID), redacted)
 		}
-		if evt.Timestamp > 0 && evt.Timestamp <= c.lastProcessedTime {
-			return
-		}
-		if evt.Timestamp > 0 {
-			c.lastProcessedTime = evt.Timestamp
+		return
+	}
+
+	if evt.Sender == c.userID {
+		return
+	}
+
+	if evt.Type == event.EventReaction {
+		reaction := evt.Content.AsReaction()
+		if c.reactionHandler != nil && reaction.RelatesTo.EventID != "" {
+			go c.reactionHandler(
+				ctx,
+				string(evt.RoomID),
+				string(evt.Sender),
+				string(reaction.RelatesTo.EventID),
+				reaction.RelatesTo.Key,
+			)
 		}
+		return
+	}
 
-		if evt.Type == event.StateMember {
-			member := evt.Content.AsMember()
-			if member != nil {
-				if member.Membership == event.MembershipInvite &&
-					evt.GetStateKey() == string(c.userID) {
-					log.Printf(
-						"INVITE DETECTED - Received invite to room %s from %s",
-						evt.RoomID, evt.Sender,
-					)
-					log.Printf("Attempting to join room %s", evt.RoomID)
-					_, err := c.client.JoinRoom(string(evt.RoomID), "", nil)
-					if err != nil {
-						log.Printf("Failed to join room: %v", err)
-					} else {
-						log.Printf("Successfully joined room %s", evt.RoomID)
-					}
-				}
+	if evt.Type != event.EventMessage {
+		return
+	}
+
+	log.Printf("Processing message from %s in room %s (timestamp: %d)",
+		evt.Sender, evt.RoomID, evt.Timestamp)
+
+	go c.messageHandler(ctx, evt)
+}
+
+// redactedEventID returns the event a redaction removes. Room version 11
+// moved the field from the event into its content.
+func redactedEventID(evt *event.Event) string {
+	if evt.Redacts != "" {
+		return string(evt.Redacts)
+	}
+	redacts, _ := evt.Content.Raw["redacts"].(string)
+	return redacts
+}
+
+// handleReceipts passes each public read receipt of another user on to
+// the receipt handler.
+func (c *Client) handleReceipts(ctx context.Context, evt *event.Event) {
+	if c.receiptHandler == nil {
+		return
+	}
+	receipts := evt.Content.AsReceipt()
+	if receipts == nil {
+		return
+	}
+	for eventID, byType := range *receipts {
+		for userID, receipt := range byType[event.ReceiptTypeRead] {
+			if userID == c.userID {
+				continue
 			}
+			go c.receiptHandler(
+				ctx,
+				string(evt.RoomID),
+				string(userID),
+				string(eventID),
+				receipt.Timestamp.UnixNano()/1e6,
+			)
 		}
+	}
+}
 
-		if evt.Type != event.EventMessage {
-			return
-		}
-		if evt.Sender == c.userID {
-			return
-		}
+func (c *Client) ListenForMessages(ctx context.Context) error {
+	if c.messageHandler == nil {
+		return fmt.Errorf("message handler not set")
+	}
+
+	if c.isAppService() {
+		return c.listenAppService(ctx)
+	}
 
-		log.Printf("Processing message from %s in room %s (timestamp: %d)",
-			evt.Sender, evt.RoomID, evt.Timestamp)
+	syncer := c.client.Syncer.(*mautrix.DefaultSyncer)
 
-		go c.messageHandler(ctx, evt)
-	})
+	log.Printf("Setting up Matrix event handlers...")
 
-	log.Printf("Starting Matrix sync loop...")
+	syncer.OnEvent(func(source mautrix.EventSource, evt *event.Event) {
+		c.handleEvent(ctx, evt)
+	})
 
-	if c.syncToken != "" {
-		log.Printf("Using stored sync token: %s to skip old messages", c.syncToken)
-		c.client.Store.SaveNextBatch("", c.syncToken)
+	if c.syncStore.LoadNextBatch(c.userID) == "" {
+		if err := c.initialSync(ctx); err != nil {
+			return fmt.Errorf("initial sync failed: %v", err)
+		}
+	} else {
+		log.Printf("Resuming sync from stored token")
 	}
 
+	log.Printf("Starting Matrix sync loop...")
+
 	go func() {
 		for {
 			select {
@@ -222,35 +377,25 @@ func (c *Client) ListenForMessages(ctx context.Context) error {
 				return
 			default:
 				log.Printf("Starting Matrix continuous sync")
-				err := c.client.Sync()
+				err := c.client.SyncWithContext(ctx)
 
-				if err != nil &&
-					(strings.Contains(err.Error(), "M_UNKNOWN_TOKEN") ||
-						strings.Contains(err.Error(), "Invalid access token") ||
-						strings.Contains(err.Error(), "401")) {
+				if errors.Is(err, mautrix.MUnknownToken) ||
+					errors.Is(err, mautrix.MMissingToken) {
 					log.Printf(
 						"AUTHENTICATION ERROR: %v - clearing sync token and exiting sync loop",
 						err,
 					)
-					c.syncToken = ""
-					if saveErr := saveSyncToken(c.config.SyncTokenFile, ""); saveErr != nil {
-						log.Printf("WARNING: Failed to clear sync token file: %v", saveErr)
+					c.syncStore.SaveNextBatch(c.userID, "")
+					if clearErr := clearSession(c.config.SessionFile); clearErr != nil {
+						log.Printf("WARNING: Failed to clear session file: %v", clearErr)
 					}
 					return
 				}
 
-				if nextBatch := c.client.Store.LoadNextBatch(""); nextBatch != "" {
-					c.syncToken = nextBatch
-					log.Printf("Saving sync token: %s for next restart", c.syncToken)
-					if saveErr := saveSyncToken(c.config.SyncTokenFile, c.syncToken); saveErr != nil {
-						log.Printf("WARNING: Failed to save sync token: %v", saveErr)
-					}
-				}
-
-				if err != nil {
+				if err != nil && ctx.Err() == nil {
 					log.Printf("Sync error: %v - will retry in 5 seconds", err)
 					time.Sleep(5 * time.Second)
-				} else {
+				} else if err == nil {
 					log.Printf("Sync completed normally, which should not happen - will restart")
 				}
 			}
@@ -260,9 +405,106 @@ func (c *Client) ListenForMessages(ctx context.Context) error {
 	return nil
 }
 
+// isInviteForBot reports whether evt invites Henry to a room.
+func (c *Client) isInviteForBot(evt *event.Event) bool {
+	if evt.Type != event.StateMember || evt.GetStateKey() != string(c.userID) {
+		return false
+	}
+	member := evt.Content.AsMember()
+	return member != nil && member.Membership == event.MembershipInvite
+}
+
+// handleInvite passes an invite to the invite handler, or joins right away
+// if there is none.
+func (c *Client) handleInvite(ctx context.Context, evt *event.Event) {
+	log.Printf("INVITE DETECTED - Received invite to room %s from %s", evt.RoomID, evt.Sender)
+	if c.inviteHandler != nil {
+		go c.inviteHandler(ctx, string(evt.RoomID), string(evt.Sender))
+		return
+	}
+	log.Printf("Attempting to join room %s", evt.RoomID)
+	if _, err := c.client.JoinRoom(string(evt.RoomID), "", nil); err != nil {
+		log.Printf("Failed to join room: %v", err)
+	} else {
+		log.Printf("Successfully joined room %s", evt.RoomID)
+	}
+}
+
+// initialSync runs a single /sync without a since token to establish a
+// starting point. Pending invites are dispatched, but timelines are
+// dropped so history from before the bot started is never answered.
+func (c *Client) initialSync(ctx context.Context) error {
+	log.Printf("No sync token stored, performing initial sync...")
+
+	filterID, err := c.ensureFilter()
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.FullSyncRequest(mautrix.ReqSync{
+		Timeout:  0,
+		FilterID: filterID,
+		Context:  ctx,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, room := range resp.Rooms.Join {
+		room.Timeline.Events = nil
+	}
+
+	if err := c.client.Syncer.ProcessResponse(resp, ""); err != nil {
+		return err
+	}
+	c.syncStore.SaveNextBatch(c.userID, resp.NextBatch)
+
+	log.Printf(
+		"Initial sync completed: %d joined rooms, %d pending invites",
+		len(resp.Rooms.Join), len(resp.Rooms.Invite),
+	)
+	return nil
+}
+
+// ensureFilter returns the ID of the uploaded sync filter, uploading it
+// first if the store has none for the current filter definition.
+func (c *Client) ensureFilter() (string, error) {
+	if filterID := c.syncStore.LoadFilterID(c.userID); filterID != "" {
+		return filterID, nil
+	}
+
+	log.Printf("Uploading sync filter")
+	resp, err := c.client.CreateFilter(syncFilter())
+	if err != nil {
+		return "", fmt.Errorf("failed to upload sync filter: %v", err)
+	}
+	c.syncStore.SaveFilterID(c.userID, resp.FilterID)
+	return resp.FilterID, nil
+}
+
+// Disconnect stops syncing but keeps the session, so the next start reuses
+// the same device. Use Logout to invalidate the access token.
 func (c *Client) Disconnect() error {
-	_, err := c.client.Logout()
-	return err
+	if c.client != nil {
+		c.client.StopSync()
+	}
+	return nil
+}
+
+// Logout invalidates the access token on the homeserver and removes the
+// stored session, which also deletes the bot's device.
+func (c *Client) Logout() error {
+	if c.isAppService() {
+		return fmt.Errorf("logout is not available in appservice mode")
+	}
+	if _, err := c.client.Logout(); err != nil {
+		return fmt.Errorf("failed to log out: %v", err)
+	}
+	c.client.ClearCredentials()
+	if err := clearSession(c.config.SessionFile); err != nil {
+		return fmt.Errorf("failed to remove session file: %v", err)
+	}
+	return nil
 }
 
 func (c *Client) JoinRoom(roomID string) error {
@@ -270,17 +512,52 @@ func (c *Client) JoinRoom(roomID string) error {
 	return err
 }
 
+// SendMessage queues a text message and waits for it to be delivered.
+// Transient failures are retried by the outbox with the same transaction
+// ID, so a rate limit or network blip doesn't lose the message.
+//
+// Very long messages are uploaded as a Markdown file with an excerpt,
+// long ones are split into numbered parts, and large code blocks can be
+// sent as separate files, depending on configuration.
 func (c *Client) SendMessage(roomID string, message string) error {
-	content := map[string]interface{}{
-		"msgtype": "m.text",
-		"body":    message,
-	}
-	_, err := c.client.SendMessageEvent(
-		id.RoomID(roomID),
-		event.EventMessage,
-		content,
-	)
-	return err
+	return c.SendThreadMessage(roomID, "", message)
+}
+
+// SendThreadMessage sends message into the thread rooted at threadID, or
+// to the main timeline if threadID is empty.
+func (c *Client) SendThreadMessage(roomID, threadID string, message string) error {
+	d := c.newDelivery(roomID)
+	if c.config.AttachmentThreshold > 0 && len(message) > c.config.AttachmentThreshold {
+		sent, err := c.sendAsAttachment(d, threadID, message)
+		if err == nil {
+			return d.result()
+		}
+		if sent {
+			return fmt.Errorf("failed to attach the full answer: %v", err)
+		}
+		log.Printf("Failed to send answer as attachment, splitting instead: %v", err)
+	}
+
+	text, blocks := message, []codeBlock(nil)
+	if c.config.CodeAttachmentThreshold > 0 {
+		text, blocks = extractCodeBlocks(message, c.config.CodeAttachmentThreshold)
+	}
+
+	if err := c.sendText(d, threadID, text); err != nil {
+		return err
+	}
+
+	for i, block := range blocks {
+		name := block.fileName(i + 1)
+		if err := c.sendFile(d, threadID, name, "text/plain", []byte(block.code)); err != nil {
+			log.Printf("Failed to attach %s, sending it inline: %v", name, err)
+			inline := "```" + block.language + "\n" + block.code + "\n```"
+			if err := c.sendText(d, threadID, inline); err != nil {
+				return err
+			}
+		}
+	}
+	return d.result()
 }
 
 func (c *Client) GetRoomContext(
@@ -388,14 +665,6 @@ func (c *Client) GetRoomContext(
 	return filteredEvents, nil
 }
 
-func (c *Client) IsFromAllowedDomain(userID string) bool {
-	parts := strings.Split(userID, ":")
-	if len(parts) != 2 {
-		return false
-	}
-	return parts[1] == c.allowedDomain
-}
-
 func (c *Client) IsAddressedToBot(content string, roomType domain.RoomType) bool {
 	if roomType == domain.DirectRoom {
 		return true
@@ -0,0 +1,83 @@
+package matrix
+
+import (
+	"fmt"
+	"log"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// EnsureDirectRoom returns a joined direct chat with userID, creating one
+// and recording it in m.direct if none exists yet.
+func (c *Client) EnsureDirectRoom(userID string) (string, error) {
+	c.directMu.Lock()
+	defer c.directMu.Unlock()
+
+	direct := event.DirectChatsEventContent{}
+	if err := c.client.GetAccountData(event.AccountDataDirectChats.Type, &direct); err != nil {
+		log.Printf("No m.direct account data yet: %v", err)
+		direct = event.DirectChatsEventContent{}
+	}
+
+	if rooms := direct[id.UserID(userID)]; len(rooms) > 0 {
+		joined, err := c.client.JoinedRooms()
+		if err != nil {
+			return "", fmt.Errorf("failed to list joined rooms: %v", err)
+		}
+		for _, roomID := range rooms {
+			for _, joinedID := range joined.JoinedRooms {
+				if roomID == joinedID {
+					return string(roomID), nil
+				}
+			}
+		}
+	}
+
+	log.Printf("Creating direct chat with %s", userID)
+	roomID, err := c.CreateRoom("", "", []string{userID}, true)
+	if err != nil {
+		return "", err
+	}
+
+	direct[id.UserID(userID)] = append(direct[id.UserID(userID)], id.RoomID(roomID))
+	if err := c.client.SetAccountData(event.AccountDataDirectChats.Type, direct); err != nil {
+		log.Printf("WARNING: Failed to update m.direct: %v", err)
+	}
+	return roomID, nil
+}
+
+// SendPrompt sends a message and adds the given reactions to it, so the
+// recipient can answer by tapping one. It returns the message's event ID.
+func (c *Client) SendPrompt(roomID string, message string, reactions []string) (string, error) {
+	content := map[string]interface{}{
+		"msgtype": "m.text",
+		"body":    message,
+	}
+	eventID, err := c.outbox.send(roomID, event.EventMessage, content)
+	if err != nil {
+		return "", err
+	}
+
+	for _, key := range reactions {
+		reaction := map[string]interface{}{
+			"m.relates_to": map[string]interface{}{
+				"rel_type": "m.annotation",
+				"event_id": string(eventID),
+				"key":      key,
+			},
+		}
+		if _, err := c.outbox.send(roomID, event.EventReaction, reaction); err != nil {
+			log.Printf("Failed to add reaction %s to prompt: %v", key, err)
+		}
+	}
+	return string(eventID), nil
+}
+
+// RejectInvite declines a pending invitation to roomID.
+func (c *Client) RejectInvite(roomID string) error {
+	if _, err := c.client.LeaveRoom(id.RoomID(roomID)); err != nil {
+		return fmt.Errorf("failed to reject invite: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package matrix
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	// syncTimelineLimit caps how many timeline events a room returns per
+	// sync. Henry only reacts to new messages, so a gappy timeline is fine.
+	syncTimelineLimit = 20
+)
+
+// handledEventTypes lists the room events the bot acts on. Everything else
+// is filtered out on the server before it reaches us.
+var handledEventTypes = []event.Type{
+	event.EventMessage,
+	event.EventReaction,
+	event.EventRedaction,
+	event.StateMember,
+	event.StateRoomName,
+	event.StateCanonicalAlias,
+	event.StateTopic,
+	event.StatePinnedEvents,
+}
+
+// handledStateTypes lists the state the bot keeps track of.
+var handledStateTypes = []event.Type{
+	event.StateMember,
+	event.StateRoomName,
+	event.StateCanonicalAlias,
+	event.StateTopic,
+	event.StatePinnedEvents,
+}
+
+// syncFilter builds the server-side filter used for every /sync request.
+// Presence and room account data are dropped entirely, read receipts are
+// the only ephemeral events kept, and member events are lazy-loaded, so
+// large rooms don't send their full member list.
+func syncFilter() *mautrix.Filter {
+	return &mautrix.Filter{
+		EventFormat: mautrix.EventFormatClient,
+		Presence: mautrix.FilterPart{
+			NotTypes: []event.Type{{Type: "*"}},
+		},
+		AccountData: mautrix.FilterPart{
+			NotTypes: []event.Type{{Type: "*"}},
+		},
+		Room: mautrix.RoomFilter{
+			AccountData: mautrix.FilterPart{
+				NotTypes: []event.Type{{Type: "*"}},
+			},
+			Ephemeral: mautrix.FilterPart{
+				Types: []event.Type{event.EphemeralEventReceipt},
+			},
+			State: mautrix.FilterPart{
+				Types:           handledStateTypes,
+				LazyLoadMembers: true,
+			},
+			Timeline: mautrix.FilterPart{
+				Types:           handledEventTypes,
+				Limit:           syncTimelineLimit,
+				LazyLoadMembers: true,
+			},
+		},
+	}
+}
+
+// filterHash fingerprints a filter so a stored filter ID is only reused
+// while the filter definition is unchanged.
+func filterHash(filter *mautrix.Filter) string {
+	data, err := json.Marshal(filter)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
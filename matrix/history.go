@@ -0,0 +1,143 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/huhndev/gohenry/domain"
+)
+
+// historyPageSize is how many events are requested per /messages call when
+// paging back through a room.
+const historyPageSize = 100
+
+// historyThreadPages bounds how far back a thread is searched for, since
+// its messages are mixed with the rest of the room and a thread that
+// started long ago would otherwise page through the whole room.
+const historyThreadPages = 20
+
+// GetRoomHistory pages back through a room and returns up to limit text
+// messages, oldest first. Paging stops at messages older than since (in ms,
+// 0 for no bound). With threadID set, only that thread is returned, as
+// far as it is found within historyThreadPages pages.
+// Messages carry the sender's display name.
+func (c *Client) GetRoomHistory(
+	ctx context.Context,
+	roomID, threadID string,
+	since int64,
+	limit int,
+) ([]*domain.Message, error) {
+	filter := mautrix.FilterPart{
+		Types:           []event.Type{event.EventMessage},
+		LazyLoadMembers: true,
+	}
+	names := make(map[string]string)
+
+	var messages []*domain.Message
+	from := ""
+	for page := 1; len(messages) < limit; page++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		resp, err := c.client.Messages(
+			id.RoomID(roomID), from, "", mautrix.DirectionBackward, &filter, historyPageSize,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch room history: %v", err)
+		}
+
+		for _, evt := range resp.State {
+			if evt.Type != event.StateMember {
+				continue
+			}
+			if name, _ := evt.Content.Raw["displayname"].(string); name != "" {
+				names[evt.GetStateKey()] = name
+			}
+		}
+
+		reachedSince := false
+		for _, evt := range resp.Chunk {
+			if since > 0 && evt.Timestamp < since {
+				reachedSince = true
+				break
+			}
+			body := messageBody(evt)
+			if body == "" {
+				continue
+			}
+			msg := &domain.Message{
+				ID:        string(evt.ID),
+				RoomID:    roomID,
+				SenderID:  string(evt.Sender),
+				Content:   body,
+				Timestamp: evt.Timestamp,
+				IsFromBot: evt.Sender == c.userID,
+				ThreadID:  ThreadID(evt),
+			}
+			if threadID != "" && msg.ThreadID != threadID && msg.ID != threadID {
+				continue
+			}
+			messages = append(messages, msg)
+			if len(messages) >= limit {
+				break
+			}
+		}
+
+		if reachedSince || resp.End == "" || len(resp.Chunk) == 0 {
+			break
+		}
+		if threadID != "" && page >= historyThreadPages {
+			log.Printf("Stopped looking for thread %s in room %s after %d pages", threadID, roomID, page)
+			break
+		}
+		from = resp.End
+	}
+
+	log.Printf("Fetched %d history messages from room %s", len(messages), roomID)
+
+	c.fillDisplayNames(roomID, messages, names)
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// fillDisplayNames sets SenderName from the lazy-loaded member events,
+// falling back to the current member list and finally the user ID.
+func (c *Client) fillDisplayNames(roomID string, messages []*domain.Message, names map[string]string) {
+	for _, msg := range messages {
+		name, ok := names[msg.SenderID]
+		if !ok {
+			name = c.DisplayName(roomID, msg.SenderID)
+			names[msg.SenderID] = name
+		}
+		msg.SenderName = name
+	}
+}
+
+// messageBody returns the text of a message event, or "" if it has none.
+func messageBody(evt *event.Event) string {
+	if content, ok := evt.Content.Parsed.(*event.MessageEventContent); ok && content != nil {
+		return content.Body
+	}
+	body, _ := evt.Content.Raw["body"].(string)
+	return body
+}
+
+// userPermalink returns a matrix.to link to a user, as used in pills.
+func userPermalink(userID string) string {
+	return "https://matrix.to/#/" + userID
+}
+
+// Permalink returns a matrix.to link to an event.
+func Permalink(roomID, eventID string) string {
+	return "https://matrix.to/#/" + url.PathEscape(roomID) + "/" + url.PathEscape(eventID)
+}
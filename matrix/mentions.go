@@ -0,0 +1,177 @@
+package matrix
+
+import (
+	"html"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// codeSpans matches fenced and inline code, where names are left alone.
+var codeSpans = regexp.MustCompile("(?s)```.*?```|`[^`\n]*`")
+
+// roomMention is the legacy mass mention. Without permission it's
+// defused with a word joiner, so it doesn't notify anyone.
+const (
+	roomMention        = "@room"
+	defusedRoomMention = "@⁠room"
+)
+
+// minBareNameLength is the shortest display name linked without an "@".
+// Shorter names like "Max", "Will" or "Jo" are too often ordinary words.
+const minBareNameLength = 5
+
+// mentions is the result of linking a message's references to members.
+type mentions struct {
+	body          string
+	formattedBody string
+	userIDs       []string
+	room          bool
+}
+
+// mentionTarget is one way of referring to a member.
+type mentionTarget struct {
+	text   string
+	userID string
+	// name is the pill's text.
+	name string
+}
+
+// linkMentions finds references to room members in text, by user ID or
+// by display name, and turns them into pills. Short display names are
+// only linked as "@Name". Display names shared by several members are
+// ambiguous and left alone, as is code. A message
+// naming more than MaxMentions members is a mass ping and stays plain
+// text without any mentions.
+func (c *Client) linkMentions(roomID, text string) mentions {
+	targets := mentionTargets(c.roomMembers(roomID), string(c.userID))
+
+	result := mentions{body: text}
+	var formatted strings.Builder
+	seen := make(map[string]bool)
+	linked := false
+
+	prose := func(segment string) string {
+		var body strings.Builder
+		for i := 0; i < len(segment); {
+			if atWordStart(segment, i) {
+				if strings.HasPrefix(segment[i:], roomMention) && atWordEnd(segment, i+len(roomMention)) {
+					if c.config.AllowRoomMentions {
+						result.room = true
+						body.WriteString(roomMention)
+						formatted.WriteString(roomMention)
+					} else {
+						body.WriteString(defusedRoomMention)
+						formatted.WriteString(defusedRoomMention)
+					}
+					i += len(roomMention)
+					continue
+				}
+				if target, n := matchTarget(segment[i:], targets); n > 0 && atWordEnd(segment, i+n) {
+					linked = true
+					if !seen[target.userID] {
+						seen[target.userID] = true
+						result.userIDs = append(result.userIDs, target.userID)
+					}
+					formatted.WriteString(`<a href="` + html.EscapeString(userPermalink(target.userID)) + `">` +
+						html.EscapeString(target.name) + `</a>`)
+					body.WriteString(segment[i : i+n])
+					i += n
+					continue
+				}
+			}
+			_, size := utf8.DecodeRuneInString(segment[i:])
+			body.WriteString(segment[i : i+size])
+			formatted.WriteString(html.EscapeString(segment[i : i+size]))
+			i += size
+		}
+		return body.String()
+	}
+
+	var body strings.Builder
+	last := 0
+	for _, span := range codeSpans.FindAllStringIndex(text, -1) {
+		body.WriteString(prose(text[last:span[0]]))
+		body.WriteString(text[span[0]:span[1]])
+		formatted.WriteString(html.EscapeString(text[span[0]:span[1]]))
+		last = span[1]
+	}
+	body.WriteString(prose(text[last:]))
+
+	result.body = body.String()
+	if len(result.userIDs) > c.config.MaxMentions {
+		log.Printf("Not mentioning %d members in room %s, the limit is %d",
+			len(result.userIDs), roomID, c.config.MaxMentions)
+		result.userIDs = nil
+		return result
+	}
+	if linked {
+		result.formattedBody = strings.ReplaceAll(formatted.String(), "\n", "<br>")
+	}
+	return result
+}
+
+// mentionTargets lists the ways members can be referred to, longest
+// first, so "Anna Schmidt" wins over "Anna".
+func mentionTargets(members map[string]string, botID string) []mentionTarget {
+	nameCount := make(map[string]int)
+	for _, name := range members {
+		nameCount[name]++
+	}
+
+	var targets []mentionTarget
+	for userID, name := range members {
+		if userID == botID {
+			continue
+		}
+		if name == "" {
+			name = userID
+		}
+		targets = append(targets, mentionTarget{text: userID, userID: userID, name: name})
+		if length := len([]rune(name)); length >= 2 && nameCount[name] == 1 && name != userID {
+			targets = append(targets, mentionTarget{text: "@" + name, userID: userID, name: name})
+			if length >= minBareNameLength {
+				targets = append(targets, mentionTarget{text: name, userID: userID, name: name})
+			}
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if len(targets[i].text) != len(targets[j].text) {
+			return len(targets[i].text) > len(targets[j].text)
+		}
+		return targets[i].text < targets[j].text
+	})
+	return targets
+}
+
+func matchTarget(text string, targets []mentionTarget) (mentionTarget, int) {
+	for _, target := range targets {
+		if strings.HasPrefix(text, target.text) {
+			return target, len(target.text)
+		}
+	}
+	return mentionTarget{}, 0
+}
+
+func atWordStart(text string, i int) bool {
+	if i == 0 {
+		return true
+	}
+	r, _ := utf8.DecodeLastRuneInString(text[:i])
+	return !isWordRune(r)
+}
+
+func atWordEnd(text string, i int) bool {
+	if i >= len(text) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(text[i:])
+	return !isWordRune(r)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
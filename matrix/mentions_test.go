@@ -0,0 +1,109 @@
+package matrix
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/huhndev/gohenry/config"
+)
+
+func TestLinkMentionsCap(t *testing.T) {
+	c := &Client{
+		userID:     "@henry:example.org",
+		config:     &config.Config{MaxMentions: 3},
+		roomStates: newRoomStateCache(),
+	}
+	members := make(map[string]string)
+	var names []string
+	for i := 1; i <= 4; i++ {
+		name := fmt.Sprintf("Member%d", i)
+		members[fmt.Sprintf("@member%d:example.org", i)] = name
+		names = append(names, name)
+	}
+	c.roomStates.members["!room:example.org"] = members
+
+	few := "Thanks " + strings.Join(names[:3], ", ") + "!"
+	m := c.linkMentions("!room:example.org", few)
+	if len(m.userIDs) != 3 || m.formattedBody == "" {
+		t.Errorf("%d mentions at the cap weren't linked: %+v", len(m.userIDs), m)
+	}
+
+	many := "Thanks " + strings.Join(names, ", ") + " @room!"
+	m = c.linkMentions("!room:example.org", many)
+	if len(m.userIDs) != 0 || m.formattedBody != "" || m.room {
+		t.Errorf("mass ping mentions members: %+v", m)
+	}
+	if want := strings.Replace(many, roomMention, defusedRoomMention, 1); m.body != want {
+		t.Errorf("body = %q, want %q", m.body, want)
+	}
+}
+
+func TestLinkMentions(t *testing.T) {
+	members := map[string]string{
+		"@henry:example.org":  "Henry",
+		"@anna:example.org":   "Anna",
+		"@max:example.org":    "Max",
+		"@jonas:example.org":  "Jonas",
+		"@claire:example.org": "Claire Dupont",
+		"@sam1:example.org":   "Sam Taylor",
+		"@sam2:example.org":   "Sam Taylor",
+	}
+	tests := []struct {
+		name      string
+		text      string
+		allowRoom bool
+		wantIDs   []string
+		wantRoom  bool
+		wantBody  string
+	}{
+		{name: "long name", text: "Thanks Jonas!", wantIDs: []string{"@jonas:example.org"}},
+		{name: "short name as a word", text: "Ask Max about it, will you?"},
+		{name: "short name with @", text: "Ask @Max about it", wantIDs: []string{"@max:example.org"}},
+		{name: "full name wins", text: "Claire Dupont and Jonas", wantIDs: []string{"@claire:example.org", "@jonas:example.org"}},
+		{name: "user ID", text: "cc @anna:example.org", wantIDs: []string{"@anna:example.org"}},
+		{name: "word boundaries", text: "Annabel, @Annabel and Jonasson"},
+		{name: "ambiguous name", text: "Sam Taylor and @Sam Taylor"},
+		{name: "ambiguous name by ID", text: "Sam Taylor (@sam2:example.org)", wantIDs: []string{"@sam2:example.org"}},
+		{name: "code", text: "Run `Jonas` or\n```\n@Max\n```"},
+		{name: "the bot", text: "Henry can help"},
+		{name: "room mention allowed", text: "@room deploy is done", allowRoom: true, wantRoom: true},
+		{
+			name: "room mention defused", text: "@room deploy is done",
+			wantBody: defusedRoomMention + " deploy is done",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{
+				userID:     "@henry:example.org",
+				config:     &config.Config{MaxMentions: 10, AllowRoomMentions: tt.allowRoom},
+				roomStates: newRoomStateCache(),
+			}
+			c.roomStates.members["!room:example.org"] = members
+
+			m := c.linkMentions("!room:example.org", tt.text)
+			if !reflect.DeepEqual(m.userIDs, tt.wantIDs) {
+				t.Errorf("mentioned %v, want %v", m.userIDs, tt.wantIDs)
+			}
+			if m.room != tt.wantRoom {
+				t.Errorf("room mention = %v, want %v", m.room, tt.wantRoom)
+			}
+			content := mentionsContent(m)
+			if _, room := content["room"]; room != tt.wantRoom {
+				t.Errorf("m.mentions = %v", content)
+			}
+			wantBody := tt.wantBody
+			if wantBody == "" {
+				wantBody = tt.text
+			}
+			if m.body != wantBody {
+				t.Errorf("body = %q, want %q", m.body, wantBody)
+			}
+			if (m.formattedBody != "") != (len(tt.wantIDs) > 0) {
+				t.Errorf("formatted body = %q", m.formattedBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package matrix
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/store"
+)
+
+const (
+	queueInitialBackoff = 2 * time.Second
+	queueMaxBackoff     = 5 * time.Minute
+)
+
+// queueSendTimeout is how long a caller waits for delivery before it gets
+// domain.ErrStillQueued. The message stays queued. Tests shorten it.
+var queueSendTimeout = 2 * time.Minute
+
+var errQueueStopped = errors.New("send queue stopped, message will be retried after restart")
+
+// outboundMessage is an event waiting to be sent. The transaction ID is
+// fixed when the message is queued and reused on every attempt, so the
+// homeserver deduplicates resends of an event that already arrived.
+type outboundMessage struct {
+	TxnID     string                 `json:"txn_id"`
+	RoomID    string                 `json:"room_id"`
+	EventType string                 `json:"event_type"`
+	Content   map[string]interface{} `json:"content"`
+	QueuedAt  int64                  `json:"queued_at"`
+	Attempts  int                    `json:"attempts"`
+
+	done chan sendResult
+}
+
+type sendResult struct {
+	eventID id.EventID
+	err     error
+}
+
+// outbox delivers outgoing events with one worker per room, which keeps
+// messages to a room in order while other rooms continue independently.
+// Undelivered messages are persisted and picked up again after a restart.
+type outbox struct {
+	file *store.File
+
+	mu      sync.Mutex
+	client  *mautrix.Client
+	ctx     context.Context
+	rooms   map[string][]*outboundMessage
+	running map[string]bool
+}
+
+func newOutbox(file *store.File) *outbox {
+	q := &outbox{
+		file:    file,
+		rooms:   make(map[string][]*outboundMessage),
+		running: make(map[string]bool),
+	}
+	if err := file.Load(&q.rooms); err != nil {
+		log.Printf("WARNING: Failed to load send queue: %v", err)
+	}
+	return q
+}
+
+// start begins delivering with the given client, including any messages
+// left over from a previous run.
+func (q *outbox) start(ctx context.Context, client *mautrix.Client) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.client = client
+	q.ctx = ctx
+	for roomID, pending := range q.rooms {
+		if len(pending) == 0 {
+			continue
+		}
+		log.Printf("Resuming %d queued messages for room %s", len(pending), roomID)
+		q.ensureWorkerLocked(roomID)
+	}
+}
+
+// send queues an event and waits until it is delivered or rejected. If
+// that takes longer than queueSendTimeout, domain.ErrStillQueued is
+// returned, so a homeserver outage doesn't block callers such as scheduled
+// jobs for good. The message stays queued and persisted either way, and
+// goes out once the homeserver is back, after a restart if need be.
+func (q *outbox) send(
+	roomID string,
+	eventType event.Type,
+	content map[string]interface{},
+) (id.EventID, error) {
+	done, err := q.enqueue(roomID, eventType, content)
+	if err != nil {
+		return "", err
+	}
+
+	timer := time.NewTimer(queueSendTimeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		return res.eventID, res.err
+	case <-timer.C:
+		log.Printf("Homeserver hasn't taken a message to room %s after %s, leaving it queued", roomID, queueSendTimeout)
+		return "", domain.ErrStillQueued
+	}
+}
+
+// enqueue adds an event to the room's queue without waiting for it. The
+// returned channel receives the outcome.
+func (q *outbox) enqueue(
+	roomID string,
+	eventType event.Type,
+	content map[string]interface{},
+) (<-chan sendResult, error) {
+	msg := &outboundMessage{
+		TxnID:     newTxnID(),
+		RoomID:    roomID,
+		EventType: eventType.Type,
+		Content:   content,
+		QueuedAt:  time.Now().UnixNano() / 1e6,
+		done:      make(chan sendResult, 1),
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.client == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	q.rooms[roomID] = append(q.rooms[roomID], msg)
+	q.persistLocked()
+	if q.ctx.Err() != nil {
+		return nil, errQueueStopped
+	}
+	q.ensureWorkerLocked(roomID)
+	// abandon clears msg.done under the lock, so callers keep their own.
+	return msg.done, nil
+}
+
+func (q *outbox) ensureWorkerLocked(roomID string) {
+	if q.running[roomID] {
+		return
+	}
+	q.running[roomID] = true
+	go q.deliver(roomID)
+}
+
+func (q *outbox) deliver(roomID string) {
+	backoff := queueInitialBackoff
+
+	for {
+		q.mu.Lock()
+		pending := q.rooms[roomID]
+		if len(pending) == 0 {
+			delete(q.running, roomID)
+			q.mu.Unlock()
+			return
+		}
+		msg := pending[0]
+		msg.Attempts++
+		q.mu.Unlock()
+
+		resp, err := q.client.SendMessageEvent(
+			id.RoomID(roomID),
+			event.Type{Type: msg.EventType, Class: event.MessageEventType},
+			msg.Content,
+			mautrix.ReqSendEvent{TransactionID: msg.TxnID},
+		)
+
+		if err != nil && !isPermanentSendError(err) {
+			wait := retryDelay(err, backoff)
+			log.Printf(
+				"Sending to room %s failed (attempt %d): %v - retrying in %s",
+				roomID, msg.Attempts, err, wait,
+			)
+
+			q.mu.Lock()
+			q.persistLocked()
+			q.mu.Unlock()
+
+			select {
+			case <-q.ctx.Done():
+				q.abandon(roomID)
+				return
+			case <-time.After(wait):
+			}
+
+			backoff *= 2
+			if backoff > queueMaxBackoff {
+				backoff = queueMaxBackoff
+			}
+			continue
+		}
+
+		q.mu.Lock()
+		q.removeLocked(msg)
+		q.persistLocked()
+		done := msg.done
+		q.mu.Unlock()
+
+		var result sendResult
+		if err != nil {
+			log.Printf("Dropping message to room %s after permanent error: %v", roomID, err)
+			result.err = err
+		} else {
+			result.eventID = resp.EventID
+		}
+		if done != nil {
+			done <- result
+		}
+		backoff = queueInitialBackoff
+	}
+}
+
+// removeLocked takes a message out of its room's queue.
+func (q *outbox) removeLocked(msg *outboundMessage) {
+	pending := q.rooms[msg.RoomID]
+	for i, m := range pending {
+		if m != msg {
+			continue
+		}
+		q.rooms[msg.RoomID] = append(pending[:i:i], pending[i+1:]...)
+		if len(q.rooms[msg.RoomID]) == 0 {
+			delete(q.rooms, msg.RoomID)
+		}
+		return
+	}
+}
+
+// abandon releases everyone waiting on a room's queue when the bot shuts
+// down. The messages stay persisted and are sent on the next start.
+func (q *outbox) abandon(roomID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.running, roomID)
+	for _, msg := range q.rooms[roomID] {
+		if msg.done != nil {
+			msg.done <- sendResult{err: errQueueStopped}
+			msg.done = nil
+		}
+	}
+}
+
+func (q *outbox) persistLocked() {
+	if err := q.file.Save(q.rooms); err != nil {
+		log.Printf("WARNING: Failed to persist send queue: %v", err)
+	}
+}
+
+// isPermanentSendError reports whether retrying can't help: the server
+// understood the request and refused it. Rate limits, gateway errors and
+// network failures are all worth another attempt.
+func isPermanentSendError(err error) bool {
+	var httpErr mautrix.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.Response == nil {
+		return false
+	}
+	status := httpErr.Response.StatusCode
+	if status == http.StatusTooManyRequests || status >= 500 {
+		return false
+	}
+	return status >= 400
+}
+
+// retryDelay honours the retry_after_ms hint from M_LIMIT_EXCEEDED errors
+// and falls back to the caller's backoff otherwise.
+func retryDelay(err error, fallback time.Duration) time.Duration {
+	var httpErr mautrix.HTTPError
+	if errors.As(err, &httpErr) && httpErr.RespError != nil {
+		if ms, ok := httpErr.RespError.ExtraData["retry_after_ms"].(float64); ok && ms > 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+	}
+	return fallback
+}
+
+func newTxnID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("henry-%d", time.Now().UnixNano())
+	}
+	return fmt.Sprintf("henry-%d-%s", time.Now().UnixNano(), hex.EncodeToString(buf))
+}
@@ -0,0 +1,231 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/store"
+)
+
+const testQueueRoom = "!room:example.org"
+
+// testHomeserver records the bodies of sent events in order and answers
+// each with the status its respond func returns for it.
+type testHomeserver struct {
+	mu      sync.Mutex
+	bodies  []string
+	txnIDs  []string
+	respond func(body string, attempt int) int
+	server  *httptest.Server
+}
+
+func newTestHomeserver(t *testing.T, respond func(body string, attempt int) int) *testHomeserver {
+	hs := &testHomeserver{respond: respond}
+	hs.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var content struct {
+			Body string `json:"body"`
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &content)
+
+		hs.mu.Lock()
+		attempt := 1
+		for _, b := range hs.bodies {
+			if b == content.Body {
+				attempt++
+			}
+		}
+		hs.bodies = append(hs.bodies, content.Body)
+		hs.txnIDs = append(hs.txnIDs, r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+		status := hs.respond(content.Body, attempt)
+		n := len(hs.bodies)
+		hs.mu.Unlock()
+
+		w.WriteHeader(status)
+		switch status {
+		case http.StatusOK:
+			fmt.Fprintf(w, `{"event_id": "$%d"}`, n)
+		case http.StatusTooManyRequests:
+			fmt.Fprint(w, `{"errcode": "M_LIMIT_EXCEEDED", "error": "slow down", "retry_after_ms": 10}`)
+		default:
+			fmt.Fprint(w, `{"errcode": "M_UNKNOWN", "error": "failed"}`)
+		}
+	}))
+	t.Cleanup(hs.server.Close)
+	return hs
+}
+
+func (hs *testHomeserver) sent() []string {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	return append([]string(nil), hs.bodies...)
+}
+
+func (hs *testHomeserver) client(t *testing.T) *mautrix.Client {
+	client, err := mautrix.NewClient(hs.server.URL, "@henry:example.org", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func startTestOutbox(t *testing.T, hs *testHomeserver, file *store.File) *outbox {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	q := newOutbox(file)
+	q.start(ctx, hs.client(t))
+	return q
+}
+
+func textContent(body string) map[string]interface{} {
+	return map[string]interface{}{"msgtype": "m.text", "body": body}
+}
+
+// waitIdle waits until the room's worker has nothing left to send.
+func waitIdle(t *testing.T, q *outbox) {
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		q.mu.Lock()
+		idle := !q.running[testQueueRoom] && len(q.rooms[testQueueRoom]) == 0
+		q.mu.Unlock()
+		if idle {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("queue didn't drain")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRetryDelayHonoursRetryAfter(t *testing.T) {
+	limited := mautrix.HTTPError{RespError: &mautrix.RespError{
+		ErrCode:   "M_LIMIT_EXCEEDED",
+		ExtraData: map[string]interface{}{"retry_after_ms": float64(1500)},
+	}}
+	tests := []struct {
+		name string
+		err  error
+		want time.Duration
+	}{
+		{"retry_after_ms", limited, 1500 * time.Millisecond},
+		{"wrapped", fmt.Errorf("send: %w", limited), 1500 * time.Millisecond},
+		{"no hint", mautrix.HTTPError{RespError: &mautrix.RespError{ErrCode: "M_LIMIT_EXCEEDED"}}, 4 * time.Second},
+		{"network error", fmt.Errorf("connection refused"), 4 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.err, 4*time.Second); got != tt.want {
+			t.Errorf("%s: retryDelay = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQueueKeepsRoomOrderAcrossRetries(t *testing.T) {
+	hs := newTestHomeserver(t, func(body string, attempt int) int {
+		if body == "first" && attempt == 1 {
+			return http.StatusTooManyRequests
+		}
+		return http.StatusOK
+	})
+	q := startTestOutbox(t, hs, store.NewFile(t.TempDir(), "outbox.json"))
+
+	var wg sync.WaitGroup
+	for _, body := range []string{"first", "second", "third"} {
+		done, err := q.enqueue(testQueueRoom, event.EventMessage, textContent(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(body string) {
+			defer wg.Done()
+			if res := <-done; res.err != nil || res.eventID == "" {
+				t.Errorf("%s: got %+v, want delivered", body, res)
+			}
+		}(body)
+	}
+	wg.Wait()
+
+	if got := strings.Join(hs.sent(), " "); got != "first first second third" {
+		t.Errorf("homeserver saw %q, want the rate-limited first message retried before the others", got)
+	}
+	if hs.txnIDs[0] != hs.txnIDs[1] {
+		t.Errorf("retry used transaction %s, first attempt %s", hs.txnIDs[1], hs.txnIDs[0])
+	}
+}
+
+func TestQueueResumesFromFile(t *testing.T) {
+	hs := newTestHomeserver(t, func(string, int) int { return http.StatusOK })
+	file := store.NewFile(t.TempDir(), "outbox.json")
+	pending := map[string][]*outboundMessage{testQueueRoom: {
+		{TxnID: "henry-1", RoomID: testQueueRoom, EventType: "m.room.message", Content: textContent("left over")},
+		{TxnID: "henry-2", RoomID: testQueueRoom, EventType: "m.room.message", Content: textContent("also left")},
+	}}
+	if err := file.Save(pending); err != nil {
+		t.Fatal(err)
+	}
+
+	q := startTestOutbox(t, hs, file)
+	waitIdle(t, q)
+
+	if got := strings.Join(hs.sent(), ", "); got != "left over, also left" {
+		t.Errorf("homeserver saw %q", got)
+	}
+	if strings.Join(hs.txnIDs, " ") != "henry-1 henry-2" {
+		t.Errorf("resumed with transactions %v, want the stored ones", hs.txnIDs)
+	}
+	var saved map[string][]*outboundMessage
+	if err := file.Load(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 0 {
+		t.Errorf("delivered messages still saved: %v", saved)
+	}
+}
+
+func TestSendTimeoutLeavesMessageQueued(t *testing.T) {
+	defer func(timeout time.Duration) { queueSendTimeout = timeout }(queueSendTimeout)
+	queueSendTimeout = 50 * time.Millisecond
+
+	var mu sync.Mutex
+	down := true
+	hs := newTestHomeserver(t, func(string, int) int {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			return http.StatusTooManyRequests
+		}
+		return http.StatusOK
+	})
+	file := store.NewFile(t.TempDir(), "outbox.json")
+	q := startTestOutbox(t, hs, file)
+
+	if _, err := q.send(testQueueRoom, event.EventMessage, textContent("answer")); err != domain.ErrStillQueued {
+		t.Fatalf("send during an outage returned %v, want ErrStillQueued", err)
+	}
+	var saved map[string][]*outboundMessage
+	if err := file.Load(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved[testQueueRoom]) != 1 {
+		t.Fatalf("timed-out message not persisted: %v", saved)
+	}
+
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	waitIdle(t, q)
+	if sent := hs.sent(); sent[len(sent)-1] != "answer" {
+		t.Errorf("message wasn't delivered after the outage: %v", sent)
+	}
+}
@@ -41,6 +41,20 @@ func (c *Client) GetRoomType(
 	return domain.GroupRoom, nil
 }
 
+// GetRoomName returns the room's name, its canonical alias, or its ID.
+func (c *Client) GetRoomName(roomID string) string {
+	return displayRoomName(roomID, c.roomState(roomID))
+}
+
+// GetUserPowerLevel returns userID's power level in roomID.
+func (c *Client) GetUserPowerLevel(roomID string, userID string) (int, error) {
+	var levels event.PowerLevelsEventContent
+	if err := c.client.StateEvent(id.RoomID(roomID), event.StatePowerLevels, "", &levels); err != nil {
+		return 0, fmt.Errorf("failed to get power levels: %v", err)
+	}
+	return levels.GetUserLevel(id.UserID(userID)), nil
+}
+
 func (c *Client) CheckAndJoinInvitedRooms(ctx context.Context) error {
 	log.Printf("Checking for existing room invitations...")
 
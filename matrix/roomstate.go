@@ -0,0 +1,273 @@
+package matrix
+
+import (
+	"log"
+	"sync"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/huhndev/gohenry/domain"
+)
+
+// maxPinnedMessages is how many of the most recently pinned messages are
+// read.
+const maxPinnedMessages = 10
+
+// roomState caches the name, alias, topic and pinned events of rooms.
+// Rooms are loaded from the server on first use and kept current through
+// the state events in sync.
+type roomState struct {
+	name, alias, topic string
+	topicSender        string
+	pinned             []string
+}
+
+type roomStateCache struct {
+	mu     sync.Mutex
+	rooms  map[string]*roomState
+	pinned map[string]domain.PinnedMessage
+	// members maps room and user ID to display name.
+	members map[string]map[string]string
+}
+
+func newRoomStateCache() *roomStateCache {
+	return &roomStateCache{
+		rooms:   make(map[string]*roomState),
+		pinned:  make(map[string]domain.PinnedMessage),
+		members: make(map[string]map[string]string),
+	}
+}
+
+// updateRoomState applies a state event from sync to a cached room.
+func (c *Client) updateRoomState(evt *event.Event) {
+	switch evt.Type {
+	case event.StateRoomName, event.StateCanonicalAlias, event.StateTopic, event.StatePinnedEvents:
+	case event.StateMember:
+		c.updateMember(evt)
+		return
+	case event.EventRedaction:
+		c.roomStates.forgetPinned(redactedEventID(evt))
+		return
+	case event.EventMessage:
+		c.roomStates.applyEdit(evt)
+		return
+	default:
+		return
+	}
+
+	c.roomStates.mu.Lock()
+	defer c.roomStates.mu.Unlock()
+	state, ok := c.roomStates.rooms[string(evt.RoomID)]
+	if !ok {
+		return
+	}
+	state.apply(evt)
+}
+
+// apply updates the state from a name, alias, topic or pinned event.
+func (state *roomState) apply(evt *event.Event) {
+	_ = evt.Content.ParseRaw(evt.Type)
+	switch content := evt.Content.Parsed.(type) {
+	case *event.RoomNameEventContent:
+		state.name = content.Name
+	case *event.CanonicalAliasEventContent:
+		state.alias = string(content.Alias)
+	case *event.TopicEventContent:
+		state.topic = content.Topic
+		state.topicSender = string(evt.Sender)
+	case *event.PinnedEventsEventContent:
+		state.pinned = pinnedIDs(content)
+	}
+}
+
+// updateMember applies a member event to a cached member list.
+func (c *Client) updateMember(evt *event.Event) {
+	c.roomStates.mu.Lock()
+	defer c.roomStates.mu.Unlock()
+	members, ok := c.roomStates.members[string(evt.RoomID)]
+	if !ok || evt.StateKey == nil {
+		return
+	}
+	_ = evt.Content.ParseRaw(evt.Type)
+	member := evt.Content.AsMember()
+	if member.Membership != event.MembershipJoin {
+		delete(members, *evt.StateKey)
+		return
+	}
+	members[*evt.StateKey] = member.Displayname
+}
+
+// DisplayName returns a member's display name, or their user ID if they
+// have none.
+func (c *Client) DisplayName(roomID, userID string) string {
+	if name := c.roomMembers(roomID)[userID]; name != "" {
+		return name
+	}
+	return userID
+}
+
+// roomMembers returns the joined members of a room with their display
+// names. The list is loaded once per room and then kept current through
+// sync.
+func (c *Client) roomMembers(roomID string) map[string]string {
+	c.roomStates.mu.Lock()
+	members, ok := c.roomStates.members[roomID]
+	c.roomStates.mu.Unlock()
+
+	if !ok {
+		joined, err := c.client.JoinedMembers(id.RoomID(roomID))
+		if err != nil {
+			log.Printf("Failed to fetch members of %s: %v", roomID, err)
+			return nil
+		}
+		members = make(map[string]string, len(joined.Joined))
+		for memberID, member := range joined.Joined {
+			members[string(memberID)] = member.DisplayName
+		}
+		c.roomStates.mu.Lock()
+		c.roomStates.members[roomID] = members
+		c.roomStates.mu.Unlock()
+	}
+
+	c.roomStates.mu.Lock()
+	defer c.roomStates.mu.Unlock()
+	copied := make(map[string]string, len(members))
+	for userID, name := range members {
+		copied[userID] = name
+	}
+	return copied
+}
+
+// roomState returns the cached state of a room, loading it if needed.
+func (c *Client) roomState(roomID string) roomState {
+	c.roomStates.mu.Lock()
+	state, ok := c.roomStates.rooms[roomID]
+	c.roomStates.mu.Unlock()
+	if ok {
+		return *state
+	}
+
+	// The full state is fetched, rather than each event's content, since
+	// the topic's sender is needed too.
+	state = &roomState{}
+	stateMap, err := c.client.State(id.RoomID(roomID))
+	if err != nil {
+		log.Printf("Failed to fetch state of %s: %v", roomID, err)
+		return *state
+	}
+	for _, evtType := range []event.Type{
+		event.StateRoomName, event.StateCanonicalAlias, event.StateTopic, event.StatePinnedEvents,
+	} {
+		if evt := stateMap[evtType][""]; evt != nil {
+			state.apply(evt)
+		}
+	}
+
+	c.roomStates.mu.Lock()
+	c.roomStates.rooms[roomID] = state
+	c.roomStates.mu.Unlock()
+	return *state
+}
+
+// GetRoomInfo returns the room's name, topic and pinned messages, with
+// who sent them.
+func (c *Client) GetRoomInfo(roomID string) *domain.RoomInfo {
+	state := c.roomState(roomID)
+	info := &domain.RoomInfo{
+		Name:          displayRoomName(roomID, state),
+		Topic:         state.topic,
+		TopicSenderID: state.topicSender,
+	}
+	for _, eventID := range state.pinned {
+		if pinned := c.pinnedMessage(roomID, eventID); pinned.Text != "" {
+			info.Pinned = append(info.Pinned, pinned)
+		}
+	}
+	return info
+}
+
+// pinnedMessage returns the body and sender of a pinned message. They are
+// cached, as pinned messages rarely change; edits and redactions from sync
+// keep the cache current.
+func (c *Client) pinnedMessage(roomID, eventID string) domain.PinnedMessage {
+	c.roomStates.mu.Lock()
+	pinned, ok := c.roomStates.pinned[eventID]
+	c.roomStates.mu.Unlock()
+	if ok {
+		return pinned
+	}
+
+	evt, err := c.client.GetEvent(id.RoomID(roomID), id.EventID(eventID))
+	if err != nil {
+		log.Printf("Failed to fetch pinned event %s in %s: %v", eventID, roomID, err)
+		return domain.PinnedMessage{}
+	}
+	_ = evt.Content.ParseRaw(evt.Type)
+	pinned = domain.PinnedMessage{SenderID: string(evt.Sender), Text: messageBody(evt)}
+
+	c.roomStates.mu.Lock()
+	c.roomStates.pinned[eventID] = pinned
+	c.roomStates.mu.Unlock()
+	return pinned
+}
+
+// forgetPinned drops a cached pinned message, so a redacted one is fetched
+// again, now without its text.
+func (c *roomStateCache) forgetPinned(eventID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pinned, eventID)
+}
+
+// applyEdit puts the new text of an edited message into the cache if it's
+// a cached pinned message. Fetching the event again would return the
+// original text, so the cached entry is updated rather than dropped. Only
+// the sender's own edits count.
+func (c *roomStateCache) applyEdit(evt *event.Event) {
+	target, text := editOf(evt)
+	if target == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pinned, ok := c.pinned[target]; ok && pinned.SenderID == string(evt.Sender) {
+		pinned.Text = text
+		c.pinned[target] = pinned
+	}
+}
+
+// editOf returns the event an m.replace edit replaces and its new text.
+func editOf(evt *event.Event) (target, text string) {
+	relation, _ := evt.Content.Raw["m.relates_to"].(map[string]interface{})
+	if relation["rel_type"] != "m.replace" {
+		return "", ""
+	}
+	target, _ = relation["event_id"].(string)
+	newContent, _ := evt.Content.Raw["m.new_content"].(map[string]interface{})
+	text, _ = newContent["body"].(string)
+	return target, text
+}
+
+// pinnedIDs returns the most recently pinned event IDs.
+func pinnedIDs(content *event.PinnedEventsEventContent) []string {
+	pinned := content.Pinned
+	if len(pinned) > maxPinnedMessages {
+		pinned = pinned[len(pinned)-maxPinnedMessages:]
+	}
+	ids := make([]string, len(pinned))
+	for i, eventID := range pinned {
+		ids[i] = string(eventID)
+	}
+	return ids
+}
+
+func displayRoomName(roomID string, state roomState) string {
+	if state.name != "" {
+		return state.name
+	}
+	if state.alias != "" {
+		return state.alias
+	}
+	return roomID
+}
@@ -0,0 +1,56 @@
+package matrix
+
+import (
+	"encoding/json"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+
+	"github.com/huhndev/gohenry/domain"
+)
+
+// syncEvent parses an event the way it arrives from sync.
+func syncEvent(t *testing.T, data string) *event.Event {
+	var evt event.Event
+	if err := json.Unmarshal([]byte(data), &evt); err != nil {
+		t.Fatal(err)
+	}
+	return &evt
+}
+
+func TestPinnedCacheFollowsEditsAndRedactions(t *testing.T) {
+	c := &Client{roomStates: newRoomStateCache()}
+	c.roomStates.pinned["$rules"] = domain.PinnedMessage{SenderID: "@alice:example.org", Text: "Deploys on Fridays"}
+	c.roomStates.pinned["$faq"] = domain.PinnedMessage{SenderID: "@alice:example.org", Text: "See the wiki"}
+
+	edit := func(sender, text string) *event.Event {
+		return syncEvent(t, `{"type": "m.room.message", "room_id": "!room:example.org", "event_id": "$edit",
+			"sender": "`+sender+`", "content": {"msgtype": "m.text", "body": "* `+text+`",
+			"m.new_content": {"msgtype": "m.text", "body": "`+text+`"},
+			"m.relates_to": {"rel_type": "m.replace", "event_id": "$rules"}}}`)
+	}
+
+	c.updateRoomState(edit("@mallory:example.org", "Deploys any time"))
+	if got := c.roomStates.pinned["$rules"].Text; got != "Deploys on Fridays" {
+		t.Errorf("another user's edit changed the pinned text to %q", got)
+	}
+	c.updateRoomState(edit("@alice:example.org", "No deploys on Fridays"))
+	if got := c.roomStates.pinned["$rules"].Text; got != "No deploys on Fridays" {
+		t.Errorf("pinned text after edit = %q", got)
+	}
+
+	c.updateRoomState(syncEvent(t, `{"type": "m.room.message", "room_id": "!room:example.org", "event_id": "$reply",
+		"sender": "@alice:example.org", "content": {"msgtype": "m.text", "body": "unrelated"}}`))
+	if len(c.roomStates.pinned) != 2 {
+		t.Errorf("plain message changed the cache: %v", c.roomStates.pinned)
+	}
+
+	c.updateRoomState(syncEvent(t, `{"type": "m.room.redaction", "room_id": "!room:example.org", "event_id": "$redaction",
+		"sender": "@mod:example.org", "redacts": "$faq", "content": {}}`))
+	if _, ok := c.roomStates.pinned["$faq"]; ok {
+		t.Error("redacted pinned message still cached")
+	}
+	if _, ok := c.roomStates.pinned["$rules"]; !ok {
+		t.Error("redaction dropped another pinned message")
+	}
+}
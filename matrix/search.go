@@ -0,0 +1,133 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/huhndev/gohenry/domain"
+)
+
+// searchRequest and searchResponse are the parts of /search Henry uses.
+type searchRequest struct {
+	SearchCategories struct {
+		RoomEvents struct {
+			SearchTerm string `json:"search_term"`
+			OrderBy    string `json:"order_by"`
+			Filter     struct {
+				Rooms []string `json:"rooms,omitempty"`
+				Limit int      `json:"limit"`
+			} `json:"filter"`
+		} `json:"room_events"`
+	} `json:"search_categories"`
+}
+
+type searchResponse struct {
+	SearchCategories struct {
+		RoomEvents struct {
+			Results []struct {
+				Rank   float64      `json:"rank"`
+				Result *event.Event `json:"result"`
+			} `json:"results"`
+		} `json:"room_events"`
+	} `json:"search_categories"`
+}
+
+// SearchMessages asks the homeserver's /search for messages in the given
+// rooms. Servers without search support are not asked again.
+func (c *Client) SearchMessages(
+	ctx context.Context,
+	query string,
+	roomIDs []string,
+	limit int,
+) ([]*domain.Message, error) {
+	if c.searchUnsupported.Load() {
+		return nil, fmt.Errorf("homeserver does not support search")
+	}
+
+	var req searchRequest
+	events := &req.SearchCategories.RoomEvents
+	events.SearchTerm = query
+	events.OrderBy = "rank"
+	events.Filter.Rooms = roomIDs
+	events.Filter.Limit = limit
+
+	var resp searchResponse
+	_, err := c.client.MakeFullRequest(mautrix.FullRequest{
+		Method:       "POST",
+		URL:          c.client.BuildClientURL("v3", "search"),
+		RequestJSON:  req,
+		ResponseJSON: &resp,
+		Context:      ctx,
+	})
+	if err != nil {
+		var httpErr mautrix.HTTPError
+		if errors.Is(err, mautrix.MUnrecognized) || (errors.As(err, &httpErr) && httpErr.IsStatus(404)) {
+			log.Printf("Homeserver has no /search, using the local index only")
+			c.searchUnsupported.Store(true)
+		}
+		return nil, fmt.Errorf("search failed: %v", err)
+	}
+
+	var messages []*domain.Message
+	for _, result := range resp.SearchCategories.RoomEvents.Results {
+		evt := result.Result
+		if evt == nil {
+			continue
+		}
+		_ = evt.Content.ParseRaw(evt.Type)
+		body := messageBody(evt)
+		if body == "" {
+			continue
+		}
+		messages = append(messages, &domain.Message{
+			ID:        string(evt.ID),
+			RoomID:    string(evt.RoomID),
+			SenderID:  string(evt.Sender),
+			Content:   body,
+			Timestamp: evt.Timestamp,
+			IsFromBot: evt.Sender == c.userID,
+			ThreadID:  ThreadID(evt),
+		})
+	}
+	return messages, nil
+}
+
+// JoinedRooms returns the rooms Henry is in.
+func (c *Client) JoinedRooms() ([]string, error) {
+	resp, err := c.client.JoinedRooms()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list joined rooms: %v", err)
+	}
+	rooms := make([]string, len(resp.JoinedRooms))
+	for i, roomID := range resp.JoinedRooms {
+		rooms[i] = string(roomID)
+	}
+	return rooms, nil
+}
+
+// JoinedMembers returns the joined members of a room with the time of
+// their membership event in ms. Profile changes resend that event, so the
+// time can be later than the actual join, never earlier.
+func (c *Client) JoinedMembers(roomID string) (map[string]int64, error) {
+	resp, err := c.client.Members(id.RoomID(roomID), mautrix.ReqMembers{Membership: event.MembershipJoin})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get members of %s: %v", roomID, err)
+	}
+	members := make(map[string]int64, len(resp.Chunk))
+	for _, evt := range resp.Chunk {
+		if evt.StateKey == nil {
+			continue
+		}
+		_ = evt.Content.ParseRaw(evt.Type)
+		if evt.Content.AsMember().Membership == event.MembershipJoin {
+			members[*evt.StateKey] = evt.Timestamp
+		}
+	}
+	return members, nil
+}
@@ -32,10 +32,54 @@ func (s *Service) Disconnect() error {
 	return s.client.Disconnect()
 }
 
+func (s *Service) Logout() error {
+	return s.client.Logout()
+}
+
 func (s *Service) SetMessageHandler(handler func(ctx context.Context, evt *event.Event)) {
 	s.client.SetMessageHandler(handler)
 }
 
+func (s *Service) SetInviteHandler(handler func(ctx context.Context, roomID, inviterID string)) {
+	s.client.SetInviteHandler(handler)
+}
+
+func (s *Service) SetReactionHandler(
+	handler func(ctx context.Context, roomID, senderID, eventID, key string),
+) {
+	s.client.SetReactionHandler(handler)
+}
+
+func (s *Service) SetReceiptHandler(
+	handler func(ctx context.Context, roomID, userID, eventID string, ts int64),
+) {
+	s.client.SetReceiptHandler(handler)
+}
+
+func (s *Service) SetRedactionHandler(handler func(ctx context.Context, roomID, eventID string)) {
+	s.client.SetRedactionHandler(handler)
+}
+
+func (s *Service) GetDisplayName(roomID, userID string) string {
+	return s.client.DisplayName(roomID, userID)
+}
+
+func (s *Service) GetAccountData(eventType string, v interface{}) error {
+	return s.client.GetAccountData(eventType, v)
+}
+
+func (s *Service) SetAccountData(eventType string, v interface{}) error {
+	return s.client.SetAccountData(eventType, v)
+}
+
+func (s *Service) GetRoomInfo(roomID string) *domain.RoomInfo {
+	return s.client.GetRoomInfo(roomID)
+}
+
+func (s *Service) GetRoomName(roomID string) string {
+	return s.client.GetRoomName(roomID)
+}
+
 func (s *Service) ListenForMessages(ctx context.Context) error {
 	return s.client.ListenForMessages(ctx)
 }
@@ -48,6 +92,10 @@ func (s *Service) SendMessage(roomID string, content string) error {
 	return s.client.SendMessage(roomID, content)
 }
 
+func (s *Service) SendThreadMessage(roomID, threadID string, content string) error {
+	return s.client.SendThreadMessage(roomID, threadID, content)
+}
+
 func (s *Service) GetRoomContext(
 	ctx context.Context,
 	roomID string,
@@ -89,18 +137,54 @@ func (s *Service) GetRoomContext(
 		}
 
 		messages = append(messages, &domain.Message{
-			ID:        string(evt.ID),
-			RoomID:    string(evt.RoomID),
-			SenderID:  string(evt.Sender),
-			Content:   content.Body,
-			Timestamp: evt.Timestamp,
-			IsFromBot: string(evt.Sender) == s.client.GetBotUserID(),
+			ID:         string(evt.ID),
+			RoomID:     string(evt.RoomID),
+			SenderID:   string(evt.Sender),
+			SenderName: s.client.DisplayName(roomID, string(evt.Sender)),
+			Content:    content.Body,
+			Timestamp:  evt.Timestamp,
+			IsFromBot:  string(evt.Sender) == s.client.GetBotUserID(),
+			ThreadID:   ThreadID(evt),
 		})
 	}
 
 	return messages, nil
 }
 
+func (s *Service) GetRoomHistory(
+	ctx context.Context,
+	roomID, threadID string,
+	since int64,
+	limit int,
+) ([]*domain.Message, error) {
+	return s.client.GetRoomHistory(ctx, roomID, threadID, since, limit)
+}
+
+func (s *Service) DownloadMedia(ctx context.Context, url string) ([]byte, error) {
+	return s.client.DownloadMedia(ctx, url)
+}
+
+func (s *Service) SearchMessages(
+	ctx context.Context,
+	query string,
+	roomIDs []string,
+	limit int,
+) ([]*domain.Message, error) {
+	return s.client.SearchMessages(ctx, query, roomIDs, limit)
+}
+
+func (s *Service) JoinedRooms() ([]string, error) {
+	return s.client.JoinedRooms()
+}
+
+func (s *Service) JoinedMembers(roomID string) (map[string]int64, error) {
+	return s.client.JoinedMembers(roomID)
+}
+
+func (s *Service) Permalink(roomID, eventID string) string {
+	return Permalink(roomID, eventID)
+}
+
 func (s *Service) GetRoomType(ctx context.Context, roomID string) (domain.RoomType, error) {
 	return s.client.GetRoomType(ctx, roomID)
 }
@@ -109,8 +193,8 @@ func (s *Service) CheckAndJoinInvitedRooms(ctx context.Context) error {
 	return s.client.CheckAndJoinInvitedRooms(ctx)
 }
 
-func (s *Service) IsFromAllowedDomain(userID string) bool {
-	return s.client.IsFromAllowedDomain(userID)
+func (s *Service) GetUserPowerLevel(roomID string, userID string) (int, error) {
+	return s.client.GetUserPowerLevel(roomID, userID)
 }
 
 func (s *Service) IsAddressedToBot(content string, roomType domain.RoomType) bool {
@@ -130,6 +214,18 @@ func (s *Service) InviteUser(roomID string, userID string) error {
 	return s.client.InviteUser(roomID, userID)
 }
 
+func (s *Service) RejectInvite(roomID string) error {
+	return s.client.RejectInvite(roomID)
+}
+
+func (s *Service) EnsureDirectRoom(userID string) (string, error) {
+	return s.client.EnsureDirectRoom(userID)
+}
+
+func (s *Service) SendPrompt(roomID string, content string, reactions []string) (string, error) {
+	return s.client.SendPrompt(roomID, content, reactions)
+}
+
 func (s *Service) GetBotUserID() string {
 	return s.client.GetBotUserID()
 }
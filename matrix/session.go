@@ -0,0 +1,56 @@
+package matrix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// session holds the credentials returned by the first password login so
+// later runs can reuse the same device instead of creating a new one.
+type session struct {
+	Homeserver  string `json:"homeserver"`
+	UserID      string `json:"user_id"`
+	DeviceID    string `json:"device_id"`
+	AccessToken string `json:"access_token"`
+}
+
+func loadSession(sessionFile string) (*session, error) {
+	if _, err := os.Stat(sessionFile); os.IsNotExist(err) {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(sessionFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session file: %v", err)
+	}
+	var s session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse session file: %v", err)
+	}
+	return &s, nil
+}
+
+func saveSession(sessionFile string, s *session) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %v", err)
+	}
+	return ioutil.WriteFile(sessionFile, data, 0600)
+}
+
+func clearSession(sessionFile string) error {
+	if err := os.Remove(sessionFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// matches reports whether the stored session belongs to the configured
+// account, so a changed user ID or homeserver forces a fresh login.
+func (s *session) matches(homeserver, userID string) bool {
+	return s != nil &&
+		s.AccessToken != "" &&
+		s.Homeserver == homeserver &&
+		s.UserID == userID
+}
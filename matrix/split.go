@@ -0,0 +1,295 @@
+package matrix
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// partHeaderReserve leaves room for the "(12/34)" header added to each part.
+const partHeaderReserve = 16
+
+// segment is a paragraph or a fenced code block. Code blocks are kept
+// intact where possible because splitting them breaks rendering.
+type segment struct {
+	text     string
+	isCode   bool
+	language string
+}
+
+// codeBlock is a fenced code block taken out of a message so it can be
+// sent as a file instead.
+type codeBlock struct {
+	language string
+	code     string
+}
+
+// splitSegments breaks text into paragraphs at blank lines, treating each
+// fenced code block as a single segment even if it contains blank lines.
+func splitSegments(text string) []segment {
+	var segments []segment
+	var current []string
+	inCode := false
+	language := ""
+
+	flush := func(isCode bool) {
+		joined := strings.Join(current, "\n")
+		if strings.TrimSpace(joined) != "" {
+			segments = append(segments, segment{text: joined, isCode: isCode, language: language})
+		}
+		current = nil
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case !inCode && strings.HasPrefix(trimmed, "```"):
+			flush(false)
+			inCode = true
+			language = strings.TrimSpace(strings.TrimPrefix(trimmed, "```"))
+			current = append(current, line)
+		case inCode && trimmed == "```":
+			current = append(current, line)
+			flush(true)
+			inCode = false
+			language = ""
+		case !inCode && trimmed == "":
+			flush(false)
+		default:
+			current = append(current, line)
+		}
+	}
+	flush(inCode)
+
+	return segments
+}
+
+// code returns the contents of a code segment without its fences. An
+// unterminated block at the end of a message has no closing fence.
+func (seg segment) code() string {
+	lines := strings.Split(seg.text, "\n")[1:]
+	if n := len(lines); n > 0 && strings.TrimSpace(lines[n-1]) == "```" {
+		lines = lines[:n-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// splitMessage splits text into numbered parts of at most maxLen bytes,
+// preferring paragraph and code block boundaries. Text that fits is
+// returned unchanged as a single part.
+func splitMessage(text string, maxLen int) []string {
+	if maxLen <= 0 || len(text) <= maxLen {
+		return []string{text}
+	}
+
+	limit := maxLen - partHeaderReserve
+	if limit < 1 {
+		limit = maxLen
+	}
+
+	var pieces []string
+	for _, seg := range splitSegments(text) {
+		if len(seg.text) <= limit {
+			pieces = append(pieces, seg.text)
+			continue
+		}
+		if seg.isCode {
+			pieces = append(pieces, splitCode(seg, limit)...)
+		} else {
+			pieces = append(pieces, splitLines(seg.text, limit)...)
+		}
+	}
+
+	var parts []string
+	var current string
+	for _, piece := range pieces {
+		if current == "" {
+			current = piece
+			continue
+		}
+		if len(current)+2+len(piece) <= limit {
+			current += "\n\n" + piece
+			continue
+		}
+		parts = append(parts, current)
+		current = piece
+	}
+	if current != "" {
+		parts = append(parts, current)
+	}
+
+	if len(parts) > 1 {
+		for i := range parts {
+			parts[i] = fmt.Sprintf("(%d/%d)\n%s", i+1, len(parts), parts[i])
+		}
+	}
+	return parts
+}
+
+// splitCode splits an oversized code block by lines, re-opening and closing
+// the fence in every chunk so each part still renders as code.
+func splitCode(seg segment, limit int) []string {
+	open := "```" + seg.language
+	closing := "```"
+	inner := limit - len(open) - len(closing) - 2
+	if inner < 1 {
+		inner = limit
+	}
+
+	var chunks []string
+	for _, body := range splitLines(seg.code(), inner) {
+		chunks = append(chunks, open+"\n"+body+"\n"+closing)
+	}
+	return chunks
+}
+
+// splitLines packs whole lines into chunks of at most limit bytes. Lines
+// longer than that are cut at a space, or on a rune boundary if there is
+// no space near the end.
+func splitLines(text string, limit int) []string {
+	var chunks []string
+	var current string
+
+	for _, line := range strings.Split(text, "\n") {
+		for len(line) > limit {
+			if current != "" {
+				chunks = append(chunks, current)
+				current = ""
+			}
+			cut := strings.LastIndex(line[:limit], " ")
+			if cut < limit/2 {
+				cut = limit
+				for cut > 0 && !utf8.RuneStart(line[cut]) {
+					cut--
+				}
+				if cut == 0 {
+					cut = limit
+				}
+			}
+			chunks = append(chunks, strings.TrimRight(line[:cut], " "))
+			line = strings.TrimLeft(line[cut:], " ")
+		}
+
+		switch {
+		case current == "":
+			current = line
+		case len(current)+1+len(line) <= limit:
+			current += "\n" + line
+		default:
+			chunks = append(chunks, current)
+			current = line
+		}
+	}
+	if current != "" {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+// extractCodeBlocks removes fenced code blocks longer than minLen from text
+// and replaces each with a short note naming the file it will be sent as.
+func extractCodeBlocks(text string, minLen int) (string, []codeBlock) {
+	var blocks []codeBlock
+	var kept []string
+
+	for _, seg := range splitSegments(text) {
+		if !seg.isCode || len(seg.text) <= minLen {
+			kept = append(kept, seg.text)
+			continue
+		}
+
+		block := codeBlock{language: seg.language, code: seg.code()}
+		blocks = append(blocks, block)
+		kept = append(kept, fmt.Sprintf("(code attached as %s)", block.fileName(len(blocks))))
+	}
+
+	if len(blocks) == 0 {
+		return text, nil
+	}
+	return strings.Join(kept, "\n\n"), blocks
+}
+
+// codeExtensions maps common fence languages to file extensions.
+var codeExtensions = map[string]string{
+	"bash":       "sh",
+	"c":          "c",
+	"cpp":        "cpp",
+	"csharp":     "cs",
+	"css":        "css",
+	"go":         "go",
+	"html":       "html",
+	"java":       "java",
+	"javascript": "js",
+	"js":         "js",
+	"json":       "json",
+	"kotlin":     "kt",
+	"markdown":   "md",
+	"php":        "php",
+	"python":     "py",
+	"py":         "py",
+	"ruby":       "rb",
+	"rust":       "rs",
+	"sh":         "sh",
+	"shell":      "sh",
+	"sql":        "sql",
+	"swift":      "swift",
+	"toml":       "toml",
+	"ts":         "ts",
+	"typescript": "ts",
+	"xml":        "xml",
+	"yaml":       "yaml",
+	"yml":        "yaml",
+}
+
+// fileName names an attached code block after its language, e.g. go-1.go.
+func (b codeBlock) fileName(n int) string {
+	language := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '+' || r == '#' {
+			return r
+		}
+		return -1
+	}, strings.ToLower(b.language))
+	if language == "" {
+		return fmt.Sprintf("code-%d.txt", n)
+	}
+	ext, ok := codeExtensions[language]
+	if !ok {
+		ext = "txt"
+	}
+	return fmt.Sprintf("%s-%d.%s", language, n, ext)
+}
+
+// summarizeForAttachment returns the opening of a long answer, cut at a
+// paragraph or word boundary, to post alongside the full file.
+func summarizeForAttachment(text string, maxLen int) string {
+	segments := splitSegments(text)
+	var summary string
+	for _, seg := range segments {
+		if seg.isCode {
+			continue
+		}
+		if summary != "" && len(summary)+2+len(seg.text) > maxLen {
+			break
+		}
+		if summary == "" {
+			summary = seg.text
+		} else {
+			summary += "\n\n" + seg.text
+		}
+		if len(summary) >= maxLen/2 {
+			break
+		}
+	}
+
+	if len(summary) > maxLen {
+		cut := strings.LastIndex(summary[:maxLen], " ")
+		if cut <= 0 {
+			cut = maxLen
+			for cut > 0 && !utf8.RuneStart(summary[cut]) {
+				cut--
+			}
+		}
+		summary = summary[:cut] + "…"
+	}
+	return summary
+}
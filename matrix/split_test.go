@@ -0,0 +1,182 @@
+package matrix
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		maxLen int
+		want   []string
+	}{
+		{"fits", "short", 40, []string{"short"}},
+		{"no limit", strings.Repeat("long ", 20), 0, []string{strings.Repeat("long ", 20)}},
+		{
+			"numbered paragraphs",
+			"one two three\n\nfour five six\n\nseven eight", 40,
+			[]string{"(1/3)\none two three", "(2/3)\nfour five six", "(3/3)\nseven eight"},
+		},
+		{
+			"fence reopened in every part",
+			"Intro\n\n```go\nline one\nline two\nline three\n```", 40,
+			[]string{
+				"(1/4)\nIntro",
+				"(2/4)\n```go\nline one\n```",
+				"(3/4)\n```go\nline two\n```",
+				"(4/4)\n```go\nline three\n```",
+			},
+		},
+		{
+			"multi-byte rune at the boundary",
+			"x" + strings.Repeat("ä", 30), 40,
+			[]string{
+				"(1/3)\nx" + strings.Repeat("ä", 11),
+				"(2/3)\n" + strings.Repeat("ä", 12),
+				"(3/3)\n" + strings.Repeat("ä", 7),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitMessage(tt.text, tt.maxLen)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitMessage(%q, %d) = %q, want %q", tt.text, tt.maxLen, got, tt.want)
+			}
+			for _, part := range got {
+				if tt.maxLen > 0 && len(part) > tt.maxLen {
+					t.Errorf("part is %d bytes, over %d: %q", len(part), tt.maxLen, part)
+				}
+				if !utf8.ValidString(part) {
+					t.Errorf("part cuts a rune: %q", part)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"packs whole lines", "a\nb\nc", 3, []string{"a\nb", "c"}},
+		{"long line cut at a space", "aaa bbb ccc", 7, []string{"aaa", "bbb ccc"}},
+		{"long line without spaces", "abcdefgh", 3, []string{"abc", "def", "gh"}},
+		{"never cuts a rune", "ääää", 3, []string{"ä", "ä", "ä", "ä"}},
+		{"long line flushes the pending chunk", "a\nbbbbb", 3, []string{"a", "bbb", "bb"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitLines(tt.text, tt.limit); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitLines(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitCode(t *testing.T) {
+	tests := []struct {
+		name  string
+		seg   segment
+		limit int
+		want  []string
+	}{
+		{
+			"fenced",
+			segment{text: "```go\na\nb\nc\n```", isCode: true, language: "go"},
+			13,
+			[]string{"```go\na\nb\n```", "```go\nc\n```"},
+		},
+		{
+			"unterminated",
+			segment{text: "```\na\nb", isCode: true},
+			9,
+			[]string{"```\na\n```", "```\nb\n```"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitCode(tt.seg, tt.limit); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitCode(%q, %d) = %q, want %q", tt.seg.text, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractCodeBlocks(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		minLen     int
+		wantText   string
+		wantBlocks []codeBlock
+	}{
+		{
+			"large block extracted, small one kept",
+			"Look:\n\n```go\nfunc main() {}\n```\n\n```\nx\n```\n\nDone.", 10,
+			"Look:\n\n(code attached as go-1.go)\n\n```\nx\n```\n\nDone.",
+			[]codeBlock{{language: "go", code: "func main() {}"}},
+		},
+		{
+			"nothing to extract leaves text untouched",
+			"a\n\n\nb\n\n```\nx\n```", 10,
+			"a\n\n\nb\n\n```\nx\n```",
+			nil,
+		},
+		{
+			"unterminated block",
+			"```python\nprint(1)\nprint(2)", 5,
+			"(code attached as python-1.py)",
+			[]codeBlock{{language: "python", code: "print(1)\nprint(2)"}},
+		},
+		{
+			"blocks numbered in order",
+			"```sh\nls -la\n```\n\n```\nsome text\n```", 5,
+			"(code attached as sh-1.sh)\n\n(code attached as code-2.txt)",
+			[]codeBlock{{language: "sh", code: "ls -la"}, {code: "some text"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, blocks := extractCodeBlocks(tt.text, tt.minLen)
+			if text != tt.wantText {
+				t.Errorf("text = %q, want %q", text, tt.wantText)
+			}
+			if !reflect.DeepEqual(blocks, tt.wantBlocks) {
+				t.Errorf("blocks = %+v, want %+v", blocks, tt.wantBlocks)
+			}
+		})
+	}
+}
+
+func TestSummarizeForAttachment(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		maxLen int
+		want   string
+	}{
+		{
+			"skips code, stops at half",
+			"First paragraph.\n\n```\ncode\n```\n\nSecond paragraph.\n\nThird paragraph, which is not needed.", 60,
+			"First paragraph.\n\nSecond paragraph.",
+		},
+		{"stops before overflowing", "aaaa\n\nbbbbbbbbbb", 10, "aaaa"},
+		{"cut at a word", "alpha beta gamma delta", 12, "alpha beta…"},
+		{"cut on a rune boundary", "ääääää", 5, "ää…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summarizeForAttachment(tt.text, tt.maxLen); got != tt.want {
+				t.Errorf("summarizeForAttachment(%q, %d) = %q, want %q", tt.text, tt.maxLen, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package matrix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// storedFilter is the on-disk record of an uploaded sync filter.
+type storedFilter struct {
+	UserID   string `json:"user_id"`
+	FilterID string `json:"filter_id"`
+	Hash     string `json:"hash"`
+}
+
+// fileSyncStore implements mautrix.SyncStore on top of the sync token file
+// and a small filter file, so both survive restarts. The filter ID is only
+// handed out while the stored hash matches the current filter definition.
+type fileSyncStore struct {
+	tokenFile  string
+	filterFile string
+	filterHash string
+
+	mu        sync.Mutex
+	nextBatch string
+	filter    storedFilter
+}
+
+func newFileSyncStore(tokenFile, filterFile, hash string) *fileSyncStore {
+	s := &fileSyncStore{
+		tokenFile:  tokenFile,
+		filterFile: filterFile,
+		filterHash: hash,
+	}
+
+	token, err := loadSyncToken(tokenFile)
+	if err != nil {
+		log.Printf("WARNING: Failed to load sync token: %v", err)
+	}
+	s.nextBatch = token
+
+	filter, err := loadFilter(filterFile)
+	if err != nil {
+		log.Printf("WARNING: Failed to load sync filter: %v", err)
+	} else if filter != nil {
+		s.filter = *filter
+	}
+
+	return s
+}
+
+func (s *fileSyncStore) SaveFilterID(userID id.UserID, filterID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.filter = storedFilter{
+		UserID:   string(userID),
+		FilterID: filterID,
+		Hash:     s.filterHash,
+	}
+	log.Printf("Saving sync filter %s", filterID)
+	if err := saveFilter(s.filterFile, &s.filter); err != nil {
+		log.Printf("WARNING: Failed to save sync filter: %v", err)
+	}
+}
+
+func (s *fileSyncStore) LoadFilterID(userID id.UserID) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.filter.UserID != string(userID) || s.filter.Hash != s.filterHash {
+		return ""
+	}
+	return s.filter.FilterID
+}
+
+func (s *fileSyncStore) SaveNextBatch(userID id.UserID, nextBatchToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.nextBatch == nextBatchToken {
+		return
+	}
+	s.nextBatch = nextBatchToken
+	if err := saveSyncToken(s.tokenFile, nextBatchToken); err != nil {
+		log.Printf("WARNING: Failed to save sync token: %v", err)
+	}
+}
+
+func (s *fileSyncStore) LoadNextBatch(userID id.UserID) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nextBatch
+}
+
+func loadFilter(filterFile string) (*storedFilter, error) {
+	if _, err := os.Stat(filterFile); os.IsNotExist(err) {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(filterFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read filter file: %v", err)
+	}
+	var f storedFilter
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse filter file: %v", err)
+	}
+	return &f, nil
+}
+
+func saveFilter(filterFile string, f *storedFilter) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode filter: %v", err)
+	}
+	return ioutil.WriteFile(filterFile, data, 0600)
+}
@@ -0,0 +1,35 @@
+package matrix
+
+import (
+	"maunium.net/go/mautrix/event"
+)
+
+// addThreadRelation makes content part of the thread rooted at threadID.
+// Clients without thread support see it as a reply to the root.
+func addThreadRelation(content map[string]interface{}, threadID string) {
+	if threadID == "" {
+		return
+	}
+	content["m.relates_to"] = map[string]interface{}{
+		"rel_type":        "m.thread",
+		"event_id":        threadID,
+		"is_falling_back": true,
+		"m.in_reply_to": map[string]interface{}{
+			"event_id": threadID,
+		},
+	}
+}
+
+// ThreadID returns the root of the thread evt belongs to, or "" if it's on
+// the main timeline.
+func ThreadID(evt *event.Event) string {
+	relatesTo, ok := evt.Content.Raw["m.relates_to"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	if relType, _ := relatesTo["rel_type"].(string); relType != "m.thread" {
+		return ""
+	}
+	threadID, _ := relatesTo["event_id"].(string)
+	return threadID
+}
@@ -0,0 +1,353 @@
+// Package redact replaces secrets and personal data in text with
+// placeholders before the text leaves the server.
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Rule is an admin-defined detector from HENRY_REDACT_FILE. If the
+// pattern has a capture group, only the first group is redacted, so
+// `password=(\S+)` keeps the key name visible.
+type Rule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	// Restore puts the original text back into Henry's reply. Leave it
+	// off for anything that must never be posted again.
+	Restore bool `json:"restore,omitempty"`
+}
+
+type detector struct {
+	name    string
+	pattern *regexp.Regexp
+	restore bool
+	valid   func(string) bool
+}
+
+// builtins run in this order, so that a private key block or a JWT is
+// redacted as a whole before narrower patterns look inside it. Secrets are
+// never restored; personal data that came from the room is.
+var builtins = []detector{
+	{
+		name:    "private_key",
+		pattern: regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY(?: BLOCK)?-----[\s\S]*?-----END [A-Z0-9 ]*PRIVATE KEY(?: BLOCK)?-----`),
+	},
+	{
+		name:    "jwt",
+		pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{4,}\.eyJ[A-Za-z0-9_-]{4,}\.[A-Za-z0-9_-]{8,}`),
+	},
+	{
+		name: "api_key",
+		pattern: regexp.MustCompile(`\b(?:` +
+			`sk-ant-[A-Za-z0-9_-]{20,}` +
+			`|sk-(?:proj-)?[A-Za-z0-9_-]{20,}` +
+			`|gh[pousr]_[A-Za-z0-9]{36,}` +
+			`|github_pat_[A-Za-z0-9_]{22,}` +
+			`|glpat-[A-Za-z0-9_-]{20,}` +
+			`|(?:AKIA|ASIA)[0-9A-Z]{16}` +
+			`|xox[abprs]-[A-Za-z0-9-]{10,}` +
+			`|AIza[0-9A-Za-z_-]{35}` +
+			`|syt_[A-Za-z0-9_]+_[A-Za-z0-9]{10,}_[A-Za-z0-9]+` +
+			`)`),
+	},
+	{
+		// Values of obvious key=value secrets, e.g. in config files and logs.
+		name:    "secret",
+		pattern: regexp.MustCompile(`(?i)\b(?:api[_-]?key|access[_-]?token|auth[_-]?token|secret|password|passwd|pwd)["']?\s*[:=]\s*["']?([^\s"',;\[][^\s"',;]{5,})`),
+	},
+	{
+		name:    "bearer",
+		pattern: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9._~+/-]{16,}=*)`),
+	},
+	{
+		name:    "email",
+		pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`),
+		restore: true,
+	},
+	{
+		// Card numbers of the major networks, checked with Luhn. Millisecond
+		// timestamps start with 1 and are left alone.
+		name:    "card",
+		pattern: regexp.MustCompile(`\b[2-6](?:[ -]?\d){12,18}\b`),
+		valid:   validCard,
+	},
+	{
+		name:    "iban",
+		pattern: regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`),
+		restore: true,
+		valid:   validIBAN,
+	},
+	{
+		// International numbers and national ones with a leading zero and
+		// a separator, so dates, times and IDs are left alone.
+		name:    "phone",
+		pattern: regexp.MustCompile(`(?:\+|\b00)[1-9]\d{0,2}(?:[ ./-]?\(?\d{1,5}\)?){2,6}\b|\(?\b0\d{2,5}\)?[ /-]\d{3,}(?:[ -]\d{2,})*\b`),
+		restore: true,
+		valid:   func(s string) bool { return digits(s) >= 8 && digits(s) <= 15 },
+	},
+	{
+		name:    "ip",
+		pattern: regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`),
+		restore: true,
+		valid:   validIPv4,
+	},
+}
+
+var ruleName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Builtins lists the names of the built-in detectors.
+func Builtins() []string {
+	names := make([]string, 0, len(builtins))
+	for _, d := range builtins {
+		names = append(names, d.name)
+	}
+	return names
+}
+
+// Redactor holds the enabled detectors. A nil Redactor redacts nothing.
+type Redactor struct {
+	detectors []detector
+}
+
+// New enables the named built-in detectors, all of them if names is
+// empty and none if it is just "none", followed by the admin rules.
+func New(names []string, rules []Rule) (*Redactor, error) {
+	r := &Redactor{}
+	enabled := make(map[string]bool)
+	switch {
+	case len(names) == 0:
+		for _, d := range builtins {
+			enabled[d.name] = true
+		}
+	case len(names) == 1 && names[0] == "none":
+	default:
+		known := make(map[string]bool)
+		for _, d := range builtins {
+			known[d.name] = true
+		}
+		for _, name := range names {
+			if !known[name] {
+				return nil, fmt.Errorf("unknown redaction detector %q, must be one of %s",
+					name, strings.Join(Builtins(), ", "))
+			}
+			enabled[name] = true
+		}
+	}
+	for _, d := range builtins {
+		if enabled[d.name] {
+			r.detectors = append(r.detectors, d)
+		}
+	}
+
+	for _, rule := range rules {
+		if !ruleName.MatchString(rule.Name) {
+			return nil, fmt.Errorf("redaction rule %q: name must be lowercase letters, digits and underscores", rule.Name)
+		}
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction rule %q: %v", rule.Name, err)
+		}
+		r.detectors = append(r.detectors, detector{name: rule.Name, pattern: pattern, restore: rule.Restore})
+	}
+	return r, nil
+}
+
+// LoadRules reads the admin rules from a JSON file. A missing file means
+// no rules.
+func LoadRules(path string) ([]Rule, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read redaction rules: %v", err)
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse redaction rules: %v", err)
+	}
+	return rules, nil
+}
+
+// Session redacts the texts of one request. The same value always gets
+// the same placeholder within a session, so Claude can still tell that
+// two messages mention the same address.
+type Session struct {
+	r            *Redactor
+	placeholders map[string]string // value -> placeholder
+	values       map[string]string // placeholder -> value
+	restorable   map[string]bool   // placeholder -> restore in replies
+	next         map[string]int
+	counts       map[string]int
+}
+
+// NewSession starts a session.
+func (r *Redactor) NewSession() *Session {
+	return &Session{
+		r:            r,
+		placeholders: make(map[string]string),
+		values:       make(map[string]string),
+		restorable:   make(map[string]bool),
+		next:         make(map[string]int),
+		counts:       make(map[string]int),
+	}
+}
+
+// Redact replaces everything the detectors find with placeholders such as
+// [EMAIL_1].
+func (s *Session) Redact(text string) string {
+	if s == nil || s.r == nil || text == "" {
+		return text
+	}
+	for _, d := range s.r.detectors {
+		text = s.apply(d, text)
+	}
+	return text
+}
+
+func (s *Session) apply(d detector, text string) string {
+	matches := d.pattern.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if len(m) >= 4 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+		value := text[start:end]
+		if d.valid != nil && !d.valid(value) {
+			continue
+		}
+		b.WriteString(text[last:start])
+		b.WriteString(s.placeholder(d, value))
+		last = end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+func (s *Session) placeholder(d detector, value string) string {
+	s.counts[d.name]++
+	if p, ok := s.placeholders[value]; ok {
+		return p
+	}
+	s.next[d.name]++
+	p := fmt.Sprintf("[%s_%d]", strings.ToUpper(d.name), s.next[d.name])
+	s.placeholders[value] = p
+	s.values[p] = value
+	s.restorable[p] = d.restore
+	return p
+}
+
+// Restore puts back the values of placeholders whose detector allows it.
+// Secrets keep their placeholder.
+func (s *Session) Restore(text string) string {
+	if s == nil || len(s.values) == 0 {
+		return text
+	}
+	var pairs []string
+	for p, value := range s.values {
+		if s.restorable[p] {
+			pairs = append(pairs, p, value)
+		}
+	}
+	if len(pairs) == 0 {
+		return text
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// Summary formats the counts for logging, e.g. "email=2 api_key=1".
+func (s *Session) Summary() string {
+	if s == nil || len(s.counts) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(s.counts))
+	for name := range s.counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%d", name, s.counts[name]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func digits(s string) int {
+	n := 0
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			n++
+		}
+	}
+	return n
+}
+
+// validCard runs the Luhn check over the digits.
+func validCard(s string) bool {
+	n := digits(s)
+	if n < 13 || n > 19 {
+		return false
+	}
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// validIBAN checks the length and the mod-97 check digits.
+func validIBAN(s string) bool {
+	s = strings.ReplaceAll(s, " ", "")
+	if len(s) < 15 || len(s) > 34 {
+		return false
+	}
+	rearranged := s[4:] + s[:4]
+	remainder := 0
+	for _, c := range rearranged {
+		switch {
+		case c >= '0' && c <= '9':
+			remainder = (remainder*10 + int(c-'0')) % 97
+		case c >= 'A' && c <= 'Z':
+			remainder = (remainder*100 + int(c-'A') + 10) % 97
+		default:
+			return false
+		}
+	}
+	return remainder == 1
+}
+
+func validIPv4(s string) bool {
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n > 255 || (len(part) > 1 && part[0] == '0') {
+			return false
+		}
+	}
+	return true
+}
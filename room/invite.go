@@ -7,6 +7,7 @@ import (
 
 	"github.com/huhndev/gohenry/config"
 	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/i18n"
 )
 
 // InviteService handles room invitation operations
@@ -29,14 +30,15 @@ func (s *InviteService) InviteUser(
 	createRoom bool,
 ) (string, error) {
 	var targetRoomID string
+	lang := i18n.Language(s.config.Locale)
 
 	if createRoom || roomID == "" {
 		log.Printf("Creating new room and inviting %s", userID)
 
 		var err error
 		targetRoomID, err = s.matrixService.CreateRoom(
-			"Test Room for Bot",
-			"This room is for testing a Matrix bot",
+			i18n.Text(lang, "invite.room_name", nil),
+			i18n.Text(lang, "invite.room_topic", nil),
 			[]string{userID},
 			true,
 		)
@@ -57,7 +59,10 @@ func (s *InviteService) InviteUser(
 		log.Printf("Successfully invited %s to room %s", userID, targetRoomID)
 	}
 
-	welcomeMsg := fmt.Sprintf("Hello %s! This is a test message.", userID)
+	welcomeMsg := i18n.Text(lang, "invite.welcome", i18n.Vars{
+		"user":   userID,
+		"prefix": s.config.CommandPrefix,
+	})
 	if err := s.matrixService.SendMessage(targetRoomID, welcomeMsg); err != nil {
 		log.Printf("Failed to send welcome message: %v", err)
 	} else {
@@ -0,0 +1,304 @@
+package room
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huhndev/gohenry/access"
+	"github.com/huhndev/gohenry/config"
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/i18n"
+	"github.com/huhndev/gohenry/store"
+)
+
+// Invite policy modes, set with HENRY_INVITE_POLICY.
+const (
+	InviteModeOpen      = "open"
+	InviteModeAllowlist = "allowlist"
+	InviteModeAsk       = "ask"
+)
+
+const (
+	approveReaction = "✅"
+	denyReaction    = "❌"
+)
+
+type inviteStatus string
+
+const (
+	invitePending  inviteStatus = "pending"
+	inviteApproved inviteStatus = "approved"
+	inviteDenied   inviteStatus = "denied"
+)
+
+// inviteRecord tracks an invitation and the owner's decision about it.
+type inviteRecord struct {
+	RoomID        string       `json:"room_id"`
+	InviterID     string       `json:"inviter_id"`
+	Status        inviteStatus `json:"status"`
+	PromptEventID string       `json:"prompt_event_id,omitempty"`
+	ReceivedAt    int64        `json:"received_at"`
+	DecidedAt     int64        `json:"decided_at,omitempty"`
+	DecidedBy     string       `json:"decided_by,omitempty"`
+}
+
+// InvitePolicy decides which room invitations Henry accepts. Allowlisted
+// users, servers and rooms are joined right away. Everything else is
+// rejected, or in ask mode sent to the owner for approval by reaction.
+type InvitePolicy struct {
+	matrixService domain.MatrixService
+	config        *config.Config
+	file          *store.File
+
+	mu      sync.Mutex
+	invites map[string]*inviteRecord
+}
+
+func NewInvitePolicy(matrixService domain.MatrixService, cfg *config.Config) *InvitePolicy {
+	p := &InvitePolicy{
+		matrixService: matrixService,
+		config:        cfg,
+		file:          store.NewFile(cfg.DataDir, "invites.json"),
+		invites:       make(map[string]*inviteRecord),
+	}
+	if err := p.file.Load(&p.invites); err != nil {
+		log.Printf("WARNING: Failed to load invite decisions: %v", err)
+	}
+	// A pending invite saved while its prompt was still being sent has no
+	// prompt to react to; dropping it lets the next invite ask again.
+	for roomID, record := range p.invites {
+		if record.Status == invitePending && record.PromptEventID == "" {
+			delete(p.invites, roomID)
+		}
+	}
+	return p
+}
+
+// HandleInvite is called for every invitation the bot receives.
+func (p *InvitePolicy) HandleInvite(ctx context.Context, roomID, inviterID string) {
+	if p.config.InvitePolicy == InviteModeOpen || p.isAllowlisted(roomID, inviterID) {
+		log.Printf("Invite to %s from %s is allowed, joining", roomID, inviterID)
+		p.join(roomID)
+		return
+	}
+
+	// The decision is made under the lock, and a new invite is marked
+	// pending before the owner is asked, so a repeated invite or a sync
+	// replay can't send the owner a second prompt.
+	p.mu.Lock()
+	var status inviteStatus
+	record, known := p.invites[roomID]
+	if known {
+		status = record.Status
+	}
+	ask := !known && p.config.InvitePolicy == InviteModeAsk && p.config.OwnerID != ""
+	if ask {
+		p.invites[roomID] = &inviteRecord{
+			RoomID:     roomID,
+			InviterID:  inviterID,
+			Status:     invitePending,
+			ReceivedAt: time.Now().UnixNano() / 1e6,
+		}
+	}
+	p.mu.Unlock()
+
+	switch {
+	case ask:
+		p.askOwner(roomID, inviterID)
+	case status == inviteApproved:
+		log.Printf("Invite to %s was approved before, joining", roomID)
+		p.join(roomID)
+	case status == inviteDenied:
+		log.Printf("Invite to %s was denied before, rejecting", roomID)
+		p.reject(roomID)
+	case status == invitePending:
+		log.Printf("Invite to %s is already waiting for approval", roomID)
+	default:
+		log.Printf("Rejecting invite to %s from %s: not on the allowlist", roomID, inviterID)
+		p.reject(roomID)
+	}
+}
+
+// HandleReaction resolves a pending invite when the owner reacts to its
+// approval prompt. Reactions from anyone else, or to other events, are
+// ignored.
+func (p *InvitePolicy) HandleReaction(ctx context.Context, roomID, senderID, eventID, key string) {
+	if senderID != p.config.OwnerID {
+		return
+	}
+
+	p.mu.Lock()
+	var record *inviteRecord
+	for _, r := range p.invites {
+		if r.PromptEventID == eventID && r.Status == invitePending {
+			record = r
+			break
+		}
+	}
+	if record == nil {
+		p.mu.Unlock()
+		return
+	}
+
+	switch key {
+	case approveReaction:
+		record.Status = inviteApproved
+	case denyReaction:
+		record.Status = inviteDenied
+	default:
+		p.mu.Unlock()
+		return
+	}
+	record.DecidedAt = time.Now().UnixNano() / 1e6
+	record.DecidedBy = senderID
+	p.persistLocked()
+	status := record.Status
+	inviteRoomID := record.RoomID
+	p.mu.Unlock()
+
+	log.Printf("Owner %s invite to %s", status, inviteRoomID)
+	if status == inviteApproved {
+		p.join(inviteRoomID)
+	} else {
+		p.reject(inviteRoomID)
+	}
+
+	reply := i18n.Text(i18n.Language(p.config.Locale), "invite."+string(status), i18n.Vars{"room": inviteRoomID})
+	if err := p.matrixService.SendMessage(roomID, reply); err != nil {
+		log.Printf("Failed to confirm invite decision: %v", err)
+	}
+}
+
+// Forget removes the user's name from invite records. The decisions
+// themselves belong to the rooms and are kept.
+func (p *InvitePolicy) Forget(userID string) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	removed := 0
+	for _, record := range p.invites {
+		if record.InviterID == userID {
+			record.InviterID = ""
+			removed++
+		}
+		if record.DecidedBy == userID {
+			record.DecidedBy = ""
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	if err := p.file.Save(p.invites); err != nil {
+		return 0, fmt.Errorf("failed to save invite decisions: %v", err)
+	}
+	return removed, nil
+}
+
+// Describe summarises the policy for the debug command.
+func (p *InvitePolicy) Describe() string {
+	desc := fmt.Sprintf("mode=%s", p.config.InvitePolicy)
+	if len(p.config.InviteAllowedUsers) > 0 {
+		desc += fmt.Sprintf(" users=%s", strings.Join(p.config.InviteAllowedUsers, ","))
+	}
+	if len(p.config.InviteAllowedServers) > 0 {
+		desc += fmt.Sprintf(" servers=%s", strings.Join(p.config.InviteAllowedServers, ","))
+	}
+	if len(p.config.InviteAllowedRooms) > 0 {
+		desc += fmt.Sprintf(" rooms=%s", strings.Join(p.config.InviteAllowedRooms, ","))
+	}
+	return desc
+}
+
+func (p *InvitePolicy) isAllowlisted(roomID, inviterID string) bool {
+	if inviterID == p.config.OwnerID {
+		return true
+	}
+	for _, user := range p.config.InviteAllowedUsers {
+		if user == inviterID {
+			return true
+		}
+	}
+
+	if access.MatchesServer(p.config.InviteAllowedServers, inviterID) {
+		return true
+	}
+
+	for _, pattern := range p.config.InviteAllowedRooms {
+		if ok, _ := path.Match(pattern, roomID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// askOwner sends the owner an approval prompt for the pending invite to
+// roomID, or withdraws the invite and rejects it if that fails.
+func (p *InvitePolicy) askOwner(roomID, inviterID string) {
+	ownerRoom, err := p.matrixService.EnsureDirectRoom(p.config.OwnerID)
+	if err != nil {
+		log.Printf("Cannot reach owner about invite to %s, rejecting: %v", roomID, err)
+		p.withdraw(roomID)
+		p.reject(roomID)
+		return
+	}
+
+	prompt := i18n.Text(i18n.Language(p.config.Locale), "invite.ask_owner", i18n.Vars{
+		"inviter": inviterID,
+		"room":    roomID,
+		"approve": approveReaction,
+		"deny":    denyReaction,
+	})
+	eventID, err := p.matrixService.SendPrompt(
+		ownerRoom, prompt, []string{approveReaction, denyReaction},
+	)
+	if err != nil {
+		log.Printf("Failed to ask owner about invite to %s, rejecting: %v", roomID, err)
+		p.withdraw(roomID)
+		p.reject(roomID)
+		return
+	}
+
+	p.mu.Lock()
+	if record := p.invites[roomID]; record != nil && record.Status == invitePending {
+		record.PromptEventID = eventID
+		p.persistLocked()
+	}
+	p.mu.Unlock()
+
+	log.Printf("Asked owner %s to approve invite to %s", p.config.OwnerID, roomID)
+}
+
+// withdraw drops a pending invite whose prompt couldn't be sent, so the
+// next invite to the room asks again.
+func (p *InvitePolicy) withdraw(roomID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if record := p.invites[roomID]; record != nil && record.Status == invitePending {
+		delete(p.invites, roomID)
+	}
+}
+
+func (p *InvitePolicy) join(roomID string) {
+	if err := p.matrixService.JoinRoom(roomID); err != nil {
+		log.Printf("Failed to join room %s: %v", roomID, err)
+		return
+	}
+	log.Printf("Successfully joined room %s", roomID)
+}
+
+func (p *InvitePolicy) reject(roomID string) {
+	if err := p.matrixService.RejectInvite(roomID); err != nil {
+		log.Printf("Failed to reject invite to %s: %v", roomID, err)
+	}
+}
+
+func (p *InvitePolicy) persistLocked() {
+	if err := p.file.Save(p.invites); err != nil {
+		log.Printf("WARNING: Failed to save invite decisions: %v", err)
+	}
+}
@@ -0,0 +1,407 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/huhndev/gohenry/store"
+)
+
+// Clock tells the scheduler the time. Tests can replace SystemClock with a
+// fake one to fire jobs without waiting.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the real wall clock.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time                         { return time.Now() }
+func (SystemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Job is a persisted unit of scheduled work. Kind selects the handler that
+// runs it; the other fields are for the handler to interpret.
+type Job struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"`
+	Due      time.Time `json:"due"`
+	RoomID   string    `json:"room_id,omitempty"`
+	ThreadID string    `json:"thread_id,omitempty"`
+	UserID   string    `json:"user_id,omitempty"`
+	Text     string    `json:"text,omitempty"`
+	// Ref ties the job to state of the handler, such as one run of a
+	// standup.
+	Ref string `json:"ref,omitempty"`
+	// Timezone is the IANA zone the job was scheduled in.
+	Timezone string `json:"timezone,omitempty"`
+	// Cron makes the job recurring. After each run it's due again at the
+	// next match, evaluated in Timezone.
+	Cron      string    `json:"cron,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// FiredAt is set once the job ran. Fired jobs are kept for
+	// firedRetention, so they can still be snoozed.
+	FiredAt  time.Time `json:"fired_at,omitempty"`
+	Attempts int       `json:"attempts,omitempty"`
+}
+
+// Pending reports whether the job still has to run.
+func (j *Job) Pending() bool {
+	return j.FiredAt.IsZero()
+}
+
+// Handler runs a due job. A returned error schedules a retry.
+type Handler func(ctx context.Context, job Job) error
+
+// FailureHandler is told about jobs that failed on every attempt.
+type FailureHandler func(ctx context.Context, job Job, err error)
+
+// NextRun returns when a recurring job is due after t, or the zero time
+// if the job doesn't recur.
+func (j *Job) NextRun(t time.Time) (time.Time, error) {
+	if j.Cron == "" {
+		return time.Time{}, nil
+	}
+	cron, err := ParseCron(j.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc := time.Local
+	if j.Timezone != "" {
+		if loc, err = time.LoadLocation(j.Timezone); err != nil {
+			return time.Time{}, fmt.Errorf("invalid time zone %q: %v", j.Timezone, err)
+		}
+	}
+	next := cron.Next(t.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("cron schedule %q never matches", j.Cron)
+	}
+	return next, nil
+}
+
+const (
+	// maxAttempts is how often a failing job is tried before giving up.
+	maxAttempts = 5
+	// retryDelay is the wait after the first failure; it doubles each time.
+	retryDelay = time.Minute
+	// firedRetention is how long fired jobs are kept.
+	firedRetention = 24 * time.Hour
+)
+
+// state is the format of scheduler.json.
+type state struct {
+	NextID int             `json:"next_id"`
+	Jobs   map[string]*Job `json:"jobs"`
+}
+
+// Scheduler runs jobs at their due time. Jobs are saved on every change,
+// so they survive restarts; jobs that fell due while Henry was down run
+// right after startup.
+type Scheduler struct {
+	clock Clock
+	file  *store.File
+
+	mu        sync.Mutex
+	state     state
+	handlers  map[string]Handler
+	onFailure map[string]FailureHandler
+	wake      chan struct{}
+}
+
+func New(dataDir string, clock Clock) *Scheduler {
+	s := &Scheduler{
+		clock:     clock,
+		file:      store.NewFile(dataDir, "scheduler.json"),
+		state:     state{NextID: 1, Jobs: make(map[string]*Job)},
+		handlers:  make(map[string]Handler),
+		onFailure: make(map[string]FailureHandler),
+		wake:      make(chan struct{}, 1),
+	}
+	if err := s.file.Load(&s.state); err != nil {
+		log.Printf("WARNING: Failed to load scheduled jobs: %v", err)
+	}
+	if s.state.Jobs == nil {
+		s.state.Jobs = make(map[string]*Job)
+	}
+	return s
+}
+
+// Now returns the scheduler clock's time.
+func (s *Scheduler) Now() time.Time {
+	return s.clock.Now()
+}
+
+// Handle registers the handler for a job kind.
+func (s *Scheduler) Handle(kind string, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[kind] = handler
+}
+
+// OnFailure registers a handler for jobs of a kind that ran out of
+// retries.
+func (s *Scheduler) OnFailure(kind string, handler FailureHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onFailure[kind] = handler
+}
+
+// Add stores a new job and returns it with its ID filled in. A recurring
+// job without a due time is due at its first match.
+func (s *Scheduler) Add(job Job) (Job, error) {
+	next, err := job.NextRun(s.clock.Now())
+	if err != nil {
+		return Job{}, err
+	}
+	if job.Due.IsZero() {
+		job.Due = next
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.ID = strconv.Itoa(s.state.NextID)
+	s.state.NextID++
+	job.CreatedAt = s.clock.Now()
+	s.state.Jobs[job.ID] = &job
+
+	if err := s.saveLocked(); err != nil {
+		delete(s.state.Jobs, job.ID)
+		return Job{}, err
+	}
+	s.notify()
+	return job, nil
+}
+
+// Get returns the job with the given ID.
+func (s *Scheduler) Get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.state.Jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// List returns the jobs matching filter, soonest first.
+func (s *Scheduler) List(filter func(Job) bool) []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var jobs []Job
+	for _, job := range s.state.Jobs {
+		if filter == nil || filter(*job) {
+			jobs = append(jobs, *job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Due.Before(jobs[j].Due) })
+	return jobs
+}
+
+// Cancel removes a job.
+func (s *Scheduler) Cancel(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.state.Jobs[id]
+	if !ok {
+		return fmt.Errorf("no job %s", id)
+	}
+	delete(s.state.Jobs, id)
+	if err := s.saveLocked(); err != nil {
+		s.state.Jobs[id] = job
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// Forget removes every job of the user, such as their reminders and the
+// schedules they created, and returns how many there were.
+func (s *Scheduler) Forget(userID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for id, job := range s.state.Jobs {
+		if job.UserID == userID {
+			delete(s.state.Jobs, id)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	if err := s.saveLocked(); err != nil {
+		return 0, err
+	}
+	s.notify()
+	return removed, nil
+}
+
+// Reschedule moves a pending or already fired job to a new due time.
+func (s *Scheduler) Reschedule(id string, due time.Time) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.state.Jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("no job %s", id)
+	}
+	previous := *job
+	job.Due = due
+	job.FiredAt = time.Time{}
+	job.Attempts = 0
+	if err := s.saveLocked(); err != nil {
+		*job = previous
+		return Job{}, err
+	}
+	s.notify()
+	return *job, nil
+}
+
+// Run fires due jobs until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	log.Printf("Scheduler started with %d jobs", len(s.List(nil)))
+	for {
+		s.runDue(ctx)
+
+		wait := time.Hour
+		if next, ok := s.nextDue(); ok {
+			if wait = next.Sub(s.clock.Now()); wait < 0 {
+				wait = 0
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Scheduler stopped")
+			return
+		case <-s.wake:
+		case <-s.clock.After(wait):
+		}
+	}
+}
+
+// runDue runs every pending job whose due time has passed and prunes old
+// fired jobs.
+func (s *Scheduler) runDue(ctx context.Context) {
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	var due []Job
+	pruned := false
+	for id, job := range s.state.Jobs {
+		if job.Pending() && !job.Due.After(now) {
+			due = append(due, *job)
+		}
+		if !job.Pending() && now.Sub(job.FiredAt) > firedRetention {
+			delete(s.state.Jobs, id)
+			pruned = true
+		}
+	}
+	if pruned {
+		if err := s.saveLocked(); err != nil {
+			log.Printf("WARNING: Failed to save scheduled jobs: %v", err)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(due, func(i, j int) bool { return due[i].Due.Before(due[j].Due) })
+	for _, job := range due {
+		s.fire(ctx, job)
+	}
+}
+
+func (s *Scheduler) fire(ctx context.Context, job Job) {
+	s.mu.Lock()
+	handler := s.handlers[job.Kind]
+	onFailure := s.onFailure[job.Kind]
+	s.mu.Unlock()
+
+	var err error
+	if handler == nil {
+		err = fmt.Errorf("no handler for job kind %q", job.Kind)
+	} else {
+		log.Printf("Running %s job %s", job.Kind, job.ID)
+		err = handler(ctx, job)
+	}
+
+	s.mu.Lock()
+	stored, ok := s.state.Jobs[job.ID]
+	if !ok || !stored.Due.Equal(job.Due) {
+		// Cancelled or rescheduled while running.
+		s.mu.Unlock()
+		return
+	}
+
+	gaveUp := false
+	now := s.clock.Now()
+	if err != nil {
+		stored.Attempts++
+		if stored.Attempts >= maxAttempts {
+			log.Printf("Giving up on %s job %s after %d attempts: %v", job.Kind, job.ID, stored.Attempts, err)
+			gaveUp = true
+		} else {
+			delay := retryDelay << uint(stored.Attempts-1)
+			log.Printf("%s job %s failed, retrying in %s: %v", job.Kind, job.ID, delay, err)
+			stored.Due = now.Add(delay)
+		}
+	}
+
+	if err == nil || gaveUp {
+		stored.Attempts = 0
+		stored.FiredAt = now
+		if next, nextErr := stored.NextRun(now); nextErr != nil {
+			log.Printf("Cannot reschedule %s job %s: %v", job.Kind, job.ID, nextErr)
+		} else if !next.IsZero() {
+			stored.Due = next
+			stored.FiredAt = time.Time{}
+		}
+	}
+
+	if err := s.saveLocked(); err != nil {
+		log.Printf("WARNING: Failed to save scheduled jobs: %v", err)
+	}
+	failed := *stored
+	s.mu.Unlock()
+
+	if gaveUp && onFailure != nil {
+		onFailure(ctx, failed, err)
+	}
+}
+
+func (s *Scheduler) nextDue() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	found := false
+	for _, job := range s.state.Jobs {
+		if job.Pending() && (!found || job.Due.Before(next)) {
+			next, found = job.Due, true
+		}
+	}
+	return next, found
+}
+
+// notify wakes Run so it picks up changed due times.
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) saveLocked() error {
+	if err := s.file.Save(s.state); err != nil {
+		return fmt.Errorf("failed to save scheduled jobs: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when the test advances it.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock and fires the timers that are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiting
+}
+
+func newTestScheduler(t *testing.T) (*Scheduler, *fakeClock, string) {
+	dir := t.TempDir()
+	clock := newFakeClock()
+	return New(dir, clock), clock, dir
+}
+
+func TestDueJobFires(t *testing.T) {
+	s, clock, _ := newTestScheduler(t)
+	fired := make(chan Job, 1)
+	s.Handle("test", func(ctx context.Context, job Job) error {
+		fired <- job
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	job, err := s.Add(Job{Kind: "test", Due: clock.Now().Add(time.Hour), Text: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(59 * time.Minute)
+	select {
+	case <-fired:
+		t.Fatal("job fired before it was due")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(time.Minute)
+	select {
+	case got := <-fired:
+		if got.ID != job.ID || got.Text != "hello" {
+			t.Errorf("fired %+v, want job %s", got, job.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("due job didn't fire")
+	}
+
+	// Run's goroutine records the fired job after the handler returns.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if stored, _ := s.Get(job.ID); !stored.Pending() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("fired job is still pending")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFailedJobRetriesWithBackoff(t *testing.T) {
+	s, clock, _ := newTestScheduler(t)
+	runs := 0
+	s.Handle("test", func(ctx context.Context, job Job) error {
+		runs++
+		return errors.New("homeserver unavailable")
+	})
+	var failed []Job
+	s.OnFailure("test", func(ctx context.Context, job Job, err error) {
+		failed = append(failed, job)
+	})
+	s.OnFailure("other", func(ctx context.Context, job Job, err error) {
+		t.Errorf("failure of a %s job reported to the other kind's handler", job.Kind)
+	})
+
+	job, err := s.Add(Job{Kind: "test", Due: clock.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for attempt := 1; attempt < maxAttempts; attempt++ {
+		s.runDue(ctx)
+		if runs != attempt {
+			t.Fatalf("attempt %d: handler ran %d times", attempt, runs)
+		}
+		stored, _ := s.Get(job.ID)
+		delay := retryDelay << uint(attempt-1)
+		if want := clock.Now().Add(delay); !stored.Due.Equal(want) {
+			t.Fatalf("attempt %d: retry due %s, want %s", attempt, stored.Due, want)
+		}
+		if stored.Attempts != attempt || !stored.Pending() {
+			t.Fatalf("attempt %d: stored %+v", attempt, stored)
+		}
+
+		clock.Advance(delay - time.Second)
+		s.runDue(ctx)
+		if runs != attempt {
+			t.Fatalf("attempt %d: retried %s early", attempt, time.Second)
+		}
+		clock.Advance(time.Second)
+	}
+
+	s.runDue(ctx)
+	if runs != maxAttempts {
+		t.Fatalf("handler ran %d times, want %d", runs, maxAttempts)
+	}
+	if len(failed) != 1 || failed[0].ID != job.ID {
+		t.Fatalf("failure handler got %+v", failed)
+	}
+	if stored, _ := s.Get(job.ID); stored.Pending() {
+		t.Error("job is still pending after giving up")
+	}
+}
+
+func TestJobsSurviveRestart(t *testing.T) {
+	s, clock, dir := newTestScheduler(t)
+	due, err := s.Add(Job{Kind: "test", Due: clock.Now().Add(time.Hour), RoomID: "!room:example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	later, err := s.Add(Job{Kind: "test", Due: clock.Now().Add(48 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Henry is down while the first job falls due.
+	clock.Advance(2 * time.Hour)
+	restarted := New(dir, clock)
+	var ran []string
+	restarted.Handle("test", func(ctx context.Context, job Job) error {
+		ran = append(ran, job.ID)
+		return nil
+	})
+
+	if got := restarted.List(nil); len(got) != 2 || got[0].RoomID != "!room:example.org" {
+		t.Fatalf("reloaded jobs = %+v", got)
+	}
+	restarted.runDue(context.Background())
+	if len(ran) != 1 || ran[0] != due.ID {
+		t.Fatalf("after restart ran %v, want only %s", ran, due.ID)
+	}
+
+	next, err := restarted.Add(Job{Kind: "test", Due: clock.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID == due.ID || next.ID == later.ID {
+		t.Errorf("job ID %s reused after restart", next.ID)
+	}
+}
+
+func TestSnoozeAndCancel(t *testing.T) {
+	s, clock, _ := newTestScheduler(t)
+	ran := make(map[string]int)
+	s.Handle("test", func(ctx context.Context, job Job) error {
+		ran[job.ID]++
+		return nil
+	})
+	ctx := context.Background()
+
+	snoozed, err := s.Add(Job{Kind: "test", Due: clock.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelled, err := s.Add(Job{Kind: "test", Due: clock.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.runDue(ctx)
+	if ran[snoozed.ID] != 1 {
+		t.Fatal("due job didn't fire")
+	}
+
+	// A fired job can be snoozed and fires again.
+	if _, err := s.Reschedule(snoozed.ID, clock.Now().Add(10*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if job, _ := s.Get(snoozed.ID); !job.Pending() {
+		t.Error("snoozed job isn't pending")
+	}
+	if err := s.Cancel(cancelled.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(5 * time.Minute)
+	s.runDue(ctx)
+	if ran[snoozed.ID] != 1 || ran[cancelled.ID] != 0 {
+		t.Fatalf("after 5m ran %v", ran)
+	}
+
+	clock.Advance(5 * time.Minute)
+	s.runDue(ctx)
+	if ran[snoozed.ID] != 2 {
+		t.Errorf("snoozed job ran %d times, want 2", ran[snoozed.ID])
+	}
+	if ran[cancelled.ID] != 0 {
+		t.Error("cancelled job ran")
+	}
+	if _, ok := s.Get(cancelled.ID); ok {
+		t.Error("cancelled job is still stored")
+	}
+	if err := s.Cancel(cancelled.ID); err == nil {
+		t.Error("cancelling twice succeeded")
+	}
+}
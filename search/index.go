@@ -0,0 +1,309 @@
+// Package search keeps a local full-text index of room messages, ranked
+// with BM25.
+package search
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huhndev/gohenry/domain"
+	"github.com/huhndev/gohenry/knowledge"
+	"github.com/huhndev/gohenry/store"
+)
+
+// saveDelay batches saves, since messages arrive one by one.
+const saveDelay = 30 * time.Second
+
+// Entry is an indexed message.
+type Entry struct {
+	ID         string `json:"id"`
+	SenderID   string `json:"sender_id"`
+	SenderName string `json:"sender_name,omitempty"`
+	Body       string `json:"body"`
+	Timestamp  int64  `json:"ts"`
+
+	terms map[string]int
+	size  int
+}
+
+// Hit is an entry that matched a search.
+type Hit struct {
+	Entry
+	RoomID string
+	Score  float64
+}
+
+// room is one room's messages, oldest first.
+type room struct {
+	Messages []*Entry `json:"messages"`
+	// Backfilled is set once the room's history was fetched.
+	Backfilled bool `json:"backfilled,omitempty"`
+
+	ids map[string]bool
+}
+
+// Index holds up to maxPerRoom messages per room in search_index.json.
+// Changes are saved at most every saveDelay.
+type Index struct {
+	file       *store.File
+	maxPerRoom int
+
+	mu        sync.RWMutex
+	rooms     map[string]*room
+	saveTimer *time.Timer
+}
+
+func NewIndex(dataDir string, maxPerRoom int) *Index {
+	idx := &Index{
+		file:       store.NewFile(dataDir, "search_index.json"),
+		maxPerRoom: maxPerRoom,
+		rooms:      make(map[string]*room),
+	}
+	if err := idx.file.Load(&idx.rooms); err != nil {
+		log.Printf("WARNING: Failed to load search index: %v", err)
+	}
+	if idx.rooms == nil {
+		idx.rooms = make(map[string]*room)
+	}
+	for _, r := range idx.rooms {
+		r.ids = make(map[string]bool, len(r.Messages))
+		for _, e := range r.Messages {
+			r.ids[e.ID] = true
+			e.analyze()
+		}
+	}
+	return idx
+}
+
+// Add indexes a message from sync.
+func (idx *Index) Add(msg *domain.Message) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.addLocked(msg) {
+		idx.trimLocked(msg.RoomID)
+		idx.scheduleSaveLocked()
+	}
+}
+
+// Backfill indexes a room's history and marks the room as backfilled.
+func (idx *Index) Backfill(roomID string, messages []*domain.Message) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, msg := range messages {
+		idx.addLocked(msg)
+	}
+	idx.roomLocked(roomID).Backfilled = true
+	idx.trimLocked(roomID)
+	idx.scheduleSaveLocked()
+}
+
+// Backfilled reports whether the room's history was indexed.
+func (idx *Index) Backfilled(roomID string) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	r, ok := idx.rooms[roomID]
+	return ok && r.Backfilled
+}
+
+// Remove drops a message, for example after it was redacted, and reports
+// whether it was indexed.
+func (idx *Index) Remove(roomID, eventID string) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	r, ok := idx.rooms[roomID]
+	if !ok || !r.ids[eventID] {
+		return false
+	}
+	for i, e := range r.Messages {
+		if e.ID == eventID {
+			r.Messages = append(r.Messages[:i], r.Messages[i+1:]...)
+			break
+		}
+	}
+	delete(r.ids, eventID)
+	idx.scheduleSaveLocked()
+	return true
+}
+
+// Forget removes every message of the sender and returns how many there
+// were.
+func (idx *Index) Forget(senderID string) int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	removed := 0
+	for _, r := range idx.rooms {
+		kept := r.Messages[:0]
+		for _, e := range r.Messages {
+			if e.SenderID == senderID {
+				delete(r.ids, e.ID)
+				removed++
+				continue
+			}
+			kept = append(kept, e)
+		}
+		r.Messages = kept
+	}
+	if removed > 0 {
+		idx.scheduleSaveLocked()
+	}
+	return removed
+}
+
+// Flush saves pending changes right away.
+func (idx *Index) Flush() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.saveTimer != nil {
+		idx.saveTimer.Stop()
+		idx.saveTimer = nil
+	}
+	if err := idx.file.Save(idx.rooms); err != nil {
+		return fmt.Errorf("failed to save search index: %v", err)
+	}
+	return nil
+}
+
+// Search ranks the messages of the given rooms against query and returns
+// the best hits. rooms maps each room ID to the oldest timestamp to
+// consider in it, 0 for all. If from is set, only messages whose sender ID
+// or display name contains it are considered.
+func (idx *Index) Search(rooms map[string]int64, query, from string, limit int) []Hit {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	from = strings.ToLower(from)
+	var candidates []Hit
+	total := 0
+	for roomID, since := range rooms {
+		r, ok := idx.rooms[roomID]
+		if !ok {
+			continue
+		}
+		for _, e := range r.Messages {
+			if e.Timestamp < since {
+				continue
+			}
+			if from != "" &&
+				!strings.Contains(strings.ToLower(e.SenderID), from) &&
+				!strings.Contains(strings.ToLower(e.SenderName), from) {
+				continue
+			}
+			candidates = append(candidates, Hit{Entry: *e, RoomID: roomID})
+			total += e.size
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	avgLength := float64(total) / float64(len(candidates))
+
+	seen := make(map[string]bool)
+	for _, term := range knowledge.Tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		df := 0
+		for _, c := range candidates {
+			if c.terms[term] > 0 {
+				df++
+			}
+		}
+		for i := range candidates {
+			c := &candidates[i]
+			c.Score += knowledge.TermScore(c.terms[term], df, len(candidates), c.size, avgLength)
+		}
+	}
+
+	var hits []Hit
+	for _, c := range candidates {
+		if c.Score > 0 {
+			hits = append(hits, c)
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Timestamp > hits[j].Timestamp
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+func (idx *Index) addLocked(msg *domain.Message) bool {
+	if msg.Content == "" || msg.ID == "" {
+		return false
+	}
+	r := idx.roomLocked(msg.RoomID)
+	if r.ids[msg.ID] {
+		return false
+	}
+	e := &Entry{
+		ID:         msg.ID,
+		SenderID:   msg.SenderID,
+		SenderName: msg.SenderName,
+		Body:       msg.Content,
+		Timestamp:  msg.Timestamp,
+	}
+	e.analyze()
+	r.ids[e.ID] = true
+	r.Messages = append(r.Messages, e)
+	return true
+}
+
+func (idx *Index) roomLocked(roomID string) *room {
+	r, ok := idx.rooms[roomID]
+	if !ok {
+		r = &room{ids: make(map[string]bool)}
+		idx.rooms[roomID] = r
+	}
+	return r
+}
+
+// trimLocked keeps the room's messages sorted and drops the oldest beyond
+// maxPerRoom.
+func (idx *Index) trimLocked(roomID string) {
+	r := idx.rooms[roomID]
+	older := func(i, j int) bool { return r.Messages[i].Timestamp < r.Messages[j].Timestamp }
+	if !sort.SliceIsSorted(r.Messages, older) {
+		sort.SliceStable(r.Messages, older)
+	}
+	if idx.maxPerRoom <= 0 || len(r.Messages) <= idx.maxPerRoom {
+		return
+	}
+	for _, e := range r.Messages[:len(r.Messages)-idx.maxPerRoom] {
+		delete(r.ids, e.ID)
+	}
+	r.Messages = append([]*Entry(nil), r.Messages[len(r.Messages)-idx.maxPerRoom:]...)
+}
+
+func (idx *Index) scheduleSaveLocked() {
+	if idx.saveTimer != nil {
+		return
+	}
+	idx.saveTimer = time.AfterFunc(saveDelay, func() {
+		idx.mu.Lock()
+		idx.saveTimer = nil
+		err := idx.file.Save(idx.rooms)
+		idx.mu.Unlock()
+		if err != nil {
+			log.Printf("WARNING: Failed to save search index: %v", err)
+		}
+	})
+}
+
+func (e *Entry) analyze() {
+	terms := knowledge.Tokenize(e.SenderName + " " + e.Body)
+	e.terms = make(map[string]int, len(terms))
+	for _, t := range terms {
+		e.terms[t]++
+	}
+	e.size = len(terms)
+}
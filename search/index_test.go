@@ -0,0 +1,155 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/huhndev/gohenry/domain"
+)
+
+const (
+	roomA = "!a:example.org"
+	roomB = "!b:example.org"
+	alice = "@alice:example.org"
+	bob   = "@bob:example.org"
+)
+
+func message(roomID, id, sender, body string, ts int64) *domain.Message {
+	return &domain.Message{ID: id, RoomID: roomID, SenderID: sender, Content: body, Timestamp: ts}
+}
+
+func hitIDs(hits []Hit) string {
+	ids := make([]string, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+	return strings.Join(ids, " ")
+}
+
+func sortedIDs(hits []Hit) string {
+	ids := strings.Fields(hitIDs(hits))
+	sort.Strings(ids)
+	return strings.Join(ids, " ")
+}
+
+func TestSearchRanksByBM25(t *testing.T) {
+	idx := NewIndex(t.TempDir(), 0)
+	idx.Add(message(roomA, "$once", alice, "the database migration is planned for next week, see the wiki", 1))
+	idx.Add(message(roomA, "$twice", bob, "migration migration", 2))
+	idx.Add(message(roomA, "$other", bob, "lunch at noon?", 3))
+
+	hits := idx.Search(map[string]int64{roomA: 0}, "migration", "", 10)
+	if got := hitIDs(hits); got != "$twice $once" {
+		t.Errorf("hits = %q, want the shorter message with more matches first", got)
+	}
+	if hits[0].RoomID != roomA || hits[0].Score <= hits[1].Score {
+		t.Errorf("hits = %+v", hits)
+	}
+	if hits := idx.Search(map[string]int64{roomA: 0}, "migration", "", 1); hitIDs(hits) != "$twice" {
+		t.Errorf("limit 1 returned %q", hitIDs(hits))
+	}
+	if hits := idx.Search(map[string]int64{roomA: 0}, "kubernetes", "", 10); len(hits) != 0 {
+		t.Errorf("unmatched query returned %q", hitIDs(hits))
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	idx := NewIndex(t.TempDir(), 0)
+	idx.Add(message(roomA, "$old", alice, "release notes", 100))
+	idx.Add(message(roomA, "$new", bob, "release tomorrow", 200))
+	idx.Add(&domain.Message{ID: "$named", RoomID: roomA, SenderID: "@c:example.org", SenderName: "Carol",
+		Content: "release party", Timestamp: 300})
+	idx.Add(message(roomB, "$elsewhere", alice, "release date", 150))
+
+	tests := []struct {
+		name  string
+		rooms map[string]int64
+		from  string
+		want  string
+	}{
+		{"one room", map[string]int64{roomA: 0}, "", "$named $new $old"},
+		{"since", map[string]int64{roomA: 150}, "", "$named $new"},
+		{"per-room since", map[string]int64{roomA: 250, roomB: 0}, "", "$elsewhere $named"},
+		{"from user ID", map[string]int64{roomA: 0, roomB: 0}, "alice", "$elsewhere $old"},
+		{"from display name", map[string]int64{roomA: 0}, "carol", "$named"},
+		{"unknown room", map[string]int64{"!none:example.org": 0}, "", ""},
+	}
+	for _, tt := range tests {
+		if got := sortedIDs(idx.Search(tt.rooms, "release", tt.from, 10)); got != tt.want {
+			t.Errorf("%s: hits = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIndexCapsRooms(t *testing.T) {
+	idx := NewIndex(t.TempDir(), 2)
+	idx.Add(message(roomA, "$3", alice, "note three", 3))
+	idx.Add(message(roomA, "$1", alice, "note one", 1))
+	idx.Add(message(roomA, "$2", alice, "note two", 2))
+	idx.Add(message(roomA, "$2", alice, "note two", 2))
+	idx.Backfill(roomA, []*domain.Message{message(roomA, "$0", alice, "note zero", 0)})
+
+	if got := hitIDs(idx.Search(map[string]int64{roomA: 0}, "note", "", 10)); got != "$3 $2" {
+		t.Errorf("kept %q, want the two newest", got)
+	}
+	// A trimmed message can be indexed again, e.g. by a later backfill.
+	idx.rooms[roomA].Messages = idx.rooms[roomA].Messages[:0]
+	idx.Add(message(roomA, "$1", alice, "note one", 1))
+	if got := hitIDs(idx.Search(map[string]int64{roomA: 0}, "note", "", 10)); got != "$1" {
+		t.Errorf("after re-adding = %q", got)
+	}
+}
+
+func TestIndexForgetAndRemove(t *testing.T) {
+	idx := NewIndex(t.TempDir(), 0)
+	idx.Add(message(roomA, "$a1", alice, "secret plan", 1))
+	idx.Add(message(roomB, "$a2", alice, "secret plan b", 2))
+	idx.Add(message(roomA, "$b1", bob, "secret lunch", 3))
+	idx.Add(message(roomA, "$b2", bob, "secret dinner", 4))
+	rooms := map[string]int64{roomA: 0, roomB: 0}
+
+	if n := idx.Forget(alice); n != 2 {
+		t.Errorf("Forget removed %d, want 2", n)
+	}
+	if n := idx.Forget(alice); n != 0 {
+		t.Errorf("second Forget removed %d", n)
+	}
+	if !idx.Remove(roomA, "$b1") {
+		t.Error("Remove didn't find an indexed message")
+	}
+	if idx.Remove(roomA, "$b1") || idx.Remove(roomB, "$b2") {
+		t.Error("Remove reported a message that isn't indexed")
+	}
+	if got := hitIDs(idx.Search(rooms, "secret", "", 10)); got != "$b2" {
+		t.Errorf("left %q, want only $b2", got)
+	}
+}
+
+func TestIndexLoadRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	idx := NewIndex(dir, 0)
+	idx.Add(&domain.Message{ID: "$1", RoomID: roomA, SenderID: alice, SenderName: "Alice",
+		Content: "deploy checklist", Timestamp: 1})
+	idx.Backfill(roomB, nil)
+	if err := idx.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewIndex(dir, 0)
+	hits := loaded.Search(map[string]int64{roomA: 0}, "checklist", "", 10)
+	if len(hits) != 1 || hits[0].SenderName != "Alice" || hits[0].Body != "deploy checklist" {
+		t.Fatalf("loaded hits = %+v", hits)
+	}
+	if hits := loaded.Search(map[string]int64{roomA: 0}, "alice", "", 10); len(hits) != 1 {
+		t.Error("display name isn't searchable after loading")
+	}
+	if !loaded.Backfilled(roomB) || loaded.Backfilled(roomA) {
+		t.Error("backfill flags weren't kept")
+	}
+	// IDs are rebuilt, so a replayed message isn't indexed twice.
+	loaded.Add(message(roomA, "$1", alice, "deploy checklist", 1))
+	if hits := loaded.Search(map[string]int64{roomA: 0}, "checklist", "", 10); len(hits) != 1 {
+		t.Errorf("replayed message indexed again: %d hits", len(hits))
+	}
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// File persists a single JSON document on disk. Writes go to a temporary
+// file first and are renamed into place, so a crash never leaves a
+// half-written document behind.
+type File struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFile returns a File for name inside dir. The directory is created on
+// the first save.
+func NewFile(dir, name string) *File {
+	return &File{path: filepath.Join(dir, name)}
+}
+
+func (f *File) Path() string {
+	return f.path
+}
+
+// Load decodes the stored document into v. A missing file is not an error
+// and leaves v untouched.
+func (f *File) Load(v interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", f.path, err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", f.path, err)
+	}
+	return nil
+}
+
+// Save encodes v and atomically replaces the stored document.
+func (f *File) Save(v interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %v", f.path, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0700); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", f.path, err)
+	}
+	return nil
+}